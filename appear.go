@@ -0,0 +1,102 @@
+package watch
+
+import "sync/atomic"
+
+// An ExistingNotifier is a Node that chooses for itself whether the first
+// Scan after it is registered notifies it of those of its paths that exist,
+// as NotifyExisting does for every node.
+type ExistingNotifier interface {
+	Node
+
+	// NotifyExisting reports whether the node is notified of its existing
+	// paths by its first Scan.
+	NotifyExisting() bool
+}
+
+// notifyExisting reports whether the first Scan of node reports its
+// existing paths.
+func (w *Watcher) notifyExisting(node Node) bool {
+	if n, ok := node.(ExistingNotifier); ok {
+		return n.NotifyExisting()
+	}
+	if notify, ok := w.batchNotify[node]; ok {
+		return notify
+	}
+	return w.NotifyExisting
+}
+
+// AppearNode is a Node that waits for a file that may not exist yet, such as
+// a socket or a file written by another process, and calls Appeared exactly
+// once, on the first Scan that sees it appear. It then stops watching the
+// path.
+//
+// A file appears when a Scan finds it created, or renamed into place, after
+// an earlier Scan found it missing. A file that already exists at the first
+// Scan after the node is registered, because it was created before Register
+// or between Register and that Scan, has not been seen to appear and is only
+// reported if Existing is set; otherwise Appeared is called once it has been
+// removed and created again. If Appeared returns an error, it is called
+// again when the node is retried under the Watcher's UpdateRetry policy, and
+// on the next appearance.
+//
+//	w.Register(&watch.AppearNode{Watcher: w, Path: "server.sock", Existing: true, Appeared: connect})
+type AppearNode struct {
+	// Watcher is the Watcher the node is registered with.
+	Watcher *Watcher
+
+	// Path is the path of the file.
+	Path string
+
+	// Appeared is called when the file appears.
+	Appeared func() error
+
+	// Existing makes a file that exists at the first Scan after the node
+	// is registered count as appearing.
+	Existing bool
+
+	done atomic.Bool
+}
+
+// Paths implements Node. Once the file has appeared, it returns nil.
+func (n *AppearNode) Paths() []string {
+	if n.done.Load() {
+		return nil
+	}
+	return []string{n.Path}
+}
+
+// NotifyExisting implements ExistingNotifier.
+func (n *AppearNode) NotifyExisting() bool {
+	return n.Existing
+}
+
+// Updated implements Node by calling Appeared if the file appeared.
+func (n *AppearNode) Updated() error {
+	if n.done.Load() || !n.appeared() {
+		return nil
+	}
+	if n.Appeared != nil {
+		if err := n.Appeared(); err != nil {
+			return err
+		}
+	}
+	n.done.Store(true)
+	return nil
+}
+
+// Done reports whether the file has appeared and Appeared succeeded.
+func (n *AppearNode) Done() bool {
+	return n.done.Load()
+}
+
+// appeared reports whether the events of the current notification include
+// the file appearing.
+func (n *AppearNode) appeared() bool {
+	path := n.Watcher.normalize(n.Path)
+	for _, ev := range n.Watcher.Events(n) {
+		if ev.Path == path && (ev.Op == Create || ev.Op == Rename) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package watch
+
+import (
+	"context"
+	"runtime/debug"
+)
+
+// BatchNode is an optional interface for Nodes that want to know which of
+// their paths changed. When a node implements BatchNode, the Watcher calls
+// UpdatedPaths instead of Updated, once per Scan, with the sorted paths whose
+// changes caused the notification, including changes coalesced by Debounce or
+// accumulated while paused. This is the same list returned by ChangedPaths.
+// paths is empty if the node was notified only because one of its
+// dependencies was, because it is a Poller that reported a change, or by
+// UpdateAll.
+type BatchNode interface {
+	Node
+
+	// UpdatedPaths is called in place of Updated with the changed paths.
+	UpdatedPaths(paths []string) error
+}
+
+// update notifies node of a change to paths, preferring UpdatedPaths if the
+// node implements BatchNode, then UpdatedReason, called with ctx and reason,
+// if it implements ReasonNode, and UpdatedContext, called with ctx, if it
+// implements ContextNode. Errors are returned as a *NodeError, and a panic is
+// recovered and returned as a *NodeError wrapping a *PanicError.
+func update(ctx context.Context, node Node, paths []string, reason Reason) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Node: node, Value: v, Stack: debug.Stack()}
+		}
+		if err != nil {
+			e := &NodeError{Node: node, Err: err}
+			if len(paths) > 0 {
+				e.Path = paths[0]
+			}
+			err = e
+		}
+	}()
+	switch n := node.(type) {
+	case BatchNode:
+		return n.UpdatedPaths(paths)
+	case ReasonNode:
+		return n.UpdatedReason(ctx, reason)
+	case ContextNode:
+		return n.UpdatedContext(ctx)
+	}
+	return node.Updated()
+}
@@ -0,0 +1,138 @@
+package watch
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is a source of time for a Watcher. It is used to time debounce
+// periods and to drive Run, so that tests can simulate the passage of time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTicker returns a Ticker that delivers the time on its channel
+	// every d.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at regular intervals, like time.Ticker.
+type Ticker interface {
+	// C returns the channel on which ticks are delivered.
+	C() <-chan time.Time
+
+	// Stop turns off the ticker. No more ticks are delivered after Stop
+	// returns.
+	Stop()
+}
+
+// systemClock is the Clock used when Watcher.Clock is nil.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+
+func (t systemTicker) Stop() { t.t.Stop() }
+
+func (w *Watcher) clock() Clock {
+	if w.Clock == nil {
+		return systemClock{}
+	}
+	return w.Clock
+}
+
+// Run calls Scan immediately and then on every tick of a ticker with the
+// given interval, until ctx is done or the Watcher is closed. If handle is
+// not nil, it is called with the results of each Scan. Run returns the
+// context's error, or ErrClosed. If Adaptive is set, interval is the
+// starting interval, and the interval in use is reported by Stats.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration, handle func(updated bool, errs ScanErrors)) error {
+	now := w.clock().Now()
+	a := adaptive{AdaptiveInterval: w.Adaptive, interval: interval, changed: now}
+	ticker := w.clock().NewTicker(interval)
+	defer func() { ticker.Stop() }()
+	w.setInterval(interval)
+	defer w.setInterval(0)
+	for {
+		updated, errs := w.Scan()
+		if handle != nil {
+			handle(updated, errs)
+		}
+		if next := a.next(now, updated || w.Stats().LastScan.Changes > 0, interval); next != a.interval {
+			a.interval = next
+			ticker.Stop()
+			ticker = w.clock().NewTicker(next)
+			w.setInterval(next)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.doneChan():
+			return ErrClosed
+		case now = <-ticker.C():
+		}
+	}
+}
+
+func (w *Watcher) setInterval(d time.Duration) {
+	w.statsMu.Lock()
+	w.stats.Interval = d
+	w.statsMu.Unlock()
+}
+
+// AdaptiveInterval bounds the polling interval of Run when it adapts to
+// activity. Right after a scan that finds a change, Run polls every Min.
+// Once nothing has changed for Idle, every further scan without changes
+// doubles the interval, up to Max.
+type AdaptiveInterval struct {
+	// Min is the interval used after a change. If zero, the interval passed
+	// to Run is used.
+	Min time.Duration
+
+	// Max is the longest interval backed off to. If it is not greater than
+	// the minimum, the interval does not adapt.
+	Max time.Duration
+
+	// Idle is how long nothing must change before the interval starts to
+	// back off. If zero, ten times the minimum is used, so that a burst of
+	// changes or a pending Debounce is followed at the shortest interval.
+	Idle time.Duration
+}
+
+// adaptive tracks the state of an AdaptiveInterval during Run.
+type adaptive struct {
+	AdaptiveInterval
+	interval time.Duration // current interval
+	changed  time.Time     // time of the last change
+}
+
+// next returns the interval to use after a scan, ticked at now, that did or
+// did not find a change, given the interval passed to Run.
+func (a *adaptive) next(now time.Time, change bool, base time.Duration) time.Duration {
+	lo := a.Min
+	if lo <= 0 {
+		lo = base
+	}
+	if a.Max <= lo {
+		return a.interval
+	}
+	if change {
+		a.changed = now
+		return lo
+	}
+	idle := a.Idle
+	if idle <= 0 {
+		idle = 10 * lo
+	}
+	if now.Sub(a.changed) < idle {
+		return max(a.interval, lo)
+	}
+	return min(max(2*a.interval, lo), a.Max)
+}
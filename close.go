@@ -0,0 +1,55 @@
+package watch
+
+import "errors"
+
+// ErrClosed is returned by the methods of a Watcher after Close.
+var ErrClosed = errors.New("watch: watcher closed")
+
+// Close shuts the Watcher down. It waits for an in-progress Scan, ScanPaths,
+// ScanNode or UpdateAll to finish notifying nodes, stops Run, unregisters all
+// nodes, calling Detached on those that are Detachers, forgets all recorded
+// state and closes the scopes of the Watcher. Afterwards Register and the
+// scanning methods return ErrClosed, as does a second call to Close. Close
+// must not be called from Updated.
+func (w *Watcher) Close() error {
+	if !w.closed.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+	close(w.doneChan())
+	w.busy.Lock()
+	defer w.busy.Unlock()
+	nodes := w.sorted()
+	w.init()
+	w.laterMu.Lock()
+	w.later = nil
+	w.laterMu.Unlock()
+	for _, node := range nodes {
+		detached(node)
+	}
+	if w.parent != nil {
+		w.parent.removeScope(w)
+	}
+	w.closeScopes()
+	return nil
+}
+
+// doneChan returns a channel that is closed by Close.
+func (w *Watcher) doneChan() chan struct{} {
+	w.doneOnce.Do(func() { w.done = make(chan struct{}) })
+	return w.done
+}
+
+// begin locks the Watcher for a scan or notification pass, or returns
+// ErrClosed. If it returns nil, the caller must call w.busy.Unlock.
+func (w *Watcher) begin() error {
+	w.busy.Lock()
+	if w.closed.Load() {
+		w.busy.Unlock()
+		return ErrClosed
+	}
+	if !w.initialized {
+		w.init()
+	}
+	w.applyLater()
+	return nil
+}
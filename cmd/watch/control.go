@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchctl"
+)
+
+// control serves the control socket of a running watch, whose entries run
+// their commands like the command given on the command line.
+type control struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	runners []*runner
+}
+
+// listenControl starts serving the control socket at path for w. A socket
+// left behind by a watch that is no longer running is replaced.
+func listenControl(w *watch.Watcher, path string) (*control, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: a watch is already listening", path)
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &control{listener: l}
+	s := &watchctl.Server{Watcher: w, NewNode: func(command []string) (watch.Node, error) {
+		if len(command) == 0 {
+			return nil, errors.New("no command")
+		}
+		r := newRunner(command)
+		c.mu.Lock()
+		c.runners = append(c.runners, r)
+		c.mu.Unlock()
+		return r, nil
+	}}
+	go s.Serve(l)
+	return c, nil
+}
+
+// Close stops serving the socket and the commands of its entries.
+func (c *control) Close() {
+	c.listener.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.runners {
+		r.Stop()
+	}
+}
+
+// ctl runs the "watch ctl" subcommand, which sends a request to the control
+// socket of a running watch and prints the response, and returns the exit
+// status.
+func ctl(args []string) int {
+	usage := func() int {
+		fmt.Fprintf(os.Stderr, "usage: watch ctl socket status|pause|resume\n       watch ctl socket trigger [id]\n       watch ctl socket remove id\n       watch ctl socket add pattern... -- command [args...]\n")
+		return 2
+	}
+	if len(args) < 2 {
+		return usage()
+	}
+	req := watchctl.Request{Op: args[1]}
+	rest := args[2:]
+	switch req.Op {
+	case watchctl.OpStatus, watchctl.OpPause, watchctl.OpResume:
+		if len(rest) > 0 {
+			return usage()
+		}
+	case watchctl.OpTrigger, watchctl.OpRemove:
+		if len(rest) > 1 || len(rest) == 0 && req.Op == watchctl.OpRemove {
+			return usage()
+		}
+		if len(rest) == 1 {
+			id, err := strconv.Atoi(rest[0])
+			if err != nil {
+				return usage()
+			}
+			req.ID = id
+		}
+	case watchctl.OpAdd:
+		i := slices.Index(rest, "--")
+		if i <= 0 || i == len(rest)-1 {
+			return usage()
+		}
+		req.Patterns, req.Command = rest[:i], rest[i+1:]
+	default:
+		return usage()
+	}
+
+	c, err := watchctl.Dial("unix", args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "watch:", err)
+		return 1
+	}
+	defer c.Close()
+	resp, err := c.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "watch:", err)
+		return 1
+	}
+	switch {
+	case resp.Status != nil:
+		out, _ := json.MarshalIndent(resp.Status, "", "\t")
+		fmt.Println(string(out))
+	case resp.ID != 0:
+		fmt.Println(resp.ID)
+	}
+	for _, err := range resp.Errors {
+		fmt.Fprintln(os.Stderr, "watch:", err)
+	}
+	if len(resp.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+// Command watch runs a command whenever files matching a set of glob
+// patterns change, killing the previous run if it is still going.
+//
+// Usage:
+//
+//	watch [flags] -- command [args...]
+//
+// For example, to re-run the tests of a Go module on every change:
+//
+//	watch -p '**/*.go' -- go test ./...
+//
+// With -generate, watch instead re-runs the //go:generate directives of the
+// matching Go files ("**/*.go" if no -p is given) whenever their inputs
+// change, and no command is needed:
+//
+//	watch -generate
+//
+// With -manifest, watch instead reads the patterns and commands from a
+// manifest file, in the format of watch.Manifest, and reconfigures itself
+// whenever the manifest changes:
+//
+//	watch -manifest watch.json
+//
+// With -control, watch also serves a control socket, in the protocol of
+// package watchctl, through which patterns and commands can be added and
+// removed, notifications paused and resumed, and updates triggered while it
+// runs. The patterns and command are then optional. The ctl subcommand sends
+// a request to the socket:
+//
+//	watch -control /tmp/watch.sock &
+//	watch ctl /tmp/watch.sock add 'web/**/*.ts' -- npm run build
+//	watch ctl /tmp/watch.sock status
+//
+// Patterns use the syntax of watch.Watcher.Glob. Paths ignored by
+// .watchignore in the current directory, or by -i, are not watched.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchgen"
+)
+
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return fmt.Sprint(*s) }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(ctl(os.Args[2:]))
+	}
+	var patterns, ignores stringsFlag
+	flag.Var(&patterns, "p", "glob `pattern` of files to watch (repeatable)")
+	flag.Var(&ignores, "i", "gitignore-style `pattern` of paths to ignore (repeatable)")
+	ignoreFile := flag.String("ignore-file", ".watchignore", "`file` listing patterns to ignore, if it exists")
+	debounce := flag.Duration("debounce", 100*time.Millisecond, "quiet period before running the command")
+	interval := flag.Duration("interval", 250*time.Millisecond, "polling interval")
+	initial := flag.Bool("initial", true, "run the command once on start")
+	generate := flag.Bool("generate", false, "re-run the go:generate directives of the watched Go files when their inputs change, instead of a command")
+	manifest := flag.String("manifest", "", "`file` listing the patterns to watch and the commands to run, instead of a command")
+	controlSocket := flag.String("control", "", "serve a control `socket` for adding patterns and commands while running")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: watch [flags] -- command [args...]\n       watch -generate [flags]\n       watch -manifest file [flags]\n       watch -control socket [flags] [-- command [args...]]\n       watch ctl socket op [args...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	switch {
+	case *generate && *manifest != "":
+		flag.Usage()
+		os.Exit(2)
+	case *generate || *manifest != "":
+		if flag.NArg() > 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+	case *controlSocket != "" && len(patterns) == 0 && flag.NArg() == 0:
+		// everything is added through the control socket
+	case len(patterns) == 0 || flag.NArg() == 0:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	w := &watch.Watcher{Debounce: *debounce}
+	w.Ignore(".git/")
+	w.Ignore(ignores...)
+	if err := w.IgnoreFile(*ignoreFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var r watch.Node
+	switch {
+	case *generate:
+		r = &watchgen.Generator{Watcher: w, Patterns: patterns, Stdout: os.Stdout, Stderr: os.Stderr}
+		w.Register(r)
+	case *manifest != "":
+		var cmds []*runner
+		m := &watch.Manifest{Watcher: w, Path: *manifest, NewNode: func(e watch.ManifestEntry) (watch.Node, error) {
+			cmd := newRunner(e.Command)
+			cmd.Restart = e.Restart
+			cmds = append(cmds, cmd)
+			return cmd, nil
+		}}
+		if err := m.Load(); err != nil {
+			fatal(err)
+		}
+		defer func() {
+			for _, cmd := range cmds {
+				cmd.Stop()
+			}
+		}()
+		w.Register(m)
+		// -initial runs the commands of the manifest as first loaded
+		first := cmds
+		r = watch.NodeFunc(func() []string { return nil }, func() error {
+			var errs []error
+			for _, cmd := range first {
+				if err := cmd.Updated(); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		})
+	case len(patterns) == 0:
+		// only commands added through -control run
+		r = watch.NodeFunc(func() []string { return nil }, nil)
+	default:
+		cmd := newRunner(flag.Args())
+		defer cmd.Stop()
+		r = cmd
+		w.Register(&watch.GlobNode{Watcher: w, Patterns: patterns, Node: r})
+	}
+	if *controlSocket != "" {
+		c, err := listenControl(w, *controlSocket)
+		if err != nil {
+			fatal(err)
+		}
+		defer c.Close()
+	}
+	w.Run(ctx, *interval, func(_ bool, errs watch.ScanErrors) {
+		if len(errs) > 0 {
+			report(errs)
+		}
+		if *initial {
+			*initial = false
+			if err := r.Updated(); err != nil {
+				report([]error{err})
+			}
+		}
+	})
+}
+
+// report prints the errors of a scan, one per line.
+func report(errs watch.ScanErrors) {
+	for _, err := range errs {
+		msg := err.Error()
+		if !strings.HasPrefix(msg, "watch: ") {
+			msg = "watch: " + msg
+		}
+		fmt.Fprintln(os.Stderr, msg)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "watch:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/chriscraws/watch"
+)
+
+// runner is a watch.CommandNode that announces each run of its command on
+// stderr, killing the previous run if it has not finished.
+type runner struct {
+	watch.CommandNode
+	log io.Writer // receives the announcements
+}
+
+func newRunner(args []string) *runner {
+	return &runner{log: os.Stderr, CommandNode: watch.CommandNode{
+		Command: args,
+		Restart: true,
+		Stdin:   os.Stdin,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+		OnExit: func(err error) {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "watch: %v\n", err)
+			}
+		},
+	}}
+}
+
+// Updated restarts the command without changed paths.
+func (r *runner) Updated() error {
+	return r.UpdatedPaths(nil)
+}
+
+// UpdatedPaths restarts the command for the changed paths. Failures to start
+// the command are returned; the command's own exit status is reported on
+// stderr.
+func (r *runner) UpdatedPaths(paths []string) error {
+	fmt.Fprintf(r.log, "watch: running %s\n", strings.Join(r.Command, " "))
+	return r.CommandNode.UpdatedPaths(paths)
+}
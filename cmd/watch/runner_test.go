@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+func TestRunnerRestart(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not available")
+	}
+	r := newRunner([]string{"sleep", "10"})
+	if err := r.Updated(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := r.Updated(); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("restarting should kill the previous run")
+	}
+	r.Stop()
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("stopping should kill the run")
+	}
+}
+
+func TestRunnerAnnounce(t *testing.T) {
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("true not available")
+	}
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	w := &watch.Watcher{FS: fsys}
+	defer w.Close()
+	var log bytes.Buffer
+	r := newRunner([]string{"true"})
+	r.Files, r.log, r.Stdout, r.Stderr = []string{"a.txt"}, &log, nil, nil
+	w.Register(r)
+	w.Scan()
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", []byte("a"))
+	w.Scan()
+	if got, want := log.String(), "watch: running true\n"; got != want {
+		t.Errorf("runs through UpdatedPaths should be announced as %q, got %q", want, got)
+	}
+}
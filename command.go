@@ -0,0 +1,213 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CommandNode is a Node that runs a command when any of its Files change.
+// The arguments of Command are templates: "{path}" is replaced by the first
+// changed path, and an argument that is exactly "{paths}" is replaced by one
+// argument per changed path, in sorted order. When the node is notified
+// without changed paths, such as because a dependency was updated or by
+// UpdateAll, "{path}" is replaced by "" and "{paths}" is dropped.
+//
+// By default Updated runs the command to completion and returns its error,
+// such as a non-zero exit status. With Restart set, the command is started
+// in the background instead, killing the previous run if it is still going,
+// as for servers that are restarted on every change. A CommandNode must not
+// be copied after first use.
+//
+//	w.Register(&watch.CommandNode{
+//		Files:   []string{"schema.sql"},
+//		Command: []string{"sqlc", "generate", "-f", "{path}"},
+//		Stderr:  os.Stderr,
+//	})
+type CommandNode struct {
+	// Files lists the paths watched by the node.
+	Files []string
+
+	// Command is the program to run and its arguments.
+	Command []string
+
+	// Dir is the working directory of the command. If empty, the command
+	// runs in the current directory.
+	Dir string
+
+	// Env lists environment variables, in the form "key=value", added to
+	// the environment of the current process for the command.
+	Env []string
+
+	// Timeout, if not zero, is the maximum duration of a run, after which
+	// the command is killed.
+	Timeout time.Duration
+
+	// Restart makes Updated start the command in the background, killing
+	// the run started by the previous Updated if it has not finished.
+	Restart bool
+
+	// OnExit, if not nil, is called with the result of every background run
+	// when Restart is set, except for runs killed by a restart or by Stop.
+	OnExit func(err error)
+
+	// Stdin, Stdout and Stderr are connected to the command. If nil, they
+	// are connected to the null device.
+	Stdin          io.Reader
+	Stdout, Stderr io.Writer
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Paths implements Node.
+func (c *CommandNode) Paths() []string {
+	return c.Files
+}
+
+// Updated implements Node by running the command without changed paths.
+func (c *CommandNode) Updated() error {
+	return c.UpdatedPaths(nil)
+}
+
+// UpdatedPaths implements BatchNode by running the command for the changed
+// paths.
+func (c *CommandNode) UpdatedPaths(paths []string) error {
+	if len(c.Command) == 0 {
+		return errors.New("watch: CommandNode has no command")
+	}
+	if c.Restart {
+		return c.start(paths)
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	cmd := c.command(ctx, paths)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", c.Command[0], c.result(ctx, err))
+	}
+	return nil
+}
+
+// Stop kills the command started in the background, if it is still running,
+// and waits for it to exit.
+func (c *CommandNode) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stop()
+}
+
+// Detached implements Detacher by calling Stop.
+func (c *CommandNode) Detached() {
+	c.Stop()
+}
+
+// start kills the previous background run and starts a new one.
+func (c *CommandNode) start(paths []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stop()
+	ctx, cancel := c.context()
+	cmd := c.command(ctx, paths)
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return fmt.Errorf("%s: %w", c.Command[0], err)
+	}
+	var killed atomic.Bool
+	done := make(chan struct{})
+	c.done = done
+	c.cancel = func() {
+		killed.Store(true)
+		cancel()
+	}
+	go func() {
+		defer close(done)
+		defer cancel()
+		err := cmd.Wait()
+		if killed.Load() || c.OnExit == nil {
+			return
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %w", c.Command[0], c.result(ctx, err))
+		}
+		c.OnExit(err)
+	}()
+	return nil
+}
+
+// stop kills the background run, if any, and waits for it to exit. c.mu
+// must be held.
+func (c *CommandNode) stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+	c.cancel, c.done = nil, nil
+}
+
+// context returns the context of a run, which is done after Timeout.
+func (c *CommandNode) context() (context.Context, context.CancelFunc) {
+	if c.Timeout > 0 {
+		return context.WithTimeout(context.Background(), c.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// command returns the command to run for the changed paths.
+func (c *CommandNode) command(ctx context.Context, paths []string) *exec.Cmd {
+	return c.newCmd(ctx, expandArgs(c.Command, paths))
+}
+
+// newCmd returns the command running args with the Dir, Env and standard
+// streams of c, which is killed when ctx is done.
+func (c *CommandNode) newCmd(ctx context.Context, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = c.Dir
+	if c.Env != nil {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = c.Stdin, c.Stdout, c.Stderr
+	// do not wait forever for the output of processes it left behind
+	cmd.WaitDelay = time.Second
+	configureCommand(cmd)
+	cmd.Cancel = func() error {
+		return killCommand(cmd)
+	}
+	return cmd
+}
+
+// result returns the error of a run that failed with err, which reports a
+// timeout if the run was killed because of one.
+func (c *CommandNode) result(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %v: %w", c.Timeout, err)
+	}
+	return err
+}
+
+// expandArgs replaces the placeholders of args by the changed paths.
+func expandArgs(args, paths []string) []string {
+	paths = slices.Sorted(slices.Values(paths))
+	var first string
+	if len(paths) > 0 {
+		first = paths[0]
+	}
+	expanded := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "{paths}" {
+			expanded = append(expanded, paths...)
+			continue
+		}
+		expanded = append(expanded, strings.ReplaceAll(arg, "{path}", first))
+	}
+	return expanded
+}
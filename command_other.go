@@ -0,0 +1,20 @@
+//go:build !unix
+
+package watch
+
+import "os/exec"
+
+// configureCommand is a no-op on platforms without process groups; killing
+// cmd only kills the command itself.
+func configureCommand(cmd *exec.Cmd) {}
+
+// terminateCommand kills a started cmd, since there is no portable way to
+// ask it to exit.
+func terminateCommand(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killCommand kills a started cmd.
+func killCommand(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
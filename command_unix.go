@@ -0,0 +1,24 @@
+//go:build unix
+
+package watch
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// configureCommand runs cmd in its own process group so that killing it also
+// kills any processes it started, such as the test binaries run by go test.
+func configureCommand(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateCommand asks the process group of a started cmd to exit.
+func terminateCommand(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+// killCommand kills the process group of a started cmd.
+func killCommand(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
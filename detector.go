@@ -0,0 +1,141 @@
+package watch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Fingerprint summarizes the state of a file as computed by a Detector, to
+// be compared with the fingerprint computed by a later Scan. Its format is
+// up to the Detector. Fingerprints are kept for every watched path that
+// exists and are saved by SaveState.
+type Fingerprint []byte
+
+// Detector decides whether files have changed between scans, for Watchers
+// that need a strategy other than those offered by Detect, such as only
+// reporting a change to a particular key of a JSON file.
+//
+// Fingerprint is called when a path is first seen, when it is created, and
+// whenever its modification time, size or identity differ from the previous
+// Scan, leaving out the size with DetectModTime; a file whose stat did not
+// change is not reported without calling the Detector. fsys is the file
+// system of the path, which for the operating system's file system accepts
+// operating system paths. If Fingerprint returns an error, it is reported by
+// Scan and the change is decided by the stat as Detect does. Changed reports
+// whether the file changed given the fingerprints of the previous and the
+// current Scan.
+type Detector interface {
+	Fingerprint(path string, info fs.FileInfo, fsys fs.FS) (Fingerprint, error)
+	Changed(prev, cur Fingerprint) bool
+}
+
+// StatDetector is a Detector comparing the modification time, size and
+// identity of files, or only the modification time and identity if
+// IgnoreSize is set. It implements DetectModTimeSize and DetectModTime, and
+// is the starting point for detectors that refine what counts as a change.
+// Its fingerprints are not kept, since they are computed from the stat of
+// the previous Scan.
+type StatDetector struct {
+	IgnoreSize bool
+}
+
+// modified reports whether the fingerprints of prev and cur differ, without
+// computing them.
+func (d StatDetector) modified(prev, cur fs.FileInfo) bool {
+	return !prev.ModTime().Equal(cur.ModTime()) || !sameID(prev, cur) ||
+		!d.IgnoreSize && prev.Size() != cur.Size()
+}
+
+// Fingerprint implements Detector.
+func (d StatDetector) Fingerprint(path string, info fs.FileInfo, fsys fs.FS) (Fingerprint, error) {
+	fp := binary.LittleEndian.AppendUint64(nil, uint64(info.ModTime().UnixNano()))
+	if !d.IgnoreSize {
+		fp = binary.LittleEndian.AppendUint64(fp, uint64(info.Size()))
+	}
+	if id, ok := fileID(info); ok {
+		fp = binary.LittleEndian.AppendUint64(fp, id.Dev)
+		fp = binary.LittleEndian.AppendUint64(fp, id.Ino)
+	}
+	return fp, nil
+}
+
+// Changed implements Detector.
+func (StatDetector) Changed(prev, cur Fingerprint) bool {
+	return !bytes.Equal(prev, cur)
+}
+
+// HashDetector is a Detector comparing digests of the contents of files. It
+// implements DetectHash, with the Watcher's NewHash.
+type HashDetector struct {
+	// NewHash returns the hash used for digests. If nil, 64-bit FNV-1a is
+	// used.
+	NewHash func() hash.Hash
+}
+
+// Fingerprint implements Detector.
+func (d HashDetector) Fingerprint(path string, info fs.FileInfo, fsys fs.FS) (Fingerprint, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var h hash.Hash = fnv.New64a()
+	if d.NewHash != nil {
+		h = d.NewHash()
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Changed implements Detector.
+func (HashDetector) Changed(prev, cur Fingerprint) bool {
+	return !bytes.Equal(prev, cur)
+}
+
+// detector returns the Detector deciding changes to a path with detect: the
+// Watcher's Detector, or the one implementing detect.
+func (w *Watcher) detector(detect Detection) Detector {
+	switch {
+	case w.Detector != nil:
+		return w.Detector
+	case detect == DetectHash:
+		return HashDetector{NewHash: w.NewHash}
+	}
+	return StatDetector{IgnoreSize: detect == DetectModTime}
+}
+
+// fingerprint returns the fingerprint of the file at path in fsys computed
+// by d. It is never nil without an error, so that it can be told apart from
+// a path without a fingerprint.
+func fingerprint(d Detector, fsys fs.FS, path string, info fs.FileInfo) ([]byte, error) {
+	if fsys == nil {
+		fsys = osFS{}
+	}
+	fp, err := d.Fingerprint(path, info, fsys)
+	if err != nil {
+		return nil, err
+	}
+	if fp == nil {
+		fp = Fingerprint{}
+	}
+	return fp, nil
+}
+
+// osFS is the operating system's file system, accepting operating system
+// paths, for Detectors.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
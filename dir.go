@@ -0,0 +1,85 @@
+package watch
+
+import (
+	"io/fs"
+	"slices"
+)
+
+// listDir returns the sorted names of the entries of the directory at p in
+// fsys that are not excluded by Ignore. The result is never nil, so that an
+// empty directory can be told apart from one that was not listed.
+func (w *Watcher) listDir(fsys fs.FS, p string) ([]string, error) {
+	entries, err := readDir(fsys, p)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, d := range entries {
+		if !w.ignored(joinPath(fsys, p, d.Name()), d.IsDir()) {
+			names = append(names, d.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// diffEntries returns the names in the sorted list cur that are not in the
+// sorted list prev, and those in prev that are not in cur.
+func diffEntries(prev, cur []string) (added, removed []string) {
+	i, j := 0, 0
+	for i < len(prev) || j < len(cur) {
+		switch {
+		case j == len(cur) || i < len(prev) && prev[i] < cur[j]:
+			removed = append(removed, prev[i])
+			i++
+		case i == len(prev) || cur[j] < prev[i]:
+			added = append(added, cur[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return added, removed
+}
+
+// entryEvents returns a Create or Remove event for each entry added to or
+// removed from the directory dir between the listings prev and cur, and for
+// each path beneath an added directory if ListSubtrees is set.
+func (w *Watcher) entryEvents(fsys fs.FS, dir string, prev, cur []string) []Event {
+	added, removed := diffEntries(prev, cur)
+	var events []Event
+	for _, name := range added {
+		p := joinPath(fsys, dir, name)
+		events = append(events, Event{Op: Create, Path: p})
+		if w.ListSubtrees {
+			events = w.subtreeEvents(events, fsys, p)
+		}
+	}
+	for _, name := range removed {
+		events = append(events, Event{Op: Remove, Path: joinPath(fsys, dir, name)})
+	}
+	return events
+}
+
+// subtreeEvents appends a Create event for each path beneath dir that is not
+// excluded by Ignore, in lexical order, to events. Entries that cannot be
+// read, such as a directory removed while it is walked, are skipped, and
+// symbolic links to directories are not followed.
+func (w *Watcher) subtreeEvents(events []Event, fsys fs.FS, dir string) []Event {
+	entries, err := readDir(fsys, dir)
+	if err != nil {
+		return events
+	}
+	for _, d := range entries {
+		p := joinPath(fsys, dir, d.Name())
+		if w.ignored(p, d.IsDir()) {
+			continue
+		}
+		events = append(events, Event{Op: Create, Path: p})
+		if d.IsDir() {
+			events = w.subtreeEvents(events, fsys, p)
+		}
+	}
+	return events
+}
@@ -0,0 +1,147 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// StatError records a failure to stat or read a watched path during Scan.
+// Paths that do not exist are not reported. StatErrors are returned by Scan
+// before any errors returned by Node Updated methods, and can be told apart
+// from them with errors.As.
+type StatError struct {
+	Path string
+	Err  error
+}
+
+func (e *StatError) Error() string {
+	return "watch: " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *StatError) Unwrap() error {
+	return e.Err
+}
+
+// statError returns err for path as a *StatError, or returns nil if err is
+// nil or reports a missing file.
+func statError(path string, err error) error {
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return &StatError{Path: path, Err: err}
+}
+
+// NodeError attributes an error returned by a Node's Updated method to the
+// node. Scan and UpdateAll return the errors of Updated wrapped in a
+// *NodeError, so errors.Is and errors.As see through to Err.
+type NodeError struct {
+	Node Node
+
+	// Path is the first changed path that caused the node to be notified,
+	// or empty if the node was notified because one of its dependencies
+	// was, because it is a Poller, or by UpdateAll.
+	Path string
+
+	Err error
+}
+
+func (e *NodeError) Error() string {
+	if e.Path == "" {
+		return "watch: " + NodeName(e.Node) + ": " + e.Err.Error()
+	}
+	return "watch: " + NodeName(e.Node) + ": " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+// ScanErrors lists the errors of a scan or update, as returned by Scan,
+// UpdateAll and the other methods of Watcher and Group that notify nodes:
+// the *StatErrors of the paths that could not be checked, then the
+// *NodeErrors of the nodes whose update failed. It implements error, so
+// that the errors can be returned or reported as one without losing their
+// attribution, and errors.Is and errors.As look through every element.
+// Since a nil ScanErrors is not a nil error, use Err to return it as one:
+//
+//	if _, errs := w.Scan(); len(errs) > 0 {
+//		for _, err := range errs.NodeErrors() {
+//			log.Printf("%s failed: %v", err.Node, err.Err)
+//		}
+//		return errs
+//	}
+type ScanErrors []error
+
+// Error returns the messages of the errors, one per line, or "" if e is
+// empty.
+func (e ScanErrors) Error() string {
+	if len(e) == 0 {
+		return ""
+	}
+	return errors.Join(e...).Error()
+}
+
+// Unwrap returns the errors, for errors.Is and errors.As.
+func (e ScanErrors) Unwrap() []error {
+	return e
+}
+
+// Err returns e, or nil if it is empty.
+func (e ScanErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// StatErrors returns the errors that are *StatErrors, in order.
+func (e ScanErrors) StatErrors() []*StatError {
+	return scanErrors[*StatError](e)
+}
+
+// NodeErrors returns the errors that are *NodeErrors, in order.
+func (e ScanErrors) NodeErrors() []*NodeError {
+	return scanErrors[*NodeError](e)
+}
+
+// scanErrors returns the errors in e that are, or wrap, a T.
+func scanErrors[T error](e ScanErrors) []T {
+	var errs []T
+	for _, err := range e {
+		var t T
+		if errors.As(err, &t) {
+			errs = append(errs, t)
+		}
+	}
+	return errs
+}
+
+// PanicError is the Err of the *NodeError returned when a Node's Updated
+// method panics. The panic is recovered so that the remaining nodes
+// are still notified, and the nodes that depend on the panicking node are
+// not.
+type PanicError struct {
+	Node  Node
+	Value any    // the value passed to panic
+	Stack []byte // the stack trace of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("watch: panic in %s: %v", NodeName(e.Node), e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// NodeName names node in errors, profiles and metrics: it returns the String
+// method of node if it has one, or its type.
+func NodeName(node Node) string {
+	if s, ok := node.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", node)
+}
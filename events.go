@@ -0,0 +1,179 @@
+package watch
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// Op is the kind of change described by an Event.
+type Op int
+
+const (
+	// Create reports that a path that did not exist during the previous
+	// Scan was created.
+	Create Op = iota + 1
+
+	// Write reports that a file was modified.
+	Write
+
+	// Remove reports that a path that existed during the previous Scan was
+	// removed.
+	Remove
+
+	// Rename reports that a file was moved from OldPath to Path, as
+	// detected by a removed path and a path that was created with the same
+	// file identity, such as the same inode number. If only the old path
+	// is watched, the new path is found by looking for the file in the
+	// directory of the old path.
+	Rename
+
+	// Chmod reports that only the mode of a path changed, such as its
+	// permission bits after chmod +x. It is only reported if
+	// Watcher.DetectMode is set.
+	Chmod
+)
+
+func (op Op) String() string {
+	switch op {
+	case Create:
+		return "create"
+	case Write:
+		return "write"
+	case Remove:
+		return "remove"
+	case Rename:
+		return "rename"
+	case Chmod:
+		return "chmod"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (op Op) MarshalText() ([]byte, error) {
+	return []byte(op.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (op *Op) UnmarshalText(text []byte) error {
+	for o := Create; o <= Chmod; o++ {
+		if o.String() == string(text) {
+			*op = o
+			return nil
+		}
+	}
+	return fmt.Errorf("watch: unknown op %q", text)
+}
+
+// Event describes a change detected by a scan.
+type Event struct {
+	Op      Op     `json:"op"`
+	Path    string `json:"path"`
+	OldPath string `json:"oldPath,omitempty"` // the previous path of a renamed file
+
+	// Seq is the sequence number of the event in Watcher.Journal, or zero
+	// if the Watcher has no journal.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+func (e Event) String() string {
+	if e.Op == Rename {
+		return e.Op.String() + " " + e.OldPath + " -> " + e.Path
+	}
+	return e.Op.String() + " " + e.Path
+}
+
+// Events returns the events that caused node to be notified by the most
+// recent scan, in the order they were detected, including events coalesced
+// by Debounce or accumulated while paused. Renames are reported to the nodes
+// watching either path. Like ChangedPaths, it may be called from Updated, and
+// returns nil if node was not notified because of changes to its own paths.
+func (w *Watcher) Events(node Node) []Event {
+	return w.events[node]
+}
+
+// addEvent queues ev for a node awaiting notification.
+func (w *Watcher) addEvent(node Node, ev Event) {
+	if !containsEvent(w.queued[node], ev) {
+		w.queued[node] = append(w.queued[node], ev)
+	}
+}
+
+func containsEvent(events []Event, ev Event) bool {
+	for _, e := range events {
+		if e.Op == ev.Op && e.Path == ev.Path && e.OldPath == ev.OldPath {
+			return true
+		}
+	}
+	return false
+}
+
+// event returns the event describing the change detected in e.
+func (e *scanEntry) event() Event {
+	switch {
+	case e.op == Rename && e.info == nil:
+		return Event{Op: Rename, Path: e.other, OldPath: e.path}
+	case e.op == Rename:
+		return Event{Op: Rename, Path: e.path, OldPath: e.other}
+	case e.op == 0:
+		return Event{Op: Create, Path: e.path}
+	}
+	return Event{Op: e.op, Path: e.path}
+}
+
+// renames pairs the removed paths in s with created paths of the same file
+// identity, or failing that with a file of the same identity in the removed
+// path's directory, and marks them as renamed.
+func (w *Watcher) renames(s *scan) {
+	type file struct {
+		root int
+		id   fileIdentity
+	}
+	var removed []int
+	created := map[file]int{}
+	for i := range s.entries {
+		e := &s.entries[i]
+		switch {
+		case e.op == Remove:
+			removed = append(removed, i)
+		case e.info != nil && (e.op == Create || e.prev == nil):
+			if id, ok := fileID(e.info); ok {
+				created[file{e.root, id}] = i
+			}
+		}
+	}
+	for _, i := range removed {
+		old := &s.entries[i]
+		id, ok := fileID(old.prev.info)
+		if !ok {
+			continue
+		}
+		if j, ok := created[file{old.root, id}]; ok {
+			e := &s.entries[j]
+			old.op, old.other = Rename, e.path
+			e.op, e.other, e.updated = Rename, old.path, true
+			delete(created, file{old.root, id})
+		} else if p, ok := findFile(old.fsys, dirPath(old.fsys, old.path), id); ok {
+			old.op, old.other = Rename, p
+		}
+	}
+}
+
+// findFile returns the path of the file with the given identity in dir.
+func findFile(fsys fs.FS, dir string, id fileIdentity) (string, bool) {
+	entries, err := readDir(fsys, dir)
+	if err != nil {
+		return "", false
+	}
+	for _, d := range entries {
+		if d.IsDir() {
+			continue
+		}
+		if info, err := d.Info(); err == nil {
+			if other, ok := fileID(info); ok && other == id {
+				return joinPath(fsys, dir, d.Name()), true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,40 @@
+package watch
+
+import "io/fs"
+
+// fileIdentity identifies a file independently of its path, such as by its
+// device and inode numbers on Unix.
+type fileIdentity struct {
+	Dev uint64 `json:"dev"`
+	Ino uint64 `json:"ino"`
+}
+
+// fileID returns the identity of the file described by info, if the
+// platform or file system provides one. File systems other than the
+// operating system's can provide identities with a Sys value that has an
+// Inode method, as watchtest.FS does.
+func fileID(info fs.FileInfo) (fileIdentity, bool) {
+	switch sys := info.Sys().(type) {
+	case *fileIdentity:
+		return *sys, true
+	case interface{ Inode() uint64 }:
+		return fileIdentity{Ino: sys.Inode()}, true
+	}
+	return sysFileID(info)
+}
+
+// sameFile reports whether a and b describe a file at similar state: the
+// same size and, where available, the same identity. A file replaced by
+// write-to-temp-then-rename usually has a new identity even if its
+// modification time is unchanged.
+func sameFile(a, b fs.FileInfo) bool {
+	return a.Size() == b.Size() && sameID(a, b)
+}
+
+// sameID reports whether a and b have the same identity, or whether either
+// has no identity.
+func sameID(a, b fs.FileInfo) bool {
+	aid, aok := fileID(a)
+	bid, bok := fileID(b)
+	return !aok || !bok || aid == bid
+}
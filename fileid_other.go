@@ -0,0 +1,9 @@
+//go:build !unix
+
+package watch
+
+import "io/fs"
+
+func sysFileID(info fs.FileInfo) (fileIdentity, bool) {
+	return fileIdentity{}, false
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package watch
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func sysFileID(info fs.FileInfo) (fileIdentity, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileIdentity{}, false
+	}
+	return fileIdentity{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, true
+}
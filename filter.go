@@ -0,0 +1,62 @@
+package watch
+
+import (
+	"path"
+	"strings"
+)
+
+// DefaultFilter is a Filter that drops the events of files that editors and
+// operating systems create next to the files being edited: Vim swap files
+// (*.swp, *.swo, *.swx) and its write test file 4913, backup files ending in
+// ~, Emacs lock and autosave files (.#* and #*#), and .DS_Store, AppleDouble
+// (._*), Thumbs.db and desktop.ini files.
+//
+//	w := &watch.Watcher{Filter: watch.DefaultFilter}
+func DefaultFilter(p string, op Op) bool {
+	name := path.Base(strings.ReplaceAll(p, `\`, "/"))
+	switch {
+	case name == ".DS_Store", name == "Thumbs.db", name == "desktop.ini", name == "4913":
+		return false
+	case strings.HasSuffix(name, "~"),
+		strings.HasPrefix(name, ".#"),
+		strings.HasPrefix(name, "._"),
+		len(name) > 1 && strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#"):
+		return false
+	}
+	switch path.Ext(name) {
+	case ".swp", ".swo", ".swx":
+		return false
+	}
+	return true
+}
+
+// filter returns the Filter that applies to the events of node.
+func (w *Watcher) filter(node Node) func(path string, op Op) bool {
+	if f := w.options[node].Filter; f != nil {
+		return f
+	}
+	return w.Filter
+}
+
+// filterEvents returns the events that node is notified of. The result
+// shares events, which is returned as is if no event is dropped.
+func (w *Watcher) filterEvents(node Node, events []Event) []Event {
+	f := w.filter(node)
+	if f == nil {
+		return events
+	}
+	for i, ev := range events {
+		if f(ev.Path, ev.Op) {
+			continue
+		}
+		// copy the kept events, since events is shared between nodes
+		kept := append([]Event(nil), events[:i]...)
+		for _, ev := range events[i+1:] {
+			if f(ev.Path, ev.Op) {
+				kept = append(kept, ev)
+			}
+		}
+		return kept
+	}
+	return events
+}
@@ -0,0 +1,179 @@
+package watch
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ErrNotStatFS is returned by Scan and Peek in Strict mode if FS does not
+// implement fs.StatFS.
+var ErrNotStatFS = errors.New("watch: strict mode requires FS to implement fs.StatFS")
+
+// StatManyFS is a file system that can stat many paths in one operation,
+// such as a remote file system that answers a batch of stats in a single
+// round trip. When the file system of a path implements StatManyFS and
+// Symlinks is SymlinkFollow, a scan stats all the paths it checks in that
+// file system with one call to StatMany instead of one call to Stat each.
+// Retries of failed stats still use Stat.
+type StatManyFS interface {
+	fs.StatFS
+
+	// StatMany returns the info of each of names, in order, or the error
+	// statting it, which must match fs.ErrNotExist for a missing file. Both
+	// slices must have the length of names, except that errs may be nil if
+	// every stat succeeded. StatMany must follow symbolic links, like Stat.
+	StatMany(names []string) (infos []fs.FileInfo, errs []error)
+}
+
+// statMany stats the entries that are to be checked with one call to
+// StatMany per file system implementing StatManyFS, and records the results
+// in the entries for their first check.
+func (w *Watcher) statMany(entries []scanEntry) {
+	if w.Symlinks != SymlinkFollow {
+		return
+	}
+	batches := make(map[int][]int) // root to entry indices
+	for i := range entries {
+		e := &entries[i]
+		if e.skip {
+			continue
+		}
+		if _, ok := e.fsys.(StatManyFS); ok {
+			batches[e.root] = append(batches[e.root], i)
+		}
+	}
+	for _, batch := range batches {
+		names := make([]string, len(batch))
+		for j, i := range batch {
+			names[j] = entries[i].path
+		}
+		infos, errs := entries[batch[0]].fsys.(StatManyFS).StatMany(names)
+		if len(infos) != len(names) || (errs != nil && len(errs) != len(names)) {
+			// a broken batch is ignored, and the paths statted one by one
+			continue
+		}
+		for j, i := range batch {
+			e := &entries[i]
+			e.batched, e.info = true, infos[j]
+			if errs != nil {
+				e.errs[0] = errs[j]
+			}
+			if e.info == nil && e.errs[0] == nil {
+				e.errs[0] = &fs.PathError{Op: "stat", Path: e.path, Err: fs.ErrNotExist}
+			}
+		}
+	}
+}
+
+// fsys returns the file system used for all file operations, or nil if the
+// operating system's file system is used. Outside of Strict mode, FS is only
+// used if it implements fs.StatFS.
+func (w *Watcher) fsys() fs.FS {
+	if w.Strict {
+		return w.FS
+	}
+	if fsys, ok := w.FS.(fs.StatFS); ok {
+		return fsys
+	}
+	return nil
+}
+
+// checkFS returns ErrNotStatFS if the Watcher is in Strict mode and FS does
+// not implement fs.StatFS.
+func (w *Watcher) checkFS() error {
+	if _, ok := w.FS.(fs.StatFS); w.Strict && !ok {
+		return ErrNotStatFS
+	}
+	return nil
+}
+
+// open opens the file at path.
+func (w *Watcher) open(path string) (fs.File, error) {
+	return openFile(w.fsys(), path)
+}
+
+// lstatFile returns the info for path in fsys, or in the operating system's
+// file system if fsys is nil, without following a final symbolic link, along
+// with the link's destination if it is one. If the file system does not
+// implement fs.ReadLinkFS, links are followed.
+func lstatFile(fsys fs.FS, path string) (fs.FileInfo, string, error) {
+	var (
+		info fs.FileInfo
+		err  error
+	)
+	if fsys != nil {
+		info, err = fs.Lstat(fsys, path)
+	} else {
+		info, err = os.Lstat(path)
+	}
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return info, "", err
+	}
+	var link string
+	if fsys != nil {
+		link, err = fs.ReadLink(fsys, path)
+	} else {
+		link, err = os.Readlink(path)
+	}
+	return info, link, err
+}
+
+// openFile opens name in fsys, or in the operating system's file system if
+// fsys is nil. The os package gives long and UNC paths the \\?\ prefix on
+// Windows, so such paths need no conversion.
+func openFile(fsys fs.FS, name string) (fs.File, error) {
+	if fsys == nil {
+		return os.Open(name)
+	}
+	return fsys.Open(name)
+}
+
+// ReadFile reads the file name in fsys, or in the operating system's file
+// system if fsys is nil. It is how the nodes of this module and its
+// subpackages read the files of their FS field, which should match the FS
+// of the Watcher they are registered with.
+func ReadFile(fsys fs.FS, name string) ([]byte, error) {
+	if fsys == nil {
+		return os.ReadFile(name)
+	}
+	return fs.ReadFile(fsys, name)
+}
+
+// statFile stats name in fsys, or in the operating system's file system if
+// fsys is nil.
+func statFile(fsys fs.FS, name string) (fs.FileInfo, error) {
+	if fsys == nil {
+		return os.Stat(name)
+	}
+	return fs.Stat(fsys, name)
+}
+
+// readDir reads the directory name in fsys, or in the operating system's file
+// system if fsys is nil.
+func readDir(fsys fs.FS, name string) ([]fs.DirEntry, error) {
+	if fsys == nil {
+		return os.ReadDir(name)
+	}
+	return fs.ReadDir(fsys, name)
+}
+
+// joinPath joins path elements using slashes for an fs.FS and the operating
+// system's separator otherwise.
+func joinPath(fsys fs.FS, elem ...string) string {
+	if fsys == nil {
+		return filepath.Join(elem...)
+	}
+	return path.Join(elem...)
+}
+
+// dirPath returns the directory of p using slashes for an fs.FS and the
+// operating system's separator otherwise.
+func dirPath(fsys fs.FS, p string) string {
+	if fsys == nil {
+		return filepath.Dir(p)
+	}
+	return path.Dir(p)
+}
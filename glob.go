@@ -0,0 +1,182 @@
+package watch
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Glob returns the sorted paths of the files matching pattern. Patterns are
+// slash-separated and use path.Match syntax for each segment, and a "**"
+// segment matches any number of directories, so "**/*.go" matches every Go
+// file below the current directory. Paths and directories excluded by Ignore
+// are skipped.
+func (w *Watcher) Glob(pattern string) ([]string, error) {
+	files, _, err := w.glob(pattern)
+	return files, err
+}
+
+// glob returns the files matching pattern along with the directories that were
+// read to find them.
+func (w *Watcher) glob(pattern string) (files, dirs []string, err error) {
+	fsys := w.fsys()
+	root := "."
+	if strings.HasPrefix(pattern, "/") {
+		root = "/"
+	}
+	segments := splitPath(pattern)
+	// start from the longest prefix without wildcards
+	i := 0
+	for ; i < len(segments)-1 && !hasMeta(segments[i]); i++ {
+		root = joinPath(fsys, root, segments[i])
+	}
+	g := globber{w: w, fsys: fsys}
+	err = g.expand(root, segments[i:])
+	slices.Sort(g.files)
+	return g.files, g.dirs, err
+}
+
+type globber struct {
+	w     *Watcher
+	fsys  fs.FS
+	files []string
+	dirs  []string
+}
+
+// expand adds the entries of dir matching pattern.
+func (g *globber) expand(dir string, pattern []string) error {
+	if len(pattern) == 0 {
+		return nil
+	}
+	entries, err := readDir(g.fsys, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	g.dirs = append(g.dirs, dir)
+	var errs []error
+	for _, e := range entries {
+		name := joinPath(g.fsys, dir, e.Name())
+		if g.w.ignored(name, e.IsDir()) {
+			continue
+		}
+		if pattern[0] == "**" {
+			if e.IsDir() {
+				errs = append(errs, g.expand(name, pattern))
+			}
+			if len(pattern) > 1 {
+				g.match(name, e, pattern[1:], &errs)
+			} else if !e.IsDir() {
+				g.files = append(g.files, name)
+			}
+			continue
+		}
+		g.match(name, e, pattern, &errs)
+	}
+	return errors.Join(errs...)
+}
+
+// match adds the entry name if it matches the last pattern segment, or
+// descends into it if it matches an earlier one.
+func (g *globber) match(name string, e fs.DirEntry, pattern []string, errs *[]error) {
+	if ok, _ := path.Match(pattern[0], e.Name()); !ok {
+		return
+	}
+	if len(pattern) == 1 {
+		if !e.IsDir() && !slices.Contains(g.files, name) {
+			g.files = append(g.files, name)
+		}
+	} else if e.IsDir() {
+		*errs = append(*errs, g.expand(name, pattern[1:]))
+	}
+}
+
+func hasMeta(segment string) bool {
+	return strings.ContainsAny(segment, `*?[\`)
+}
+
+// GlobNode is a Node that watches every file matching a set of glob
+// patterns, as expanded by Watcher.Glob. The directories searched are
+// watched too, so that creating or removing a file notifies the node. The
+// patterns are expanded again every time Paths is called.
+type GlobNode struct {
+	// Watcher expands the patterns, using its FS and ignore rules.
+	Watcher *Watcher
+
+	// Patterns lists the glob patterns to watch.
+	Patterns []string
+
+	// Node, if not nil, is notified when any matching file changes. The
+	// paths it returns are watched in addition to the matching files. If it
+	// is a BatchNode, it receives the changed paths.
+	Node Node
+
+	err  error
+	dirs map[string]struct{} // searched by the last Paths
+}
+
+// Paths returns the files matching Patterns, the directories searched to
+// find them and the paths of Node.
+func (n *GlobNode) Paths() []string {
+	var paths []string
+	var errs []error
+	n.dirs = make(map[string]struct{})
+	for _, pattern := range n.Patterns {
+		files, dirs, err := n.Watcher.glob(pattern)
+		paths = append(paths, dirs...)
+		paths = append(paths, files...)
+		for _, dir := range dirs {
+			n.dirs[dir] = struct{}{}
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	n.err = errors.Join(errs...)
+	if n.Node != nil {
+		paths = append(paths, n.Node.Paths()...)
+	}
+	return paths
+}
+
+// Files returns the sorted files currently matching Patterns.
+func (n *GlobNode) Files() []string {
+	var files []string
+	for _, pattern := range n.Patterns {
+		matches, _ := n.Watcher.Glob(pattern)
+		files = append(files, matches...)
+	}
+	slices.Sort(files)
+	return slices.Compact(files)
+}
+
+// Updated calls Updated on Node. Errors encountered while expanding the
+// patterns are returned along with the error from Node.
+func (n *GlobNode) Updated() error {
+	var err error
+	if n.Node != nil {
+		err = n.Node.Updated()
+	}
+	return errors.Join(n.err, err)
+}
+
+// UpdatedPaths implements BatchNode by calling UpdatedPaths on Node with the
+// changed paths other than the directories searched, if Node is a BatchNode,
+// and Updated otherwise. Errors encountered while expanding the patterns are
+// returned along with the error from Node.
+func (n *GlobNode) UpdatedPaths(paths []string) error {
+	b, ok := n.Node.(BatchNode)
+	if !ok {
+		return n.Updated()
+	}
+	var files []string
+	for _, p := range paths {
+		if _, ok := n.dirs[p]; !ok {
+			files = append(files, p)
+		}
+	}
+	return errors.Join(n.err, b.UpdatedPaths(files))
+}
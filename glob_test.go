@@ -0,0 +1,65 @@
+package watch_test
+
+import (
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/chriscraws/watch"
+)
+
+func TestGlob(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.go":                 {},
+		"README.md":               {},
+		"pkg/a.go":                {},
+		"pkg/sub/b.go":            {},
+		"pkg/sub/c.txt":           {},
+		"vendor/x/x.go":           {},
+		"assets/img/logo.png":     {},
+		"assets/img/icons/ok.png": {},
+	}
+	w := &watch.Watcher{FS: fsys}
+	w.Ignore("vendor/")
+	for pattern, want := range map[string][]string{
+		"**/*.go":     {"main.go", "pkg/a.go", "pkg/sub/b.go"},
+		"pkg/*.go":    {"pkg/a.go"},
+		"pkg/**":      {"pkg/a.go", "pkg/sub/b.go", "pkg/sub/c.txt"},
+		"assets/*/*":  {"assets/img/logo.png"},
+		"README.md":   {"README.md"},
+		"missing/*.x": nil,
+	} {
+		got, err := w.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("Glob(%q) should be %v, got %v", pattern, want, got)
+		}
+	}
+}
+
+func TestGlobNode(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{
+		"src":      {Mode: fs.ModeDir | 0o755, ModTime: t0},
+		"src/a.go": {ModTime: t0},
+	}
+	w := &watch.Watcher{FS: fsys}
+	inner := &testNode{}
+	n := &watch.GlobNode{Watcher: w, Patterns: []string{"src/*.go"}, Node: innerNode{inner}}
+	w.Register(n)
+	w.Scan()
+
+	fsys["src/b.go"] = &fstest.MapFile{ModTime: t0}
+	fsys["src"] = &fstest.MapFile{Mode: fs.ModeDir | 0o755, ModTime: t0.Add(time.Second)}
+	w.Scan()
+	if inner.updated != 1 {
+		t.Errorf("creating a matching file should notify the node")
+	}
+	if want := []string{"src/a.go", "src/b.go"}; !slices.Equal(n.Files(), want) {
+		t.Errorf("files should be %v, got %v", want, n.Files())
+	}
+}
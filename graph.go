@@ -0,0 +1,288 @@
+package watch
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// ErrCycle is returned by AddDependency when the dependency would make a
+// Node depend on itself.
+var ErrCycle = errors.New("watch: dependency cycle")
+
+// AddDependency declares that dependent consumes the output of dependency.
+// Whenever Scan notifies dependency and its Updated returns nil, dependent is
+// notified afterwards, even if none of its own paths changed. Nodes are
+// always notified after the nodes they depend on. Dependencies only take
+// effect while both nodes are registered. ErrCycle is returned if dependency
+// already depends on dependent, directly or transitively.
+func (w *Watcher) AddDependency(dependent, dependency Node) error {
+	if !w.initialized {
+		w.init()
+	}
+	if dependent == dependency || w.dependsOn(dependency, dependent) {
+		return ErrCycle
+	}
+	deps := w.deps[dependent]
+	if deps == nil {
+		deps = make(map[Node]struct{})
+		w.deps[dependent] = deps
+	}
+	deps[dependency] = struct{}{}
+	return nil
+}
+
+// RemoveDependency removes a dependency declared with AddDependency.
+func (w *Watcher) RemoveDependency(dependent, dependency Node) {
+	if !w.initialized {
+		w.init()
+	}
+	delete(w.deps[dependent], dependency)
+	if len(w.deps[dependent]) == 0 {
+		delete(w.deps, dependent)
+	}
+}
+
+// Prioritizer is an optional interface for Nodes that should be notified
+// before others, such as nodes reloading configuration that other nodes read.
+// Unless Watcher.Compare is set, nodes with a higher priority are notified
+// first among the nodes whose dependencies have been notified. Nodes that do
+// not implement Prioritizer have priority zero.
+type Prioritizer interface {
+	Node
+
+	// Priority returns the priority of the node.
+	Priority() int
+}
+
+func priority(node Node) int {
+	if p, ok := node.(Prioritizer); ok {
+		return p.Priority()
+	}
+	return 0
+}
+
+// compare orders nodes that are ready to be notified at the same time.
+func (w *Watcher) compare(a, b Node) int {
+	if w.Compare != nil {
+		if c := w.Compare(a, b); c != 0 {
+			return c
+		}
+	} else if c := cmp.Compare(priority(b), priority(a)); c != 0 {
+		return c
+	}
+	return cmp.Compare(w.nodes[a], w.nodes[b])
+}
+
+// sorted returns the registered nodes in registration order.
+func (w *Watcher) sorted() []Node {
+	nodes := make([]Node, 0, len(w.nodes))
+	for node := range w.nodes {
+		nodes = append(nodes, node)
+	}
+	slices.SortFunc(nodes, func(a, b Node) int {
+		return cmp.Compare(w.nodes[a], w.nodes[b])
+	})
+	return nodes
+}
+
+// all returns the set of registered nodes.
+func (w *Watcher) all() map[Node]struct{} {
+	set := make(map[Node]struct{}, len(w.nodes))
+	for node := range w.nodes {
+		set[node] = struct{}{}
+	}
+	return set
+}
+
+// dependsOn reports whether node transitively depends on target.
+func (w *Watcher) dependsOn(node, target Node) bool {
+	seen := map[Node]struct{}{}
+	stack := []Node{node}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for dep := range w.deps[n] {
+			if dep == target {
+				return true
+			}
+			if _, ok := seen[dep]; !ok {
+				seen[dep] = struct{}{}
+				stack = append(stack, dep)
+			}
+		}
+	}
+	return false
+}
+
+// removeNode removes all dependencies to and from node.
+func (w *Watcher) removeNode(node Node) {
+	delete(w.deps, node)
+	for dependent := range w.deps {
+		w.RemoveDependency(dependent, node)
+	}
+}
+
+// order returns the registered nodes in set, together with all registered
+// nodes that transitively depend on them, sorted so that every node comes
+// after its dependencies. Nodes whose dependencies have all been placed are
+// ordered by compare.
+func (w *Watcher) order(set map[Node]struct{}) []Node {
+	dependents := map[Node][]Node{}
+	for node, deps := range w.deps {
+		if _, ok := w.nodes[node]; !ok {
+			continue
+		}
+		for dep := range deps {
+			dependents[dep] = append(dependents[dep], node)
+		}
+	}
+
+	// expand set to include all dependents
+	all := map[Node]struct{}{}
+	var stack []Node
+	for node := range set {
+		all[node] = struct{}{}
+		stack = append(stack, node)
+	}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, dependent := range dependents[n] {
+			if _, ok := all[dependent]; !ok {
+				all[dependent] = struct{}{}
+				stack = append(stack, dependent)
+			}
+		}
+	}
+
+	// topologically sort the expanded set
+	indegree := map[Node]int{}
+	for node := range all {
+		for dep := range w.deps[node] {
+			if _, ok := all[dep]; ok {
+				indegree[node]++
+			}
+		}
+	}
+	var queue, sorted []Node
+	for node := range all {
+		if indegree[node] == 0 {
+			queue = append(queue, node)
+		}
+	}
+	for len(queue) > 0 {
+		slices.SortFunc(queue, w.compare)
+		n := queue[0]
+		queue = queue[1:]
+		sorted = append(sorted, n)
+		for _, dependent := range dependents[n] {
+			if indegree[dependent]--; indegree[dependent] == 0 {
+				queue = append(queue, dependent)
+			}
+		}
+	}
+	return sorted
+}
+
+// notify calls Updated, or UpdatedPaths, on the nodes in updated and on the
+// nodes that depend on them, in dependency order. A dependent is only
+// notified if it was updated itself or if one of its dependencies was
+// notified without error. If Concurrency is greater than one, nodes whose
+// dependencies have all been handled are notified in parallel. notify
+// returns the nodes notified and the nodes that were due to be notified once
+// ctx was done or that were vetoed by Hooks.BeforeUpdate, which are not.
+func (w *Watcher) notify(ctx context.Context, updated map[Node]struct{}) (notified, skipped []Node, errors []error) {
+	var (
+		mu        sync.Mutex
+		succeeded = map[Node]struct{}{}
+	)
+	for _, level := range w.levels(w.order(updated)) {
+		w.dispatch(level, func(node Node) {
+			_, notify := updated[node]
+			reason := ReasonChange
+			if !notify {
+				reason = ReasonDependency
+			}
+			mu.Lock()
+			for dep := range w.deps[node] {
+				if _, ok := succeeded[dep]; ok {
+					notify = true
+					break
+				}
+			}
+			mu.Unlock()
+			if !notify {
+				return
+			}
+			paths := w.notified[node]
+			if ctx.Err() != nil || !w.Hooks.beforeUpdate(node, paths) {
+				mu.Lock()
+				skipped = append(skipped, node)
+				mu.Unlock()
+				return
+			}
+			err := w.timeUpdate(node, func() error { return update(ctx, node, paths, reason) })
+			w.Hooks.afterUpdate(node, paths, err)
+			mu.Lock()
+			defer mu.Unlock()
+			notified = append(notified, node)
+			if err != nil {
+				errors = append(errors, err)
+				return
+			}
+			succeeded[node] = struct{}{}
+		})
+	}
+	return notified, skipped, errors
+}
+
+// levels groups topologically sorted nodes so that every node is in a later
+// group than all of its dependencies. If nodes are notified one at a time,
+// the sorted nodes are returned as a single group to preserve their order.
+func (w *Watcher) levels(sorted []Node) [][]Node {
+	if w.Concurrency <= 1 {
+		return [][]Node{sorted}
+	}
+	level := make(map[Node]int, len(sorted))
+	var levels [][]Node
+	for _, node := range sorted {
+		l := 0
+		for dep := range w.deps[node] {
+			if dl, ok := level[dep]; ok && dl+1 > l {
+				l = dl + 1
+			}
+		}
+		level[node] = l
+		if l == len(levels) {
+			levels = append(levels, nil)
+		}
+		levels[l] = append(levels[l], node)
+	}
+	return levels
+}
+
+// dispatch calls fn for each node, on up to Concurrency goroutines at a time,
+// and returns once all calls have finished.
+func (w *Watcher) dispatch(nodes []Node, fn func(Node)) {
+	if w.Concurrency <= 1 || len(nodes) == 1 {
+		for _, node := range nodes {
+			fn(node)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, w.Concurrency)
+	for _, node := range nodes {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(node)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,164 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Group scans several Watchers together, so that independent sets of nodes,
+// such as assets, configuration files and templates, can share one scan
+// loop. The zero value is an empty Group ready to use. A Group is safe for
+// concurrent use, and its Watchers may still be used on their own.
+type Group struct {
+	// Parallel makes Scan scan the Watchers concurrently, one goroutine per
+	// Watcher. Otherwise they are scanned one after the other, in the order
+	// they were added.
+	Parallel bool
+
+	// Debounce is the debounce period of the Watchers in the group whose
+	// own Debounce is zero.
+	Debounce time.Duration
+
+	// Clock is the source of time used by Run. If nil, the system clock is
+	// used.
+	Clock Clock
+
+	// StatCache, if not nil, is shared by the Watchers in the group that
+	// have no StatCache of their own, and is reset before every Scan.
+	StatCache *StatCache
+
+	mu       sync.Mutex
+	watchers []*Watcher
+	closed   atomic.Bool
+	doneOnce sync.Once
+	done     chan struct{}
+}
+
+// Add adds watchers to the group. It waits for in-progress scans of the
+// watchers to finish, so it must not be called from Updated. It returns
+// ErrClosed after Close.
+func (g *Group) Add(watchers ...*Watcher) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed.Load() {
+		return ErrClosed
+	}
+	for _, w := range watchers {
+		w.busy.Lock()
+		w.group = g
+		w.busy.Unlock()
+		g.watchers = append(g.watchers, w)
+	}
+	return nil
+}
+
+// Watchers returns the Watchers in the group, in the order they were added.
+func (g *Group) Watchers() []*Watcher {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]*Watcher(nil), g.watchers...)
+}
+
+// Scan scans every Watcher in the group. It reports whether any node was
+// updated and returns the errors of all Watchers as one ScanErrors, in the
+// order the Watchers were added.
+func (g *Group) Scan() (bool, ScanErrors) {
+	updated, _, errs := g.ScanContext(context.Background())
+	return updated, errs
+}
+
+// ScanContext is like Scan, but calls ScanContext on every Watcher and
+// returns the paths that were not reached before ctx was done.
+func (g *Group) ScanContext(ctx context.Context) (updated bool, unreached []string, errs ScanErrors) {
+	if g.closed.Load() {
+		return false, nil, []error{ErrClosed}
+	}
+	watchers := g.Watchers()
+	if g.StatCache != nil {
+		g.StatCache.Reset()
+	}
+	type result struct {
+		updated   bool
+		unreached []string
+		errs      ScanErrors
+	}
+	results := make([]result, len(watchers))
+	scan := func(i int) {
+		r := &results[i]
+		sr := watchers[i].scanCycle(ctx, g.StatCache)
+		r.updated, r.unreached, r.errs = sr.Updated, sr.Unreached, sr.Errors
+	}
+	if g.Parallel {
+		var wg sync.WaitGroup
+		for i := range watchers {
+			wg.Go(func() { scan(i) })
+		}
+		wg.Wait()
+	} else {
+		for i := range watchers {
+			scan(i)
+		}
+	}
+	for _, r := range results {
+		updated = updated || r.updated
+		unreached = append(unreached, r.unreached...)
+		errs = append(errs, r.errs...)
+	}
+	return updated, unreached, errs
+}
+
+// Run calls ScanContext with ctx immediately and then on every tick of a
+// ticker with the given interval, until ctx is done or the group is closed.
+// If handle is not nil, it is called with the results of each scan. Run
+// returns the context's error, or ErrClosed.
+func (g *Group) Run(ctx context.Context, interval time.Duration, handle func(updated bool, errs ScanErrors)) error {
+	clock := g.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+	ticker := clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		updated, _, errs := g.ScanContext(ctx)
+		if handle != nil {
+			handle(updated, errs)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-g.doneChan():
+			return ErrClosed
+		case <-ticker.C():
+		}
+	}
+}
+
+// Close stops Run and closes every Watcher in the group, returning their
+// errors joined. Watchers that were already closed are skipped. A second
+// call to Close returns ErrClosed.
+func (g *Group) Close() error {
+	g.mu.Lock()
+	if !g.closed.CompareAndSwap(false, true) {
+		g.mu.Unlock()
+		return ErrClosed
+	}
+	watchers := g.watchers
+	g.mu.Unlock()
+	close(g.doneChan())
+	var errs []error
+	for _, w := range watchers {
+		if err := w.Close(); err != nil && !errors.Is(err, ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// doneChan returns a channel that is closed by Close.
+func (g *Group) doneChan() chan struct{} {
+	g.doneOnce.Do(func() { g.done = make(chan struct{}) })
+	return g.done
+}
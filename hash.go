@@ -0,0 +1,28 @@
+package watch
+
+// Detection selects the strategy a Watcher uses to decide whether a file has
+// changed between calls to Scan.
+type Detection int
+
+const (
+	// DetectModTimeSize reports a change when the modification time, size
+	// or identity (such as the inode number) of a file differs from the
+	// one seen during the previous Scan. Comparing sizes catches quick
+	// successive writes on file systems with a coarse modification time
+	// granularity, such as the 2 seconds of FAT, and comparing identities
+	// catches editors that save by writing a temporary file and renaming it
+	// over the original.
+	DetectModTimeSize Detection = iota
+
+	// DetectHash reports a change only when the digest of a file's contents
+	// differs from the one seen during the previous Scan. Digests are cached
+	// and only recomputed when the modification time, size or identity of
+	// the file changes, so touching a file without modifying it is not
+	// reported.
+	DetectHash
+
+	// DetectModTime is like DetectModTimeSize, but ignores the size of
+	// files, for files whose size changes without their contents changing,
+	// such as preallocated logs.
+	DetectModTime
+)
@@ -0,0 +1,47 @@
+package watch
+
+// Hooks are functions a Watcher calls around scans and notifications, so
+// that applications can log, instrument or veto notifications without
+// wrapping every Node. Nil hooks are skipped. If Concurrency is greater than
+// one, BeforeUpdate and AfterUpdate may be called concurrently.
+type Hooks struct {
+	// BeforeScan is called at the start of Scan, ScanContext, ScanPaths
+	// and ScanNode.
+	BeforeScan func()
+
+	// AfterScan is called at the end of a scan with its statistics.
+	AfterScan func(ScanStats)
+
+	// BeforeUpdate is called before node is notified, with the paths whose
+	// changes caused the notification. Paths is nil if node is notified
+	// because of a dependency or by UpdateAll. If BeforeUpdate returns
+	// false, node is not notified and neither are its dependents, and a
+	// scan notifies it on the next Scan instead.
+	BeforeUpdate func(node Node, paths []string) bool
+
+	// AfterUpdate is called after node is notified, with the error
+	// returned by Updated wrapped in a *NodeError.
+	AfterUpdate func(node Node, paths []string, err error)
+}
+
+func (h *Hooks) beforeScan() {
+	if h.BeforeScan != nil {
+		h.BeforeScan()
+	}
+}
+
+func (h *Hooks) afterScan(s ScanStats) {
+	if h.AfterScan != nil {
+		h.AfterScan(s)
+	}
+}
+
+func (h *Hooks) beforeUpdate(node Node, paths []string) bool {
+	return h.BeforeUpdate == nil || h.BeforeUpdate(node, paths)
+}
+
+func (h *Hooks) afterUpdate(node Node, paths []string, err error) {
+	if h.AfterUpdate != nil {
+		h.AfterUpdate(node, paths, err)
+	}
+}
@@ -0,0 +1,169 @@
+package watch
+
+import (
+	"bufio"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a single gitignore-style pattern.
+type ignoreRule struct {
+	base     []string // directory the pattern is relative to
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// Ignore adds gitignore-style exclusion patterns. Paths matching a pattern,
+// or inside a directory matching one, are skipped by Scan and by the helpers
+// in this package that walk directories. The syntax follows .gitignore:
+//
+//   - a pattern without a slash, such as "*.tmp" or "node_modules", matches
+//     a file or directory name at any depth
+//   - a pattern containing a slash, such as "/build" or "docs/*.html", is
+//     matched against the whole path
+//   - a trailing slash, as in "out/", only matches directories
+//   - "**" matches any number of directories
+//   - a leading "!" re-includes paths excluded by an earlier pattern
+//
+// Patterns are matched against paths as returned by Node.Paths. Blank
+// patterns and patterns starting with "#" are ignored.
+func (w *Watcher) Ignore(patterns ...string) {
+	for _, p := range patterns {
+		if rule, ok := parseIgnoreRule(nil, p); ok {
+			w.ignore = append(w.ignore, rule)
+		}
+	}
+}
+
+// IgnoreFile adds the patterns listed in a .gitignore or .watchignore style
+// file, one per line. Patterns are relative to the directory containing the
+// file.
+func (w *Watcher) IgnoreFile(name string) error {
+	f, err := w.open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	base := splitPath(path.Dir(filepath.ToSlash(name)))
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(base, scanner.Text()); ok {
+			w.ignore = append(w.ignore, rule)
+		}
+	}
+	return scanner.Err()
+}
+
+// Ignored reports whether path is excluded by the patterns added with Ignore
+// or IgnoreFile, or is outside the prefix of a Scope. Directory walkers
+// should call Ignored on directories to skip their contents.
+func (w *Watcher) Ignored(path string) bool {
+	return w.ignored(path, false)
+}
+
+// ignored reports whether p is excluded. If isDir is set, p is known to be a
+// directory and may be matched by directory-only patterns.
+func (w *Watcher) ignored(p string, isDir bool) bool {
+	if len(w.ignore) == 0 && len(w.prefix) == 0 {
+		return false
+	}
+	segments := splitPath(filepath.ToSlash(p))
+	if w.outOfScope(segments, isDir) {
+		return true
+	}
+	ignored := false
+	for _, rule := range w.ignore {
+		if rule.negate == ignored && rule.match(segments, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func parseIgnoreRule(base []string, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	rule.segments = splitPath(line)
+	if len(rule.segments) == 0 {
+		return ignoreRule{}, false
+	}
+	if !anchored {
+		rule.segments = append([]string{"**"}, rule.segments...)
+	}
+	return rule, true
+}
+
+// match reports whether the rule matches the path with the given segments or
+// any of its parent directories.
+func (r *ignoreRule) match(segments []string, isDir bool) bool {
+	if len(segments) < len(r.base) {
+		return false
+	}
+	for i, b := range r.base {
+		if segments[i] != b {
+			return false
+		}
+	}
+	segments = segments[len(r.base):]
+	for n := 1; n <= len(segments); n++ {
+		if r.dirOnly && n == len(segments) && !isDir {
+			break
+		}
+		if matchSegments(r.segments, segments[:n]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against pattern segments, where each
+// pattern segment uses path.Match syntax and "**" matches zero or more
+// segments.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(name); i >= 0; i-- {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// splitPath splits a slash-separated path into its non-empty segments,
+// dropping "." segments.
+func splitPath(p string) []string {
+	var segments []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" && s != "." {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
@@ -0,0 +1,57 @@
+package watch_test
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/chriscraws/watch"
+)
+
+func TestIgnored(t *testing.T) {
+	w := new(watch.Watcher)
+	w.Ignore("node_modules", "*.swp", "/build", "out/", "docs/**/*.html", "!docs/keep/index.html", "# comment", "")
+	for path, want := range map[string]bool{
+		"main.go":                    false,
+		"node_modules/x/index.js":    true,
+		"web/node_modules/y.js":      true,
+		"src/.main.go.swp":           true,
+		"build/app":                  true,
+		"src/build/app":              false,
+		"out/bin":                    true,
+		"out":                        false,
+		"docs/a/b/page.html":         true,
+		"docs/page.html":             true,
+		"docs/keep/index.html":       false,
+		"/abs/project/node_modules/": true,
+	} {
+		if got := w.Ignored(path); got != want {
+			t.Errorf("Ignored(%q) should be %v", path, want)
+		}
+	}
+}
+
+func TestIgnoreFile(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{
+		"proj/.watchignore": {Data: []byte("# generated\n*.gen.go\n/tmp/\n")},
+		"proj/a.go":         {ModTime: t0},
+		"proj/a.gen.go":     {ModTime: t0},
+	}
+	w := &watch.Watcher{FS: fsys}
+	if err := w.IgnoreFile("proj/.watchignore"); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Ignored("proj/tmp/x") || w.Ignored("tmp/x") || !w.Ignored("proj/sub/b.gen.go") {
+		t.Errorf("patterns should be relative to the ignore file")
+	}
+
+	n := testNode{path: "proj/a.go", deps: []string{"proj/a.gen.go"}}
+	w.Register(&n)
+	w.Scan()
+	fsys["proj/a.gen.go"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+	if n.updated != 0 {
+		t.Errorf("changes to ignored paths should not notify")
+	}
+}
@@ -0,0 +1,136 @@
+package watch
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// DefaultJournalLimit is the number of events kept by a Journal whose Limit
+// is zero.
+const DefaultJournalLimit = 1024
+
+// Journal is an append-only log of the events detected by the Watcher it is
+// set on, numbered with monotonic sequence numbers, so that clients that
+// missed notifications, such as reconnecting live-reload clients, can catch
+// up with Since instead of reloading everything. The zero value is an empty
+// in-memory journal. A Journal is safe for concurrent use.
+type Journal struct {
+	// Limit is the number of most recent events kept in memory. If zero,
+	// DefaultJournalLimit is used.
+	Limit int
+
+	// Writer, if not nil, receives every event as a line of JSON, for a
+	// journal that persists across restarts with Load.
+	Writer io.Writer
+
+	mu     sync.Mutex
+	events []Event
+	seq    uint64
+}
+
+// Seq returns the sequence number of the last event, or zero if there are
+// none.
+func (j *Journal) Seq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+// Since returns the events with a sequence number greater than seq, oldest
+// first. Use Covers to check that none were dropped.
+func (j *Journal) Since(seq uint64) []Event {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	i, _ := slices.BinarySearchFunc(j.events, seq+1, func(e Event, seq uint64) int {
+		return cmp.Compare(e.Seq, seq)
+	})
+	return slices.Clone(j.events[i:])
+}
+
+// Covers reports whether every event after seq is still in the journal, so
+// that Since(seq) is complete. It returns false if seq is greater than the
+// last sequence number, for clients of a previous journal.
+func (j *Journal) Covers(seq uint64) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if seq >= j.seq {
+		return seq == j.seq
+	}
+	return len(j.events) > 0 && seq+1 >= j.events[0].Seq
+}
+
+// Load appends the events written to Writer by a previous journal, read
+// from r, and continues their numbering.
+func (j *Journal) Load(r io.Reader) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("watch: journal: %w", err)
+		}
+		if e.Seq <= j.seq {
+			return fmt.Errorf("watch: journal: event %d out of order", e.Seq)
+		}
+		j.seq = e.Seq
+		j.add(e)
+	}
+	return scanner.Err()
+}
+
+// append numbers events, records them and writes them to Writer.
+func (j *Journal) append(events []Event) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i := range events {
+		j.seq++
+		events[i].Seq = j.seq
+		j.add(events[i])
+		if j.Writer != nil {
+			data, err := json.Marshal(events[i])
+			if err != nil {
+				return err
+			}
+			if _, err := j.Writer.Write(append(data, '\n')); err != nil {
+				return fmt.Errorf("watch: journal: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// journal numbers events and appends them to the Journal, except for the
+// events already appended by the same scan, listed in seen, which are given
+// the same sequence numbers. Renames are reported by both of their paths.
+func (w *Watcher) journal(events []Event, seen map[Event]uint64) error {
+	for i := range events {
+		key := events[i]
+		if seq, ok := seen[key]; ok {
+			events[i].Seq = seq
+			continue
+		}
+		if err := w.Journal.append(events[i : i+1]); err != nil {
+			return err
+		}
+		seen[key] = events[i].Seq
+	}
+	return nil
+}
+
+// add records e, dropping the oldest event if the journal is full.
+func (j *Journal) add(e Event) {
+	limit := j.Limit
+	if limit <= 0 {
+		limit = DefaultJournalLimit
+	}
+	if len(j.events) >= limit {
+		j.events = slices.Delete(j.events, 0, len(j.events)-limit+1)
+	}
+	j.events = append(j.events, e)
+}
@@ -0,0 +1,39 @@
+package watch
+
+// Attacher is an optional interface for Nodes that set themselves up when
+// they are registered, such as by parsing their root file or opening a
+// backend watch, rather than lazily on their first Updated. Attached is
+// called by Register, RegisterWithOptions and RegisterFS once the node is
+// registered, so it may add dependencies on other registered nodes. It is
+// not called again if the node is registered a second time.
+type Attacher interface {
+	Node
+
+	// Attached is called when the node is registered with w.
+	Attached(w *Watcher)
+}
+
+// Detacher is an optional interface for Nodes that release resources when
+// they stop being watched. Detached is called by Unregister, and by Close
+// for every node still registered, after the node has been removed. It must
+// not call the scanning methods of the Watcher.
+type Detacher interface {
+	Node
+
+	// Detached is called when the node is unregistered.
+	Detached()
+}
+
+// attached calls Attached on node if it is an Attacher.
+func (w *Watcher) attached(node Node) {
+	if a, ok := node.(Attacher); ok {
+		a.Attached(w)
+	}
+}
+
+// detached calls Detached on node if it is a Detacher.
+func detached(node Node) {
+	if d, ok := node.(Detacher); ok {
+		d.Detached()
+	}
+}
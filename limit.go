@@ -0,0 +1,105 @@
+package watch
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// maxLimitNodes is the number of nodes listed by a PathLimitError.
+const maxLimitNodes = 10
+
+// PathLimitError is reported by Scan when the registered nodes reference more
+// distinct paths than Watcher.MaxPaths. Only the first MaxPaths paths are
+// tracked; the others are neither checked nor kept in memory.
+type PathLimitError struct {
+	// Limit is the Watcher's MaxPaths.
+	Limit int
+
+	// Paths is the number of distinct paths the nodes reference.
+	Paths int
+
+	// Nodes lists the nodes referencing the most paths, most first, to find
+	// the bad glob or recursive watch responsible.
+	Nodes []NodePaths
+}
+
+// NodePaths is the number of paths referenced by a node.
+type NodePaths struct {
+	Node  Node
+	Paths int
+
+	// Patterns lists the patterns of the node if it is a GlobNode.
+	Patterns []string
+}
+
+func (e *PathLimitError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "watch: %d paths exceed the limit of %d", e.Paths, e.Limit)
+	for i, n := range e.Nodes {
+		if i == 0 {
+			b.WriteString("; most by ")
+		} else {
+			b.WriteString(", ")
+		}
+		b.WriteString(NodeName(n.Node))
+		if n.Patterns != nil {
+			fmt.Fprintf(&b, " %q", n.Patterns)
+		}
+		fmt.Fprintf(&b, " (%d)", n.Paths)
+	}
+	return b.String()
+}
+
+// pathLimit tracks the paths dropped by a scan that exceeds MaxPaths.
+type pathLimit struct {
+	counts  []NodePaths
+	dropped map[pathKey]struct{}
+}
+
+// admit reports whether a new path with key may be tracked by s, recording it
+// as dropped otherwise.
+func (w *Watcher) admit(s *scan, key pathKey) bool {
+	if w.MaxPaths <= 0 || len(s.entries) < w.MaxPaths {
+		return true
+	}
+	if s.limit == nil {
+		s.limit = new(pathLimit)
+	}
+	if s.limit.dropped == nil {
+		s.limit.dropped = make(map[pathKey]struct{})
+	}
+	s.limit.dropped[key] = struct{}{}
+	return false
+}
+
+// count records the number of paths node references, if MaxPaths is set.
+func (w *Watcher) count(s *scan, node Node, paths int) {
+	if w.MaxPaths <= 0 {
+		return
+	}
+	if s.limit == nil {
+		s.limit = new(pathLimit)
+	}
+	s.limit.counts = append(s.limit.counts, NodePaths{Node: node, Paths: paths})
+}
+
+// limitError returns the PathLimitError of s, or nil if s did not exceed
+// MaxPaths.
+func (w *Watcher) limitError(s *scan) *PathLimitError {
+	if s.limit == nil || len(s.limit.dropped) == 0 {
+		return nil
+	}
+	nodes := slices.Clone(s.limit.counts)
+	slices.SortStableFunc(nodes, func(a, b NodePaths) int {
+		return cmp.Compare(b.Paths, a.Paths)
+	})
+	nodes = nodes[:min(len(nodes), maxLimitNodes)]
+	for i := range nodes {
+		if g, ok := nodes[i].Node.(*GlobNode); ok {
+			nodes[i].Patterns = g.Patterns
+		}
+	}
+	return &PathLimitError{Limit: w.MaxPaths, Paths: len(s.entries) + len(s.limit.dropped), Nodes: nodes}
+}
@@ -0,0 +1,252 @@
+package watch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ManifestEntry is an entry of a manifest: the files to watch and the
+// command to run when they change.
+type ManifestEntry struct {
+	// Name identifies the entry in errors.
+	Name string `json:"name,omitempty"`
+
+	// Patterns lists the glob patterns of the files to watch, in the
+	// syntax of Watcher.Glob.
+	Patterns []string `json:"patterns"`
+
+	// Command is the program to run and its arguments, as for CommandNode.
+	Command []string `json:"command,omitempty"`
+
+	// Restart runs the command in the background, restarting it on every
+	// change, as for CommandNode.
+	Restart bool `json:"restart,omitempty"`
+
+	// Tags label the entry, for selecting entries with Manifest.Tags.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Manifest is a Node that configures its Watcher from a manifest file
+// listing path patterns and the command to run when the matching files
+// change, and reconfigures it whenever the manifest changes, so that the set
+// of watched files can be changed without restarting the process. Each
+// entry is registered as a GlobNode notifying the node returned by NewNode.
+// Entries that are unchanged when the manifest is reloaded keep their
+// nodes, so their commands are not restarted; the nodes of removed entries
+// are unregistered, and detached if they are Detachers. A reload made by
+// Updated is applied with BatchLater, once the scan has notified its nodes.
+// A Manifest is an ExistingNotifier, so the first Scan after it is
+// registered loads it.
+//
+// A manifest whose name ends in ".json" is a JSON array of ManifestEntry
+// objects. Any other manifest is text with one entry per line, listing its
+// patterns, then optional tags prefixed with "@", then "--" and the command:
+//
+//	# run the tests when Go files change
+//	**/*.go @test -- go test ./...
+//	web/**/*.ts -- npm run build
+//
+// Fields are separated by spaces and cannot be quoted; use JSON for
+// arguments containing spaces. Blank lines and lines starting with "#" are
+// skipped. A manifest that fails to read or parse is reported, and the
+// previous configuration is kept.
+//
+//	m := &watch.Manifest{Watcher: w, Path: "watch.json", Stdout: os.Stdout, Stderr: os.Stderr}
+//	w.Register(m)
+type Manifest struct {
+	// Watcher is the Watcher configured by the manifest.
+	Watcher *Watcher
+
+	// Path is the path of the manifest in the Watcher's file system.
+	Path string
+
+	// Tags, if not empty, selects the entries having at least one of them.
+	Tags []string
+
+	// NewNode, if not nil, returns the node notified when the files of
+	// entry change. If nil, a CommandNode running the entry's command is
+	// used, and entries without a command are rejected.
+	NewNode func(entry ManifestEntry) (Node, error)
+
+	// Stdout and Stderr are connected to the commands of the default
+	// CommandNodes. If nil, they are connected to the null device.
+	Stdout, Stderr io.Writer
+
+	mu      sync.Mutex
+	entries []ManifestEntry
+	globs   []*GlobNode
+}
+
+// Paths implements Node.
+func (m *Manifest) Paths() []string {
+	return []string{m.Path}
+}
+
+// NotifyExisting implements ExistingNotifier by returning true.
+func (*Manifest) NotifyExisting() bool {
+	return true
+}
+
+// Updated implements Node by reloading the manifest.
+func (m *Manifest) Updated() error {
+	return m.reload(false)
+}
+
+// Load reads the manifest and registers its entries with the Watcher right
+// away, rather than on the next Scan, such as to use the entries' nodes
+// before scanning.
+func (m *Manifest) Load() error {
+	return m.reload(true)
+}
+
+// Entries returns the entries of the manifest currently in use.
+func (m *Manifest) Entries() []ManifestEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.entries)
+}
+
+// reload reads the manifest and applies it with Batch, or with BatchLater if
+// it is called from Updated, during a Scan.
+func (m *Manifest) reload(batch bool) error {
+	entries, err := m.read()
+	if err != nil {
+		return fmt.Errorf("watch: manifest %s: %w", m.Path, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// reuse the nodes of unchanged entries
+	globs := make([]*GlobNode, len(entries))
+	stale := slices.Clone(m.globs)
+	old := slices.Clone(m.entries)
+	for i, e := range entries {
+		if j := slices.IndexFunc(old, func(o ManifestEntry) bool { return reflect.DeepEqual(o, e) }); j >= 0 {
+			globs[i] = stale[j]
+			old = slices.Delete(old, j, j+1)
+			stale = slices.Delete(stale, j, j+1)
+		}
+	}
+	for i, e := range entries {
+		if globs[i] != nil {
+			continue
+		}
+		node, err := m.node(e)
+		if err != nil {
+			return fmt.Errorf("watch: manifest %s: %s: %w", m.Path, entryName(e, i), err)
+		}
+		globs[i] = &GlobNode{Watcher: m.Watcher, Patterns: e.Patterns, Node: node}
+	}
+
+	apply := func(tx *Tx) {
+		for _, g := range stale {
+			tx.Unregister(g)
+		}
+		for _, g := range globs {
+			tx.Register(g)
+		}
+	}
+	detach := func() {
+		for _, g := range stale {
+			detached(g.Node)
+		}
+	}
+	if !batch {
+		// the next reload can only be made by a later Scan, after the
+		// batch is applied
+		m.Watcher.BatchLater(func(tx *Tx) {
+			apply(tx)
+			detach()
+		})
+		m.entries, m.globs = entries, globs
+		return nil
+	}
+	if err := m.Watcher.Batch(apply); err != nil {
+		return err
+	}
+	detach()
+	m.entries, m.globs = entries, globs
+	return nil
+}
+
+// node returns the node notified for entry.
+func (m *Manifest) node(e ManifestEntry) (Node, error) {
+	if m.NewNode != nil {
+		return m.NewNode(e)
+	}
+	if len(e.Command) == 0 {
+		return nil, errors.New("no command")
+	}
+	return &CommandNode{Command: e.Command, Restart: e.Restart, Stdout: m.Stdout, Stderr: m.Stderr}, nil
+}
+
+// read reads and parses the manifest, keeping the entries selected by Tags.
+func (m *Manifest) read() ([]ManifestEntry, error) {
+	data, err := ReadFile(m.Watcher.fsys(), m.Path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ManifestEntry
+	if strings.EqualFold(path.Ext(strings.ReplaceAll(m.Path, `\`, "/")), ".json") {
+		err = json.Unmarshal(data, &entries)
+	} else {
+		entries, err = parseManifest(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range entries {
+		if len(e.Patterns) == 0 {
+			return nil, fmt.Errorf("%s: no patterns", entryName(e, i))
+		}
+	}
+	if len(m.Tags) > 0 {
+		entries = slices.DeleteFunc(entries, func(e ManifestEntry) bool {
+			return !slices.ContainsFunc(e.Tags, func(tag string) bool { return slices.Contains(m.Tags, tag) })
+		})
+	}
+	return entries, nil
+}
+
+// parseManifest parses a text manifest.
+func parseManifest(data []byte) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var e ManifestEntry
+		if i := slices.Index(fields, "--"); i >= 0 {
+			e.Command = fields[i+1:]
+			fields = fields[:i]
+		}
+		for _, f := range fields {
+			if tag, ok := strings.CutPrefix(f, "@"); ok {
+				e.Tags = append(e.Tags, tag)
+			} else {
+				e.Patterns = append(e.Patterns, f)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// entryName names the entry at index i in errors.
+func entryName(e ManifestEntry, i int) string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return fmt.Sprintf("entry %d", i)
+}
@@ -0,0 +1,109 @@
+package watch
+
+import (
+	"errors"
+	"maps"
+	"time"
+)
+
+// ScanStats describes a single call to Scan, ScanPaths or ScanNode.
+type ScanStats struct {
+	Duration time.Duration // measured with the Watcher's Clock
+	Paths    int           // paths statted
+	Changes  int           // paths found changed
+	Notified int           // nodes notified, including dependents
+	Errors   int           // errors returned
+}
+
+// Stats holds counters accumulated over the lifetime of a Watcher.
+type Stats struct {
+	Nodes int // nodes registered at the end of the last scan
+	Paths int // paths tracked at the end of the last scan
+
+	Scans        uint64
+	Stats        uint64 // paths statted
+	Changes      uint64 // paths found changed
+	Notified     uint64 // nodes notified
+	Errors       uint64 // errors returned
+	ScanDuration time.Duration
+	LastScan     ScanStats
+
+	// Interval is the polling interval currently used by Run, which varies
+	// with Watcher.Adaptive, or zero if Run is not running.
+	Interval time.Duration
+
+	// NodeErrors counts the errors returned by the updates of each node, by
+	// Scan and UpdateAll. Like the other counters, the counts only grow:
+	// they are kept after a node is unregistered.
+	NodeErrors map[Node]uint64
+}
+
+// Metrics receives statistics about every scan, so they can be exported to a
+// monitoring system without this package depending on one. ObserveScan is
+// called synchronously at the end of each scan and should not block.
+type Metrics interface {
+	ObserveScan(stats ScanStats)
+}
+
+// Stats returns the counters accumulated by the Watcher. It may be called
+// concurrently with Scan.
+func (w *Watcher) Stats() Stats {
+	w.statsMu.Lock()
+	defer w.statsMu.Unlock()
+	stats := w.stats
+	stats.NodeErrors = maps.Clone(stats.NodeErrors)
+	return stats
+}
+
+// countNodeErrors counts the errors returned by node updates in errs.
+func (w *Watcher) countNodeErrors(errs []error) {
+	w.statsMu.Lock()
+	defer w.statsMu.Unlock()
+	for _, err := range errs {
+		var e *NodeError
+		if !errors.As(err, &e) || e.Node == nil {
+			continue
+		}
+		if w.stats.NodeErrors == nil {
+			w.stats.NodeErrors = make(map[Node]uint64)
+		}
+		w.stats.NodeErrors[e.Node]++
+	}
+}
+
+// record accumulates the statistics of a scan, reports them to Metrics and
+// returns them.
+func (w *Watcher) record(s *scan, start time.Time, notified, errors int) ScanStats {
+	scan := ScanStats{
+		Duration: w.clock().Now().Sub(start),
+		Notified: notified,
+		Errors:   errors,
+	}
+	for _, e := range s.entries {
+		if !e.skip {
+			scan.Paths++
+		}
+		if e.updated {
+			scan.Changes++
+		}
+	}
+	w.statsMu.Lock()
+	w.stats.Nodes = len(w.nodes)
+	w.stats.Paths = len(w.paths)
+	w.stats.Scans++
+	w.stats.Stats += uint64(scan.Paths)
+	w.stats.Changes += uint64(scan.Changes)
+	w.stats.Notified += uint64(scan.Notified)
+	w.stats.Errors += uint64(scan.Errors)
+	w.stats.ScanDuration += scan.Duration
+	w.stats.LastScan = scan
+	w.statsMu.Unlock()
+	if p := w.profile.Load(); p != nil {
+		p.scanned()
+	}
+	if w.Metrics != nil {
+		w.Metrics.ObserveScan(scan)
+	}
+	w.Hooks.afterScan(scan)
+	return scan
+}
@@ -0,0 +1,41 @@
+package watch
+
+import "slices"
+
+// funcNode is the Node returned by NodeFunc, File and Files. It is always
+// used through a pointer, so that every call returns a distinct Node that can
+// be registered and unregistered on its own.
+type funcNode struct {
+	paths   func() []string
+	updated func() error
+}
+
+func (n *funcNode) Paths() []string { return n.paths() }
+
+func (n *funcNode) Updated() error {
+	if n.updated == nil {
+		return nil
+	}
+	return n.updated()
+}
+
+// NodeFunc returns a Node whose Paths and Updated methods call paths and
+// updated. If updated is nil, Updated does nothing.
+func NodeFunc(paths func() []string, updated func() error) Node {
+	return &funcNode{paths: paths, updated: updated}
+}
+
+// File returns a Node that watches a single path and calls fn when it
+// changes:
+//
+//	w.Register(watch.File("config.yaml", reload))
+func File(path string, fn func() error) Node {
+	return Files([]string{path}, fn)
+}
+
+// Files returns a Node that watches a fixed set of paths and calls fn when
+// any of them changes.
+func Files(paths []string, fn func() error) Node {
+	paths = slices.Clone(paths)
+	return NodeFunc(func() []string { return paths }, fn)
+}
@@ -0,0 +1,122 @@
+package watch
+
+import (
+	"reflect"
+	"time"
+)
+
+// NodeOptions overrides the Watcher's defaults for a single node. The zero
+// value uses the defaults.
+type NodeOptions struct {
+	// Interval is the minimum time between checks of the node's paths,
+	// for nodes that can be scanned less often than the Scan loop runs,
+	// such as nodes watching large assets. Scans that happen sooner skip
+	// the node's paths, unless another node that is due references them.
+	// If zero, the paths are checked on every Scan.
+	Interval time.Duration
+
+	// Debounce overrides Watcher.Debounce for the node if it is not zero.
+	Debounce time.Duration
+
+	// RateLimit overrides Watcher.RateLimit for the node if it is not
+	// zero.
+	RateLimit time.Duration
+
+	// Detect overrides Watcher.Detect for the node's paths if it is not
+	// nil. Detection is done per path, so a path referenced by several
+	// nodes is hashed if any of them uses DetectHash. Only the nodes using
+	// DetectHash ignore a change whose digest shows that the contents did
+	// not change, such as a touch; the others are still notified, so
+	// verifying expensive assets can be limited to the nodes that need it.
+	Detect *Detection
+
+	// UpdateRetry overrides Watcher.UpdateRetry for the node if it is not
+	// nil.
+	UpdateRetry *UpdateRetryPolicy
+
+	// Filter overrides Watcher.Filter for the node if it is not nil. A
+	// filter that returns true delivers every event to the node, even if
+	// the Watcher has a Filter.
+	Filter func(path string, op Op) bool
+}
+
+// RegisterWithOptions registers node like Register, with options that
+// override the Watcher's defaults. If node is already registered, its
+// options are replaced.
+func (w *Watcher) RegisterWithOptions(node Node, opts NodeOptions) error {
+	if err := w.Register(node); err != nil {
+		return err
+	}
+	if reflect.ValueOf(opts).IsZero() {
+		delete(w.options, node)
+	} else {
+		w.options[node] = opts
+	}
+	return nil
+}
+
+// debounce returns the debounce period of node.
+func (w *Watcher) debounce(node Node) time.Duration {
+	if d := w.options[node].Debounce; d != 0 {
+		return d
+	}
+	if w.Debounce == 0 && w.group != nil {
+		return w.group.Debounce
+	}
+	return w.Debounce
+}
+
+// rateLimit returns the minimum time between notifications of node.
+func (w *Watcher) rateLimit(node Node) time.Duration {
+	if d := w.options[node].RateLimit; d != 0 {
+		return d
+	}
+	return w.RateLimit
+}
+
+// detection returns the detection strategy for a path referenced by nodes,
+// and whether only some of them use DetectHash.
+func (w *Watcher) detection(nodes []Node) (detect Detection, mixed bool) {
+	detect = w.Detect
+	if w.Detector != nil {
+		return detect, false
+	}
+	hashed := 0
+	for i, node := range nodes {
+		d := w.nodeDetection(node)
+		if i == 0 || d == DetectHash {
+			detect = d
+		}
+		if d == DetectHash {
+			hashed++
+		}
+	}
+	return detect, hashed > 0 && hashed < len(nodes)
+}
+
+// updateRetry returns the policy for retrying failed updates of node.
+func (w *Watcher) updateRetry(node Node) UpdateRetryPolicy {
+	if o := w.options[node].UpdateRetry; o != nil {
+		return *o
+	}
+	return w.UpdateRetry
+}
+
+// nodeDetection returns the Detection that applies to the paths of node.
+func (w *Watcher) nodeDetection(node Node) Detection {
+	if o := w.options[node].Detect; o != nil {
+		return *o
+	}
+	return w.Detect
+}
+
+// due reports whether the paths of node should be checked by a Scan starting
+// at now.
+func (w *Watcher) due(node Node, now time.Time) bool {
+	interval := w.options[node].Interval
+	if interval <= 0 {
+		return true
+	}
+	last, ok := w.checked[node]
+	return !ok || now.Sub(last) >= interval
+}
@@ -0,0 +1,52 @@
+package watch
+
+import "io/fs"
+
+// Producer is an optional interface for Nodes that write files, such as build
+// steps writing into a watched directory. After a Producer is notified, the
+// Watcher records the state of its outputs and of the directories containing
+// them, and does not notify the node for changes to those paths as long as
+// they are still in the recorded state, so that a node does not trigger
+// itself. Other nodes watching the outputs are notified as usual, and so is
+// the Producer if the outputs are modified again after its Updated returns.
+type Producer interface {
+	Node
+
+	// Outputs returns the paths of the files written by Updated.
+	Outputs() []string
+}
+
+// absorb records the state of the outputs of the Producers in nodes, and of
+// their parent directories.
+func (w *Watcher) absorb(nodes []Node) {
+	for _, node := range nodes {
+		p, ok := node.(Producer)
+		if !ok {
+			continue
+		}
+		fsys := w.rootFS(w.nodeRoot[node])
+		produced := make(map[string]fs.FileInfo)
+		for _, out := range p.Outputs() {
+			out = w.normalize(out)
+			for _, path := range []string{out, w.normalize(dirPath(fsys, out))} {
+				if info, err := statFile(fsys, path); err == nil {
+					produced[path] = info
+				}
+			}
+		}
+		w.produced[node] = produced
+	}
+}
+
+// verified reports whether node ignores the change detected in e because
+// its digest showed that the contents of the file did not change.
+func (w *Watcher) verified(node Node, e *scanEntry) bool {
+	return e.touched && w.nodeDetection(node) == DetectHash
+}
+
+// ownChange reports whether the change detected in e was made by the last
+// Updated call of node.
+func (w *Watcher) ownChange(node Node, e *scanEntry) bool {
+	info, ok := w.produced[node][e.path]
+	return ok && e.info != nil && info.ModTime().Equal(e.info.ModTime()) && sameFile(info, e.info)
+}
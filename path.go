@@ -0,0 +1,36 @@
+package watch
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// CleanPath is a PathNormalizer that converts the separators of p to
+// slashes and cleans it with path.Clean, so that "./a/../b.txt" and
+// "b.txt" are the same path. Slash-separated paths are accepted by both
+// fs.FS implementations and the operating system's file system on Windows.
+// The leading double slash of a UNC path, such as \\server\share, is kept.
+func CleanPath(p string) string {
+	p = filepath.ToSlash(p)
+	if strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "///") {
+		return "/" + path.Clean(p)
+	}
+	return path.Clean(p)
+}
+
+// FoldCase is a PathNormalizer for case-insensitive file systems, such as
+// the default file systems of macOS and Windows. It cleans p like CleanPath
+// and converts it to lower case, so that "Foo.go" and "foo.go" are the same
+// path.
+func FoldCase(p string) string {
+	return strings.ToLower(CleanPath(p))
+}
+
+// normalize returns the name of p in the path table.
+func (w *Watcher) normalize(p string) string {
+	if w.PathNormalizer == nil {
+		return p
+	}
+	return w.PathNormalizer(p)
+}
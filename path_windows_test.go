@@ -0,0 +1,39 @@
+package watch_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+)
+
+func TestLongPath(t *testing.T) {
+	dir := t.TempDir()
+	for len(dir) < 300 {
+		dir = filepath.Join(dir, strings.Repeat("d", 50))
+	}
+	if err := os.MkdirAll(`\\?\`+dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(`\\?\`+name, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := new(watch.Watcher)
+	n := &testNode{path: name}
+	w.Register(n)
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatalf("scan should stat long paths, got %v", errs)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(`\\?\`+name, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if updated, errs := w.Scan(); !updated || len(errs) > 0 {
+		t.Errorf("change to a long path should be detected, got %v, %v", updated, errs)
+	}
+}
@@ -0,0 +1,63 @@
+package watch
+
+import (
+	"context"
+	"sync"
+)
+
+// Poller is an optional interface for Nodes that detect changes themselves
+// rather than through the paths they return, such as nodes watching remote
+// resources. Scan calls Poll on every registered Poller while it stats
+// paths, and notifies the node if Poll reports a change, exactly as if one of
+// its paths had changed. Poll may be called concurrently with other Pollers
+// if StatConcurrency is greater than one.
+type Poller interface {
+	Node
+
+	// Poll reports whether the resource watched by the node has changed
+	// since the previous call to Poll.
+	Poll() (bool, error)
+}
+
+// ContextPoller is an optional interface for Pollers whose polls can be
+// cancelled. Scan calls PollContext instead of Poll, with the context passed
+// to ScanContext, or context.Background for Scan.
+type ContextPoller interface {
+	Poller
+
+	// PollContext is called in place of Poll. It should return promptly
+	// once ctx is done.
+	PollContext(ctx context.Context) (bool, error)
+}
+
+// poll calls Poll, or PollContext with ctx, on all registered Pollers and
+// records the nodes reporting a change in s. Errors are recorded as
+// *StatError values whose Path is the node's String method, if it has one.
+func (w *Watcher) poll(ctx context.Context, s *scan) {
+	var pollers []Poller
+	for _, node := range w.sorted() {
+		if p, ok := node.(Poller); ok {
+			pollers = append(pollers, p)
+		}
+	}
+	var mu sync.Mutex
+	forEach(len(pollers), w.StatConcurrency, func(i int) {
+		var (
+			changed bool
+			err     error
+		)
+		if p, ok := pollers[i].(ContextPoller); ok {
+			changed, err = p.PollContext(ctx)
+		} else {
+			changed, err = pollers[i].Poll()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if changed {
+			s.polled = append(s.polled, pollers[i])
+		}
+		if err != nil {
+			s.errors = append(s.errors, &StatError{Path: NodeName(pollers[i]), Err: err})
+		}
+	})
+}
@@ -0,0 +1,244 @@
+package watch
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Profile starts or stops profiling the Watcher's scans. While profiling,
+// every scan records how long it spends statting each path, in the Paths
+// method of each node and in each node's update, so that ProfileReport can
+// point at what makes a scan slow, such as paths on a network mount or a
+// glob matching a huge tree. Enabling profiling starts a new profile;
+// disabling it keeps the profile for ProfileReport. Times are measured with
+// the Watcher's Clock. Profile may be called concurrently with Scan.
+func (w *Watcher) Profile(enabled bool) {
+	if enabled {
+		w.profile.Store(&profiler{start: w.clock().Now()})
+		return
+	}
+	if p := w.profile.Swap(nil); p != nil {
+		p.mu.Lock()
+		p.end = w.clock().Now()
+		p.mu.Unlock()
+		w.lastProfile.Store(p)
+	}
+}
+
+// ProfileReport returns the report of the current profile, or of the last
+// one if profiling is disabled, or nil if the Watcher was never profiled. It
+// may be called concurrently with Scan.
+func (w *Watcher) ProfileReport() *ProfileReport {
+	p := w.profile.Load()
+	if p == nil {
+		p = w.lastProfile.Load()
+	}
+	if p == nil {
+		return nil
+	}
+	return p.report(w.clock().Now())
+}
+
+// ProfileReport summarizes the time spent by the scans of a profile, with the
+// slowest paths and nodes first.
+type ProfileReport struct {
+	Start    time.Time     // when profiling started
+	Duration time.Duration // the time profiled
+	Scans    int           // the scans profiled
+
+	// Paths lists the paths statted, by decreasing total stat time. A path
+	// watched in several file systems is listed once.
+	Paths []PathProfile
+
+	// Nodes lists the nodes scanned, by decreasing total time.
+	Nodes []NodeProfile
+}
+
+// PathProfile is the time spent statting a path during a profile, including
+// hashing its contents and listing it.
+type PathProfile struct {
+	Path  string
+	Stats int           // times the path was statted
+	Time  time.Duration // total time
+	Max   time.Duration // longest single stat
+}
+
+// NodeProfile is the time spent on a node during a profile.
+type NodeProfile struct {
+	Node Node
+
+	// Paths is the number of paths the node returned from Paths on the
+	// last scan profiled.
+	Paths int
+
+	// List is the time spent in the node's Paths method, such as expanding
+	// globs.
+	List time.Duration
+
+	// Stat is the time spent statting the node's paths. Paths shared by
+	// several nodes count for each of them.
+	Stat time.Duration
+
+	// Update is the time spent in the node's updates, and Updates their
+	// number.
+	Update  time.Duration
+	Updates int
+}
+
+// Total returns the time spent on the node.
+func (n NodeProfile) Total() time.Duration {
+	return n.List + n.Stat + n.Update
+}
+
+// Write writes the report as text to wr, listing the top slowest paths and
+// nodes, or all of them if top is not positive.
+func (r *ProfileReport) Write(wr io.Writer, top int) error {
+	var b strings.Builder
+	var scan time.Duration
+	if r.Scans > 0 {
+		scan = r.Duration / time.Duration(r.Scans)
+	}
+	fmt.Fprintf(&b, "profile: %d scans in %v (%v per scan)\n", r.Scans, r.Duration, scan)
+	paths, nodes := r.Paths, r.Nodes
+	if top > 0 {
+		paths, nodes = paths[:min(top, len(paths))], nodes[:min(top, len(nodes))]
+	}
+	b.WriteString("\nslowest paths:\n")
+	for _, p := range paths {
+		fmt.Fprintf(&b, "  %12v %6d stats %12v max  %s\n", p.Time, p.Stats, p.Max, p.Path)
+	}
+	b.WriteString("\nslowest nodes:\n")
+	for _, n := range nodes {
+		fmt.Fprintf(&b, "  %12v %6d paths %12v list %12v stat %12v update  %s\n",
+			n.Total(), n.Paths, n.List, n.Stat, n.Update, NodeName(n.Node))
+	}
+	_, err := io.WriteString(wr, b.String())
+	return err
+}
+
+// profiler records a profile.
+type profiler struct {
+	mu    sync.Mutex
+	start time.Time
+	end   time.Time // when profiling was disabled
+	scans int
+	paths map[string]*PathProfile
+	nodes map[Node]*NodeProfile
+}
+
+// node returns the profile of node. p.mu must be held.
+func (p *profiler) node(node Node) *NodeProfile {
+	n := p.nodes[node]
+	if n == nil {
+		if p.nodes == nil {
+			p.nodes = make(map[Node]*NodeProfile)
+		}
+		n = &NodeProfile{Node: node}
+		p.nodes[node] = n
+	}
+	return n
+}
+
+// listed records that node returned n paths from Paths in d.
+func (p *profiler) listed(node Node, n int, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	np := p.node(node)
+	np.Paths = n
+	np.List += d
+}
+
+// statted records the stat times of the entries of s.
+func (p *profiler) statted(s *scan) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paths == nil {
+		p.paths = make(map[string]*PathProfile)
+	}
+	for i := range s.entries {
+		e := &s.entries[i]
+		if e.skip {
+			continue
+		}
+		pp := p.paths[e.path]
+		if pp == nil {
+			pp = &PathProfile{Path: e.path}
+			p.paths[e.path] = pp
+		}
+		pp.Stats++
+		pp.Time += e.took
+		pp.Max = max(pp.Max, e.took)
+		for _, node := range e.nodes {
+			p.node(node).Stat += e.took
+		}
+	}
+}
+
+// updated records that an update of node took d.
+func (p *profiler) updated(node Node, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	np := p.node(node)
+	np.Update += d
+	np.Updates++
+}
+
+// scanned records the end of a scan.
+func (p *profiler) scanned() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scans++
+}
+
+// report returns the report of the profile at now.
+func (p *profiler) report(now time.Time) *ProfileReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.end.IsZero() {
+		now = p.end
+	}
+	r := &ProfileReport{Start: p.start, Duration: now.Sub(p.start), Scans: p.scans}
+	for _, pp := range p.paths {
+		r.Paths = append(r.Paths, *pp)
+	}
+	slices.SortFunc(r.Paths, func(a, b PathProfile) int {
+		return cmp.Or(cmp.Compare(b.Time, a.Time), cmp.Compare(a.Path, b.Path))
+	})
+	for _, np := range p.nodes {
+		r.Nodes = append(r.Nodes, *np)
+	}
+	slices.SortStableFunc(r.Nodes, func(a, b NodeProfile) int {
+		return cmp.Or(cmp.Compare(b.Total(), a.Total()), cmp.Compare(NodeName(a.Node), NodeName(b.Node)))
+	})
+	return r
+}
+
+// listPaths returns the paths of node, recording the time it took in the
+// profile, if profiling.
+func (w *Watcher) listPaths(node Node) []string {
+	p := w.profile.Load()
+	if p == nil {
+		return node.Paths()
+	}
+	start := w.clock().Now()
+	paths := node.Paths()
+	p.listed(node, len(paths), w.clock().Now().Sub(start))
+	return paths
+}
+
+// timeUpdate calls update and records its time in the profile, if profiling.
+func (w *Watcher) timeUpdate(node Node, update func() error) error {
+	p := w.profile.Load()
+	if p == nil {
+		return update()
+	}
+	start := w.clock().Now()
+	err := update()
+	p.updated(node, w.clock().Now().Sub(start))
+	return err
+}
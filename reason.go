@@ -0,0 +1,47 @@
+package watch
+
+import "context"
+
+// Reason is why a node is updated.
+type Reason int
+
+const (
+	// ReasonChange reports that the node's own paths changed, or that it
+	// is a Poller that reported a change, including updates retried under
+	// UpdateRetry.
+	ReasonChange Reason = iota + 1
+
+	// ReasonForced reports a refresh forced by UpdateAll or UpdateNode,
+	// whether or not anything changed.
+	ReasonForced
+
+	// ReasonDependency reports that the node is updated only because one
+	// of its dependencies was.
+	ReasonDependency
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonChange:
+		return "change"
+	case ReasonForced:
+		return "forced"
+	case ReasonDependency:
+		return "dependency"
+	}
+	return "unknown"
+}
+
+// ReasonNode is an optional interface for Nodes that want to know why they
+// are updated, for example to skip work on a forced refresh whose inputs are
+// known to be current. The Watcher calls UpdatedReason instead of Updated or
+// UpdatedContext, with the context passed to ScanContext, or
+// context.Background for Scan and UpdateAll. A BatchNode is still called
+// with UpdatedPaths.
+type ReasonNode interface {
+	Node
+
+	// UpdatedReason is called in place of Updated. It should return
+	// promptly once ctx is done.
+	UpdatedReason(ctx context.Context, reason Reason) error
+}
@@ -0,0 +1,552 @@
+package watch
+
+import (
+	"bufio"
+	"errors"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver discovers the files that a file depends on, such as the files it
+// includes or imports.
+type Resolver interface {
+	// Resolve returns the paths of the files that the file at path depends
+	// on, given a reader for its contents. Returned paths should be usable
+	// with the same file system as path.
+	Resolve(path string, r io.Reader) ([]string, error)
+}
+
+// ResolverFunc adapts an ordinary function to the Resolver interface.
+type ResolverFunc func(path string, r io.Reader) ([]string, error)
+
+// Resolve calls f(path, r).
+func (f ResolverFunc) Resolve(path string, r io.Reader) ([]string, error) {
+	return f(path, r)
+}
+
+// ResolvedNode is a Node that watches a root file together with every file it
+// transitively depends on, as discovered by Resolver. The dependencies are
+// resolved the first time Paths is called and again every time the node is
+// updated, so Paths grows and shrinks as the root file's imports change.
+type ResolvedNode struct {
+	// Root is the path of the root file.
+	Root string
+
+	// Resolver discovers the dependencies of each file.
+	Resolver Resolver
+
+	// FS is the file system used to read files. If nil, the operating
+	// system's file system is used. It should match the FS of the Watcher
+	// the node is registered with.
+	FS fs.FS
+
+	// Node, if not nil, is notified when any resolved file changes. The
+	// paths it returns are watched in addition to the resolved files.
+	Node Node
+
+	resolved bool
+	paths    []string
+	err      error
+}
+
+// Paths returns Root, its transitive dependencies and the paths of Node.
+func (n *ResolvedNode) Paths() []string {
+	if !n.resolved {
+		n.resolve()
+	}
+	if n.Node == nil {
+		return n.paths
+	}
+	return append(n.paths[:len(n.paths):len(n.paths)], n.Node.Paths()...)
+}
+
+// Updated re-resolves the dependencies of Root and then calls Updated on Node.
+// Errors encountered while resolving are returned along with the error from
+// Node.
+func (n *ResolvedNode) Updated() error {
+	n.resolve()
+	var err error
+	if n.Node != nil {
+		err = n.Node.Updated()
+	}
+	return errors.Join(n.err, err)
+}
+
+// resolve walks the dependency graph of Root breadth first.
+func (n *ResolvedNode) resolve() {
+	n.resolved = true
+	n.paths = nil
+	var errs []error
+	seen := map[string]struct{}{n.Root: {}}
+	queue := []string{n.Root}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		n.paths = append(n.paths, p)
+		deps, err := n.resolveFile(p)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, dep := range deps {
+			if _, ok := seen[dep]; !ok {
+				seen[dep] = struct{}{}
+				queue = append(queue, dep)
+			}
+		}
+	}
+	n.err = errors.Join(errs...)
+}
+
+func (n *ResolvedNode) resolveFile(p string) ([]string, error) {
+	f, err := openFile(n.FS, p)
+	if errors.Is(err, fs.ErrNotExist) {
+		// missing files are still watched so that their creation is noticed
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return nil, nil
+	}
+	return n.Resolver.Resolve(p, f)
+}
+
+// IncludeResolver resolves C preprocessor style include directives, as used
+// by C, C++ and GLSL:
+//
+//	#include "local.h"
+//	#include <system.h>
+//
+// Quoted includes are looked up relative to the including file first and
+// then in Dirs. Angle-bracket includes are only looked up in Dirs. Includes
+// that cannot be found in Dirs are ignored, except for quoted includes, which
+// resolve to the path relative to the including file so that the file is
+// picked up once it is created.
+type IncludeResolver struct {
+	// Dirs lists the include search directories.
+	Dirs []string
+
+	// FS is the file system used to look up includes. If nil, the operating
+	// system's file system is used.
+	FS fs.FS
+}
+
+var includeDirective = regexp.MustCompile(`^\s*#\s*include\s*([<"])([^>"]+)[>"]`)
+
+// Resolve implements Resolver.
+func (ir *IncludeResolver) Resolve(p string, r io.Reader) ([]string, error) {
+	var deps []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name, quoted, ok := parseInclude(scanner.Text())
+		if !ok {
+			continue
+		}
+		candidates := ir.candidates(p, name, quoted)
+		if dep, ok := ir.find(candidates); ok {
+			deps = append(deps, dep)
+		} else if quoted {
+			deps = append(deps, candidates[0])
+		}
+	}
+	return deps, scanner.Err()
+}
+
+// Include parses line, of the file at p, as an include directive. If it is
+// one, Include returns the included name and the file it refers to, looked
+// up as by Resolve, or an empty dep if no such file exists. It is for tools
+// that process includes themselves, such as to inline them.
+func (ir *IncludeResolver) Include(p, line string) (name, dep string, ok bool) {
+	name, quoted, ok := parseInclude(line)
+	if !ok {
+		return "", "", false
+	}
+	dep, _ = ir.find(ir.candidates(p, name, quoted))
+	return name, dep, true
+}
+
+// parseInclude returns the name included by line, and whether it is quoted
+// rather than in angle brackets, if line is an include directive.
+func parseInclude(line string) (name string, quoted, ok bool) {
+	m := includeDirective.FindStringSubmatch(line)
+	if m == nil {
+		return "", false, false
+	}
+	return m[2], m[1] == `"`, true
+}
+
+// candidates returns the paths an include of name from the file at p is
+// looked up at, in order.
+func (ir *IncludeResolver) candidates(p, name string, quoted bool) []string {
+	var candidates []string
+	if quoted {
+		candidates = append(candidates, joinPath(ir.FS, dirPath(ir.FS, p), name))
+	}
+	for _, dir := range ir.Dirs {
+		candidates = append(candidates, joinPath(ir.FS, dir, name))
+	}
+	return candidates
+}
+
+// find returns the first of candidates that exists.
+func (ir *IncludeResolver) find(candidates []string) (string, bool) {
+	for _, c := range candidates {
+		if _, err := statFile(ir.FS, c); err == nil {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// CSSResolver resolves CSS @import rules relative to the importing file.
+// Imports of absolute URLs are ignored.
+type CSSResolver struct {
+	// FS is the file system the resolved paths refer to. If nil, the
+	// operating system's file system is used.
+	FS fs.FS
+}
+
+var cssImport = regexp.MustCompile(`@import\s+(?:url\(\s*)?["']?([^"')\s;]+)`)
+
+// Resolve implements Resolver.
+func (cr *CSSResolver) Resolve(p string, r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var deps []string
+	for _, m := range cssImport.FindAllStringSubmatch(string(data), -1) {
+		name := m[1]
+		if strings.Contains(name, "://") || strings.HasPrefix(name, "//") {
+			continue
+		}
+		deps = append(deps, joinPath(cr.FS, dirPath(cr.FS, p), name))
+	}
+	return deps, nil
+}
+
+// TemplateResolver resolves the {{template "name"}} and {{block "name"}}
+// actions of text/template and html/template files. Following the convention
+// of template.ParseFiles, a template is named after the base name of the file
+// defining it, so each name is looked up as a file name relative to the
+// including file and then in Dirs. Names that cannot be found are ignored,
+// since they usually refer to templates defined with {{define}}.
+type TemplateResolver struct {
+	// Dirs lists additional directories to look up templates in.
+	Dirs []string
+
+	// FS is the file system used to look up templates. If nil, the
+	// operating system's file system is used.
+	FS fs.FS
+}
+
+var templateAction = regexp.MustCompile(`\{\{-?\s*(?:template|block)\s+"([^"]+)"`)
+
+// Resolve implements Resolver.
+func (tr *TemplateResolver) Resolve(p string, r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	dirs := append([]string{dirPath(tr.FS, p)}, tr.Dirs...)
+	var deps []string
+	for _, m := range templateAction.FindAllStringSubmatch(string(data), -1) {
+		for _, dir := range dirs {
+			c := joinPath(tr.FS, dir, m[1])
+			if c == p {
+				break
+			}
+			if _, err := statFile(tr.FS, c); err == nil {
+				deps = append(deps, c)
+				break
+			}
+		}
+	}
+	return deps, nil
+}
+
+// GoResolver resolves the imports of a Go source file to the non-test Go
+// files of the imported packages that belong to the same module.
+type GoResolver struct {
+	// Dir is the root directory of the module.
+	Dir string
+
+	// Module is the module path. If empty, it is read from the go.mod file
+	// in Dir, which is read again only when its modification time changes.
+	Module string
+
+	// FS is the file system Dir refers to. If nil, the operating system's
+	// file system is used.
+	FS fs.FS
+
+	mu      sync.Mutex
+	module  string    // read from go.mod
+	modTime time.Time // of go.mod when module was read
+}
+
+// Resolve implements Resolver.
+func (gr *GoResolver) Resolve(p string, r io.Reader) ([]string, error) {
+	module, err := gr.modulePath()
+	if err != nil {
+		return nil, err
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), p, r, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	var deps []string
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		rel, ok := localPackage(module, importPath)
+		if !ok {
+			continue
+		}
+		files, err := goFiles(gr.FS, joinPath(gr.FS, gr.Dir, rel), false)
+		if err != nil {
+			return deps, err
+		}
+		deps = append(deps, files...)
+	}
+	return deps, nil
+}
+
+// modulePath returns Module, or the module path declared in the go.mod file
+// in Dir.
+func (gr *GoResolver) modulePath() (string, error) {
+	if gr.Module != "" {
+		return gr.Module, nil
+	}
+	p := joinPath(gr.FS, gr.Dir, "go.mod")
+	info, err := statFile(gr.FS, p)
+	if err != nil {
+		return "", err
+	}
+	gr.mu.Lock()
+	defer gr.mu.Unlock()
+	if gr.module != "" && info.ModTime().Equal(gr.modTime) {
+		return gr.module, nil
+	}
+	module, err := readModulePath(gr.FS, p)
+	if err != nil {
+		return "", err
+	}
+	gr.module, gr.modTime = module, info.ModTime()
+	return module, nil
+}
+
+// localPackage returns the directory, relative to the module root, of the
+// package importPath if it belongs to module, that is if it is module or
+// continues it with a path element.
+func localPackage(module, importPath string) (string, bool) {
+	if importPath == module {
+		return ".", true
+	}
+	return strings.CutPrefix(importPath, module+"/")
+}
+
+// GoPackageNode is a Node that watches a Go package together with every
+// package of the same module that it transitively imports. The directories
+// and .go files of the packages are watched, along with the module's go.mod,
+// so adding a file or an import is noticed. Build constraints are not
+// evaluated: every .go file of a package is parsed for imports. The import
+// graph is walked the first time Paths is called and again every time the
+// node is updated.
+type GoPackageNode struct {
+	// Dir is the directory of the package.
+	Dir string
+
+	// ModuleDir is the root directory of the module. If empty, it is the
+	// closest directory containing a go.mod file, searching Dir and its
+	// parents as far as Dir's path goes.
+	ModuleDir string
+
+	// Tests also watches the _test.go files of Dir and the packages they
+	// import. The test files of imported packages are never watched.
+	Tests bool
+
+	// FS is the file system used to read files. If nil, the operating
+	// system's file system is used. It should match the FS of the Watcher
+	// the node is registered with.
+	FS fs.FS
+
+	// Node, if not nil, is notified when anything in the import graph
+	// changes. The paths it returns are watched in addition to the package
+	// files.
+	Node Node
+
+	resolved bool
+	packages []string
+	paths    []string
+	err      error
+}
+
+// Paths returns the directories and Go files of Dir and the local packages
+// it imports, the module's go.mod and the paths of Node.
+func (n *GoPackageNode) Paths() []string {
+	if !n.resolved {
+		n.resolve()
+	}
+	if n.Node == nil {
+		return n.paths
+	}
+	return append(n.paths[:len(n.paths):len(n.paths)], n.Node.Paths()...)
+}
+
+// Packages returns the directories of Dir and the local packages it
+// transitively imports, Dir first, as of the last walk.
+func (n *GoPackageNode) Packages() []string {
+	if !n.resolved {
+		n.resolve()
+	}
+	return n.packages
+}
+
+// Updated walks the import graph again and then calls Updated on Node.
+// Errors encountered while walking are returned along with the error from
+// Node.
+func (n *GoPackageNode) Updated() error {
+	n.resolve()
+	var err error
+	if n.Node != nil {
+		err = n.Node.Updated()
+	}
+	return errors.Join(n.err, err)
+}
+
+// resolve walks the local import graph of Dir breadth first.
+func (n *GoPackageNode) resolve() {
+	n.resolved = true
+	n.packages, n.paths = nil, nil
+	moduleDir, module, err := n.module()
+	if err != nil {
+		n.err = err
+		n.paths = []string{n.Dir}
+		return
+	}
+	n.paths = append(n.paths, joinPath(n.FS, moduleDir, "go.mod"))
+	var errs []error
+	seen := map[string]struct{}{n.Dir: {}}
+	queue := []string{n.Dir}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		n.packages = append(n.packages, dir)
+		n.paths = append(n.paths, dir)
+		files, err := goFiles(n.FS, dir, n.Tests && dir == n.Dir)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, p := range files {
+			n.paths = append(n.paths, p)
+			imports, err := goImports(n.FS, p)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			for _, importPath := range imports {
+				rel, ok := localPackage(module, importPath)
+				if !ok {
+					continue
+				}
+				dep := joinPath(n.FS, moduleDir, rel)
+				if _, ok := seen[dep]; !ok {
+					seen[dep] = struct{}{}
+					queue = append(queue, dep)
+				}
+			}
+		}
+	}
+	n.err = errors.Join(errs...)
+}
+
+// module returns the root directory and path of the module containing Dir.
+func (n *GoPackageNode) module() (dir, module string, err error) {
+	dir = n.ModuleDir
+	if dir == "" {
+		for d := n.Dir; ; {
+			if _, err := statFile(n.FS, joinPath(n.FS, d, "go.mod")); err == nil {
+				dir = d
+				break
+			}
+			parent := dirPath(n.FS, d)
+			if parent == d {
+				return "", "", errors.New("watch: no go.mod found for " + n.Dir)
+			}
+			d = parent
+		}
+	}
+	module, err = readModulePath(n.FS, joinPath(n.FS, dir, "go.mod"))
+	return dir, module, err
+}
+
+// goImports returns the import paths of the Go file at p.
+func goImports(fsys fs.FS, p string) ([]string, error) {
+	f, err := openFile(fsys, p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file, err := parser.ParseFile(token.NewFileSet(), p, f, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	var imports []string
+	for _, spec := range file.Imports {
+		if importPath, err := strconv.Unquote(spec.Path.Value); err == nil {
+			imports = append(imports, importPath)
+		}
+	}
+	return imports, nil
+}
+
+// goFiles returns the Go files in dir, leaving out test files unless tests is
+// set.
+func goFiles(fsys fs.FS, dir string, tests bool) ([]string, error) {
+	entries, err := readDir(fsys, dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || (!tests && strings.HasSuffix(name, "_test.go")) {
+			continue
+		}
+		files = append(files, joinPath(fsys, dir, name))
+	}
+	return files, nil
+}
+
+// readModulePath returns the module path declared in the go.mod file at p.
+func readModulePath(fsys fs.FS, p string) (string, error) {
+	f, err := openFile(fsys, p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "//")
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("watch: no module directive in " + p)
+}
@@ -0,0 +1,189 @@
+package watch_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+func TestIncludeResolver(t *testing.T) {
+	fsys := fstest.MapFS{
+		"src/main.c": {Data: []byte("#include \"util.h\"\n#include <stdio.h>\nint main() {}\n")},
+		"src/util.h": {Data: []byte("#include <lib.h>\n#include \"missing.h\"\n")},
+		"inc/lib.h":  {Data: []byte("// nothing\n")},
+	}
+	n := &watch.ResolvedNode{
+		Root:     "src/main.c",
+		Resolver: &watch.IncludeResolver{Dirs: []string{"inc"}, FS: fsys},
+		FS:       fsys,
+	}
+	got := n.Paths()
+	want := []string{"src/main.c", "src/util.h", "inc/lib.h", "src/missing.h"}
+	if !slices.Equal(got, want) {
+		t.Errorf("paths should be %v, got %v", want, got)
+	}
+
+	ir := n.Resolver.(*watch.IncludeResolver)
+	for _, tc := range []struct {
+		line, name, dep string
+		ok              bool
+	}{
+		{`#include <lib.h>`, "lib.h", "inc/lib.h", true},
+		{` # include "util.h"`, "util.h", "src/util.h", true},
+		{`#include "missing.h"`, "missing.h", "", true},
+		{`int main() {}`, "", "", false},
+	} {
+		if name, dep, ok := ir.Include("src/main.c", tc.line); name != tc.name || dep != tc.dep || ok != tc.ok {
+			t.Errorf("Include(%q) = %q, %q, %v, want %q, %q, %v", tc.line, name, dep, ok, tc.name, tc.dep, tc.ok)
+		}
+	}
+}
+
+func TestCSSResolver(t *testing.T) {
+	r := &watch.CSSResolver{FS: fstest.MapFS{}}
+	src := `@import "base.css"; @import url('theme/dark.css'); @import url(https://example.com/x.css);`
+	got, err := r.Resolve("styles/site.css", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"styles/base.css", "styles/theme/dark.css"}
+	if !slices.Equal(got, want) {
+		t.Errorf("paths should be %v, got %v", want, got)
+	}
+}
+
+func TestGoResolver(t *testing.T) {
+	fsys := fstest.MapFS{
+		"mod/go.mod":         {Data: []byte("module example.com/mod // comment\n")},
+		"mod/main.go":        {Data: []byte("package main\nimport (\n\t\"fmt\"\n\t\"example.com/mod/util\"\n\t\"example.com/modx\"\n)\n")},
+		"mod/util/util.go":   {Data: []byte("package util\n")},
+		"mod/x/x.go":         {Data: []byte("package x\n")},
+		"mod/util/x_test.go": {Data: []byte("package util\n")},
+		"mod/util/README.md": {Data: []byte("")},
+		"mod/other/other.go": {Data: []byte("package other\n")},
+	}
+	n := &watch.ResolvedNode{
+		Root:     "mod/main.go",
+		Resolver: &watch.GoResolver{Dir: "mod", FS: fsys},
+		FS:       fsys,
+	}
+	got := n.Paths()
+	want := []string{"mod/main.go", "mod/util/util.go"}
+	if !slices.Equal(got, want) {
+		t.Errorf("paths should be %v, got %v", want, got)
+	}
+
+	// go.mod is only read again once its modification time changes
+	fsys["mod/go.mod"] = &fstest.MapFile{Data: []byte("module example.com/other\n")}
+	n.Updated()
+	if got := n.Paths(); !slices.Equal(got, want) {
+		t.Errorf("cached module path should be used, got %v", got)
+	}
+	fsys["mod/go.mod"].ModTime = time.Unix(1, 0)
+	n.Updated()
+	if got, want := n.Paths(), []string{"mod/main.go"}; !slices.Equal(got, want) {
+		t.Errorf("paths should be %v after go.mod changed, got %v", want, got)
+	}
+}
+
+func TestResolvedNode(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{
+		"a.glsl": {Data: []byte("#include \"b.glsl\"\n"), ModTime: t0},
+		"b.glsl": {Data: []byte(""), ModTime: t0},
+	}
+	inner := &testNode{}
+	n := &watch.ResolvedNode{
+		Root:     "a.glsl",
+		Resolver: &watch.IncludeResolver{FS: fsys},
+		FS:       fsys,
+		Node:     watch.Node(innerNode{inner}),
+	}
+	w := &watch.Watcher{FS: fsys}
+	w.Register(n)
+	w.Scan()
+
+	fsys["c.glsl"] = &fstest.MapFile{ModTime: t0}
+	fsys["b.glsl"] = &fstest.MapFile{Data: []byte("#include \"c.glsl\"\n"), ModTime: t0.Add(time.Second)}
+	w.Scan()
+	if inner.updated != 1 {
+		t.Errorf("updated should be 1")
+	}
+	if !slices.Contains(n.Paths(), "c.glsl") {
+		t.Errorf("paths should contain newly included c.glsl")
+	}
+}
+
+// innerNode watches no paths of its own.
+type innerNode struct{ *testNode }
+
+func (innerNode) Paths() []string { return nil }
+
+func TestTemplateResolver(t *testing.T) {
+	fsys := fstest.MapFS{
+		"pages/index.html":   {Data: []byte(`{{template "layout.html" .}}{{define "body"}}{{template "body"}}{{end}}`)},
+		"pages/layout.html":  {Data: []byte(`<html>{{- block "nav.html" .}}{{end}}{{template "body" .}}</html>`)},
+		"partials/nav.html":  {Data: []byte(`<nav></nav>`)},
+		"partials/unused.tt": {Data: []byte(``)},
+	}
+	n := &watch.ResolvedNode{
+		Root:     "pages/index.html",
+		Resolver: &watch.TemplateResolver{Dirs: []string{"partials"}, FS: fsys},
+		FS:       fsys,
+	}
+	got := n.Paths()
+	want := []string{"pages/index.html", "pages/layout.html", "partials/nav.html"}
+	if !slices.Equal(got, want) {
+		t.Errorf("paths should be %v, got %v", want, got)
+	}
+}
+
+func TestGoPackageNode(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("go.mod", []byte("module example.com/mod\n"))
+	fsys.WriteFile("cmd/app/main.go", []byte("package main\nimport (\n\t\"fmt\"\n\t\"example.com/mod/util\"\n)\n"))
+	fsys.WriteFile("cmd/app/main_test.go", []byte("package main\nimport \"example.com/mod/testutil\"\n"))
+	fsys.WriteFile("util/util.go", []byte("package util\nimport \"example.com/mod/internal/strs\"\n"))
+	fsys.WriteFile("util/util_test.go", []byte("package util\nimport \"example.com/mod/other\"\n"))
+	fsys.WriteFile("internal/strs/strs.go", []byte("package strs\n"))
+	fsys.WriteFile("testutil/testutil.go", []byte("package testutil\n"))
+	fsys.WriteFile("other/other.go", []byte("package other\n"))
+	var updates int
+	n := &watch.GoPackageNode{Dir: "cmd/app", FS: fsys, Node: watch.Files(nil, func() error {
+		updates++
+		return nil
+	})}
+	if got, want := n.Packages(), []string{"cmd/app", "util", "internal/strs"}; !slices.Equal(got, want) {
+		t.Errorf("packages should be %v, got %v", want, got)
+	}
+	got := n.Paths()
+	want := []string{"go.mod", "cmd/app", "cmd/app/main.go", "util", "util/util.go", "internal/strs", "internal/strs/strs.go"}
+	if !slices.Equal(got, want) {
+		t.Errorf("paths should be %v, got %v", want, got)
+	}
+
+	w := &watch.Watcher{FS: fsys}
+	w.Register(n)
+	w.Scan()
+	fsys.Advance(time.Second)
+	fsys.WriteFile("internal/strs/strs.go", []byte("package strs\nimport \"example.com/mod/other\"\n"))
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if updates != 1 {
+		t.Errorf("a transitive import change should update the node, got %d updates", updates)
+	}
+	if !slices.Contains(n.Packages(), "other") {
+		t.Errorf("a new import should be walked, got %v", n.Packages())
+	}
+
+	tests := &watch.GoPackageNode{Dir: "cmd/app", FS: fsys, Tests: true}
+	if got, want := tests.Packages(), []string{"cmd/app", "util", "testutil", "internal/strs", "other"}; !slices.Equal(got, want) {
+		t.Errorf("packages with tests should be %v, got %v", want, got)
+	}
+}
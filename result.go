@@ -0,0 +1,99 @@
+package watch
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// ScanResult summarizes a call to ScanResult, so that callers can log and act
+// on a scan without deriving the information from their nodes.
+type ScanResult struct {
+	// Updated reports whether any node was due to be notified, as returned
+	// by Scan.
+	Updated bool
+
+	// Changed lists the sorted paths whose changes were detected, including
+	// removed paths.
+	Changed []string
+
+	// Deleted lists the sorted paths that were removed or renamed.
+	Deleted []string
+
+	// Notified lists the nodes that were notified, in notification order.
+	Notified []Node
+
+	// Unreached lists the paths that were not checked before the context
+	// was done, as returned by ScanContext.
+	Unreached []string
+
+	// Errors holds the errors returned by Scan.
+	Errors ScanErrors
+
+	// StatErrors is the number of *StatError values in Errors.
+	StatErrors int
+
+	// Duration is the time the scan took, measured with Clock.
+	Duration time.Duration
+}
+
+// ScanResult is like ScanContext, but returns a summary of the scan. Scan and
+// ScanContext return a subset of the summary.
+func (w *Watcher) ScanResult(ctx context.Context) ScanResult {
+	return w.scanCycle(ctx, nil)
+}
+
+// scanCycle scans w and then its scopes, which share the StatCache of the
+// cycle. shared is the StatCache of the Group scan or the scan of the parent
+// that scans w, if any.
+func (w *Watcher) scanCycle(ctx context.Context, shared *StatCache) ScanResult {
+	r, c := w.scanResult(ctx, shared)
+	for _, s := range w.Scopes() {
+		r.merge(s.scanCycle(ctx, c))
+	}
+	return r
+}
+
+// scanResult is ScanResult without the scopes of w. It returns the StatCache
+// of the cycle, as chosen by newCycle.
+func (w *Watcher) scanResult(ctx context.Context, shared *StatCache) (ScanResult, *StatCache) {
+	if err := w.begin(); err != nil {
+		return ScanResult{Errors: []error{err}}, nil
+	}
+	defer w.busy.Unlock()
+	c := w.newCycle(shared)
+	w.cycle.Store(c)
+	defer w.cycle.Store(nil)
+	start := w.clock().Now()
+	if err := w.checkFS(); err != nil {
+		return ScanResult{Errors: []error{err}}, c
+	}
+	w.Hooks.beforeScan()
+	return w.process(ctx, w.detect(ctx, true, start), start), c
+}
+
+// result returns the summary of s.
+func (s *scan) result(updated bool, notified []Node, errs []error, stats ScanStats) ScanResult {
+	r := ScanResult{
+		Updated:    updated,
+		Notified:   notified,
+		Unreached:  s.unreached,
+		Errors:     errs,
+		StatErrors: len(s.errors),
+		Duration:   stats.Duration,
+	}
+	for _, e := range s.entries {
+		if !e.updated {
+			continue
+		}
+		r.Changed = append(r.Changed, e.path)
+		if e.info == nil {
+			r.Deleted = append(r.Deleted, e.path)
+		}
+	}
+	slices.Sort(r.Changed)
+	slices.Sort(r.Deleted)
+	r.Changed = slices.Compact(r.Changed)
+	r.Deleted = slices.Compact(r.Deleted)
+	return r
+}
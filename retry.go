@@ -0,0 +1,124 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// RetryPolicy configures how a Watcher handles failing stats, such as the
+// intermittent errors of network file systems. It applies to every error
+// but a missing file, which is reported as a removal. The zero value reports
+// errors on the first failure.
+type RetryPolicy struct {
+	// Attempts is the number of times a failing stat is retried during a
+	// single scan.
+	Attempts int
+
+	// Backoff is the delay before the first retry. It doubles for every
+	// subsequent retry, up to MaxBackoff if it is not zero. Delays are
+	// measured with the Watcher's Clock and cut short when the context
+	// passed to ScanContext is done.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Failures is the number of consecutive scans a path must fail before
+	// its error is reported. Until then the path is treated as unchanged.
+	Failures int
+}
+
+// retry reports whether err should be retried after attempt retries, after
+// waiting for the backoff delay.
+func (r *RetryPolicy) retry(ctx context.Context, clock Clock, err error, attempt int) bool {
+	if err == nil || errors.Is(err, fs.ErrNotExist) || attempt >= r.Attempts {
+		return false
+	}
+	d := backoff(r.Backoff, r.MaxBackoff, attempt)
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := clock.NewTicker(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// backoff returns the delay before retry number attempt, counting from zero,
+// which starts at d and doubles for every attempt, up to max if it is not
+// zero.
+func backoff(d, max time.Duration, attempt int) time.Duration {
+	for i := 0; i < attempt && d > 0 && (max == 0 || d < max); i++ {
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+// tolerate reports whether a stat failing with err for the given number of
+// consecutive scans should not be reported yet.
+func (r *RetryPolicy) tolerate(err error, failures int) bool {
+	return err != nil && !errors.Is(err, fs.ErrNotExist) && failures < r.Failures
+}
+
+// UpdateRetryPolicy configures how a Watcher retries nodes whose update
+// failed, such as a template that did not parse because it was read while
+// half saved, so that they recover once the files are valid even if they do
+// not change again. The zero value does not retry: a failed node is only
+// notified again when its paths change.
+type UpdateRetryPolicy struct {
+	// Attempts is the number of times a node whose update failed is
+	// notified again by later scans, although none of its paths changed,
+	// until an update succeeds. If negative, the node is retried until it
+	// succeeds.
+	Attempts int
+
+	// Backoff is the minimum time between a failed update and its retry.
+	// It doubles for every consecutive failure, up to MaxBackoff if it is
+	// not zero. If zero, the node is retried by the next Scan.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// updateFailure records the consecutive failed updates of a node that is to
+// be retried.
+type updateFailure struct {
+	attempts int       // retries so far
+	next     time.Time // earliest time of the next retry
+}
+
+// retryFailed records which of the notified nodes failed, according to the
+// NodeErrors in errs, and schedules their retries. The changes that caused
+// the notification of a node to be retried are kept, so that ChangedPaths
+// and Events report them again on the retry.
+func (w *Watcher) retryFailed(notified []Node, errs []error, now time.Time) {
+	failed := make(map[Node]bool)
+	for _, err := range errs {
+		var ne *NodeError
+		if errors.As(err, &ne) {
+			failed[ne.Node] = true
+		}
+	}
+	for _, node := range notified {
+		policy := w.updateRetry(node)
+		f := w.failed[node]
+		if !failed[node] || policy.Attempts >= 0 && f.attempts >= policy.Attempts {
+			delete(w.failed, node)
+			continue
+		}
+		w.failed[node] = updateFailure{
+			attempts: f.attempts + 1,
+			next:     now.Add(backoff(policy.Backoff, policy.MaxBackoff, f.attempts)),
+		}
+		for _, path := range w.notified[node] {
+			w.addChange(node, path)
+		}
+		w.queued[node] = append(w.queued[node], w.events[node]...)
+	}
+}
@@ -0,0 +1,64 @@
+package watch
+
+import (
+	"io/fs"
+	"reflect"
+)
+
+// RegisterFS registers node like Register, but the node's paths, and its
+// outputs if it is a Producer, refer to fsys instead of FS, so that a single
+// Watcher can track nodes on several file systems, such as an embed.FS
+// overlay, a temporary directory and the operating system's file system. If
+// fsys is nil, the operating system's file system is used. The same path in
+// different file systems is tracked separately. If node is already
+// registered, its file system is replaced.
+func (w *Watcher) RegisterFS(fsys fs.FS, node Node) error {
+	if err := w.Register(node); err != nil {
+		return err
+	}
+	w.nodeRoot[node] = w.addRoot(fsys)
+	return nil
+}
+
+// addRoot returns the root of fsys, adding it to the roots if it is not one
+// of them.
+func (w *Watcher) addRoot(fsys fs.FS) int {
+	for i, r := range w.roots {
+		if sameFS(r, fsys) {
+			return i + 1
+		}
+	}
+	w.roots = append(w.roots, fsys)
+	return len(w.roots)
+}
+
+// rootFS returns the file system of root, which is FS for root zero, as
+// returned by fsys, and a file system passed to RegisterFS otherwise.
+func (w *Watcher) rootFS(root int) fs.FS {
+	if root == 0 {
+		return w.fsys()
+	}
+	return w.roots[root-1]
+}
+
+// sameFS reports whether a and b are the same file system. File systems of
+// types that cannot be compared, such as fstest.MapFS, are the same if they
+// refer to the same map.
+func sameFS(a, b fs.FS) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) {
+		return false
+	}
+	if ta.Comparable() {
+		return a == b
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch va.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Func:
+		return va.UnsafePointer() == vb.UnsafePointer()
+	}
+	return false
+}
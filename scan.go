@@ -0,0 +1,464 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scan holds the changes detected in the paths of the registered nodes. It is
+// computed by detect without modifying the Watcher, and applied by commit.
+type scan struct {
+	entries []scanEntry
+	index   map[pathKey]int
+	polled  []Node
+	errors  []error
+	partial bool       // only some of the watched paths were visited
+	now     time.Time  // start of the scan
+	due     []Node     // nodes with an Interval whose paths were checked
+	limit   *pathLimit // set if MaxPaths is
+
+	unreached []string // paths not checked before the context was done
+	ctxErr    error    // the context's error, if it cut the scan short
+}
+
+// scanEntry is a distinct path visited during a scan.
+type scanEntry struct {
+	path     string
+	root     int       // the file system of the path, see Watcher.rootFS
+	fsys     fs.FS     // the file system of the path, or nil for the OS
+	prev     *pathStat // nil if the path was not seen by the previous Scan
+	nodes    []Node
+	shared   bool // nodes is a prefix of prev.nodes, which must not be modified
+	detect   Detection
+	mixed    bool   // only some of the nodes use DetectHash
+	touched  bool   // modified, but the digest shows the same contents
+	skip     bool   // no node referencing the path is due to be checked
+	op       Op     // the kind of change, if updated
+	other    string // the other path of a rename
+	info     fs.FileInfo
+	sum      []byte
+	entries  []string
+	failures int
+	events   []Event // the entries added to or removed from a directory
+	link     string
+	target   fs.FileInfo
+	batched  bool      // info and errs[0] hold the result of StatMany
+	missing  time.Time // when the path went missing, if its removal is held
+	updated  bool
+	errs     [2]error
+	took     time.Duration // time spent checking the path, if profiling
+}
+
+// detect collects the distinct paths of all registered nodes, stats them and
+// determines which have changed since the last commit. Paths that are only
+// referenced by nodes whose Interval has not elapsed at now are not statted,
+// unless they have never been seen. If poll is set, registered Pollers are
+// polled too. If ctx is done before all paths are checked, the remaining
+// paths are recorded as unreached.
+func (w *Watcher) detect(ctx context.Context, poll bool, now time.Time) *scan {
+	s := w.newScan()
+	s.now = now
+	for _, node := range w.sorted() {
+		due := w.due(node, now)
+		if due && w.options[node].Interval > 0 {
+			s.due = append(s.due, node)
+		}
+		root := w.nodeRoot[node]
+		paths := w.listPaths(node)
+		w.count(s, node, len(paths))
+		for _, path := range paths {
+			path = w.normalize(path)
+			if w.ignored(path, false) {
+				continue
+			}
+			key := w.key(root, path)
+			if i, ok := s.index[key]; ok {
+				e := &s.entries[i]
+				if e.nodes[len(e.nodes)-1] != node {
+					e.addNode(node)
+				}
+				e.skip = e.skip && !due
+				continue
+			}
+			if !w.admit(s, key) {
+				continue
+			}
+			prev := w.paths[key]
+			s.index[key] = len(s.entries)
+			s.entries = append(s.entries, scanEntry{
+				path:   path,
+				root:   root,
+				fsys:   w.rootFS(root),
+				prev:   prev,
+				shared: prev != nil,
+				skip:   !due && prev != nil,
+			})
+			s.entries[len(s.entries)-1].addNode(node)
+		}
+	}
+	w.checkEntries(ctx, s)
+	w.renames(s)
+	if poll && s.ctxErr == nil {
+		w.poll(ctx, s)
+	}
+	return s
+}
+
+// newScan returns an empty scan, reusing the entries and index of the last
+// released one so that scanning an unchanged tree does not allocate them
+// again.
+func (w *Watcher) newScan() *scan {
+	s := w.spare
+	w.spare = nil
+	if s == nil {
+		return &scan{index: make(map[pathKey]int)}
+	}
+	return s
+}
+
+// release makes the buffers of s available to the next scan. s must not be
+// used afterwards. The slices handed out to callers, such as the errors and
+// unreached paths, are not reused.
+func (w *Watcher) release(s *scan) {
+	clear(s.entries)
+	clear(s.index)
+	*s = scan{entries: s.entries[:0], index: s.index}
+	w.spare = s
+}
+
+// addNode appends node to the nodes referencing the entry. Since the nodes
+// usually reference the same paths in the same order on every Scan, the
+// previous Scan's slice is reused for as long as it matches.
+func (e *scanEntry) addNode(node Node) {
+	if !e.shared {
+		e.nodes = append(e.nodes, node)
+		return
+	}
+	if n := len(e.nodes); n < len(e.prev.nodes) && e.prev.nodes[n] == node {
+		e.nodes = e.prev.nodes[:n+1]
+		return
+	}
+	e.shared = false
+	e.nodes = append(slices.Clip(e.nodes), node)
+}
+
+// detectPaths is like detect, but only visits the given paths, attributing
+// them to the nodes that referenced them during the last Scan and to node,
+// if it is not nil. If node is nil, the paths are looked up in every file
+// system the Watcher tracks. Paths that are not watched are skipped.
+func (w *Watcher) detectPaths(ctx context.Context, paths []string, node Node) *scan {
+	s := w.newScan()
+	s.partial = true
+	roots := make([]int, 0, len(w.roots)+1)
+	if node != nil {
+		roots = append(roots, w.nodeRoot[node])
+	} else {
+		for root := range len(w.roots) + 1 {
+			roots = append(roots, root)
+		}
+	}
+	for _, path := range paths {
+		path = w.normalize(path)
+		if w.ignored(path, false) {
+			continue
+		}
+		for _, root := range roots {
+			key := w.key(root, path)
+			if _, ok := s.index[key]; ok {
+				continue
+			}
+			prev := w.paths[key]
+			var nodes []Node
+			if prev != nil {
+				// skip nodes unregistered since the last Scan
+				nodes = slices.DeleteFunc(slices.Clone(prev.nodes), func(n Node) bool {
+					_, ok := w.nodes[n]
+					return !ok
+				})
+			}
+			if node != nil && !slices.Contains(nodes, node) {
+				nodes = append(nodes, node)
+			}
+			if len(nodes) == 0 || prev == nil && w.MaxPaths > 0 && len(w.paths)+len(s.entries) >= w.MaxPaths {
+				continue
+			}
+			s.index[key] = len(s.entries)
+			s.entries = append(s.entries, scanEntry{path: path, root: root, fsys: w.rootFS(root), prev: prev, nodes: nodes})
+		}
+	}
+	w.checkEntries(ctx, s)
+	w.renames(s)
+	return s
+}
+
+// checkEntries checks every entry of s that is not skipped and collects
+// their errors.
+func (w *Watcher) checkEntries(ctx context.Context, s *scan) {
+	for i := range s.entries {
+		e := &s.entries[i]
+		e.detect, e.mixed = w.detection(e.nodes)
+	}
+	if ctx.Done() == nil {
+		w.statMany(s.entries)
+		forEach(len(s.entries), w.StatConcurrency, func(i int) {
+			if !s.entries[i].skip {
+				s.entries[i].check(ctx, w)
+			}
+		})
+	} else {
+		w.checkContext(ctx, s)
+	}
+	if p := w.profile.Load(); p != nil {
+		p.statted(s)
+	}
+	for _, e := range s.entries {
+		for _, err := range e.errs {
+			if err := statError(e.path, err); err != nil {
+				s.errors = append(s.errors, err)
+			}
+		}
+	}
+}
+
+// checkContext checks the entries of s like checkEntries, but stops waiting
+// for the checks once ctx is done, since a stat on an unresponsive network
+// file system may never return. Checks still in progress are abandoned and
+// their results discarded. Entries that were not checked are skipped and
+// recorded as unreached.
+func (w *Watcher) checkContext(ctx context.Context, s *scan) {
+	var (
+		mu        sync.Mutex
+		abandoned bool
+		reached   = make([]bool, len(s.entries))
+		work      = slices.Clone(s.entries)
+		finished  = make(chan struct{})
+	)
+	for i := range work {
+		// abandoned checks must not read state that commit modifies
+		if prev := work[i].prev; prev != nil {
+			work[i].prev = &pathStat{info: prev.info, more: prev.more, failures: prev.failures}
+		}
+	}
+	go func() {
+		defer close(finished)
+		w.statMany(work)
+		forEach(len(work), w.StatConcurrency, func(i int) {
+			if work[i].skip || ctx.Err() != nil {
+				return
+			}
+			work[i].check(ctx, w)
+			mu.Lock()
+			defer mu.Unlock()
+			if !abandoned {
+				prev := s.entries[i].prev
+				s.entries[i] = work[i]
+				s.entries[i].prev = prev
+				reached[i] = true
+			}
+		})
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	abandoned = true
+	for i := range s.entries {
+		if e := &s.entries[i]; !reached[i] && !e.skip {
+			e.skip = true
+			s.unreached = append(s.unreached, e.path)
+		}
+	}
+	if len(s.unreached) > 0 {
+		s.ctxErr = ctx.Err()
+	}
+}
+
+// check stats the path of e and records whether it changed, retrying
+// failures according to w.Retry. Only e is modified, so entries may be
+// checked concurrently.
+func (e *scanEntry) check(ctx context.Context, w *Watcher) {
+	if w.profile.Load() != nil {
+		start := w.clock().Now()
+		defer func() { e.took = w.clock().Now().Sub(start) }()
+	}
+	info, err := e.statLink(w)
+	for i := 0; w.Retry.retry(ctx, w.clock(), err, i); i++ {
+		info, err = e.statLink(w)
+	}
+	if info == nil && err != nil && !errors.Is(err, fs.ErrNotExist) {
+		if e.prev != nil {
+			e.failures = e.prev.failures
+		}
+		e.failures++
+		if w.Retry.tolerate(err, e.failures) {
+			err = nil
+		}
+	}
+	e.errs[0] = err
+	if info == nil {
+		if e.prev != nil && e.prev.info != nil && errors.Is(err, fs.ErrNotExist) && !e.holdRemove(w) {
+			e.updated, e.op = true, Remove
+		}
+		return
+	}
+	e.info = info
+	switch {
+	case e.prev == nil:
+		// the first time a path is seen it is not reported, even if it exists
+		e.sum, e.errs[1] = w.digest(e, info)
+	case e.prev.info == nil:
+		// the path was seen before, but did not exist
+		e.updated, e.op = true, Create
+		e.sum, e.errs[1] = w.digest(e, info)
+	default:
+		e.updated, e.sum, e.errs[1] = w.changed(e.fsys, e.path, e.detect, e.prev, info)
+		if e.linkChanged(e.prev) {
+			e.updated = true
+		}
+		if e.updated {
+			e.op = Write
+		} else if w.DetectMode && e.prev.info.Mode() != info.Mode() {
+			e.updated, e.op = true, Chmod
+		} else if e.mixed && e.detect == DetectHash {
+			// notify the nodes that do not verify the contents
+			if touched, _, _ := w.changed(e.fsys, e.path, DetectModTimeSize, e.prev, info); touched {
+				e.updated, e.op, e.touched = true, Write, true
+			}
+		}
+	}
+	if w.ListDirs && info.IsDir() {
+		e.listDir(w)
+	}
+}
+
+// holdRemove reports whether the removal of the path of e is held because
+// it has been missing for less than w.RemoveGrace, recording when it went
+// missing if so.
+func (e *scanEntry) holdRemove(w *Watcher) bool {
+	if w.RemoveGrace <= 0 {
+		return false
+	}
+	now := w.clock().Now()
+	since := e.prev.extra().missing
+	if since.IsZero() {
+		since = now
+	}
+	if now.Sub(since) >= w.RemoveGrace {
+		return false
+	}
+	e.missing = since
+	return true
+}
+
+// listDir records the entries of the directory of e and whether they changed
+// since the previous scan. If the directory cannot be read, the previous
+// listing is kept.
+func (e *scanEntry) listDir(w *Watcher) {
+	entries, err := w.listDir(e.fsys, e.path)
+	if err != nil {
+		e.errs[1] = errors.Join(e.errs[1], err)
+		if e.prev != nil {
+			e.entries = e.prev.extra().entries
+		}
+		return
+	}
+	e.entries = entries
+	if prev := e.prev.extra().entries; prev != nil && e.prev.info != nil && !slices.Equal(prev, entries) {
+		e.updated, e.op = true, Write
+		e.events = w.entryEvents(e.fsys, e.path, prev, entries)
+	}
+}
+
+// commit records the state detected by s in the path table and, unless s is
+// partial, forgets the paths that are no longer referenced by any node.
+func (w *Watcher) commit(s *scan) {
+	for _, e := range s.entries {
+		stat := e.prev
+		if stat == nil && e.skip {
+			// never checked, so there is nothing to record
+			continue
+		}
+		if stat == nil {
+			stat = new(pathStat)
+			key := w.key(e.root, e.path)
+			// the path may be part of a longer string the node keeps
+			key.base = strings.Clone(key.base)
+			w.paths[key] = stat
+		}
+		if e.info != nil || e.updated {
+			// a removed path is recorded as missing
+			stat.info = reuseFileStat(stat.info, e.info)
+			stat.setExtra(pathExtra{sum: e.sum, link: e.link, target: reuseFileStat(stat.extra().target, e.target), entries: e.entries})
+		} else if !e.missing.IsZero() {
+			// keep the state from before the path went missing
+			x := stat.extra()
+			x.missing = e.missing
+			stat.setExtra(x)
+		}
+		if !e.skip {
+			stat.failures = e.failures
+		}
+		stat.nodes = e.nodes
+	}
+	for _, node := range s.due {
+		w.checked[node] = s.now
+	}
+	if s.partial {
+		return
+	}
+	for key := range w.paths {
+		if _, ok := s.index[key]; !ok {
+			delete(w.paths, key)
+		}
+	}
+	w.dirs.sweep(w.paths)
+}
+
+// digest returns the fingerprint to record for the file of e, described by
+// info, when it has no previous fingerprint to compare with. It is nil for a
+// StatDetector, whose fingerprints are not kept.
+func (w *Watcher) digest(e *scanEntry, info fs.FileInfo) ([]byte, error) {
+	d := w.detector(e.detect)
+	if _, ok := d.(StatDetector); ok {
+		return nil, nil
+	}
+	return fingerprint(d, e.fsys, e.path, info)
+}
+
+// forEach calls fn for every index in [0, n) on up to workers goroutines and
+// returns once all calls have finished.
+func forEach(n, workers int, fn func(i int)) {
+	if workers <= 1 || n <= 1 {
+		for i := range n {
+			fn(i)
+		}
+		return
+	}
+	workers = min(workers, n)
+	var (
+		wg   sync.WaitGroup
+		next atomic.Int64
+	)
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= n {
+					return
+				}
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,191 @@
+package watch
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// ContextNode is an optional interface for Nodes whose updates can be
+// cancelled. The Watcher calls UpdatedContext instead of Updated, with the
+// context passed to ScanContext, or context.Background for Scan. Wrapped
+// with a Scheduler, the context is cancelled when the node's paths change
+// again before the update finishes.
+type ContextNode interface {
+	Node
+
+	// UpdatedContext is called in place of Updated. It should return
+	// promptly once ctx is done.
+	UpdatedContext(ctx context.Context) error
+}
+
+// Scheduler runs the updates of ContextNodes asynchronously, so that a Scan
+// returns without waiting for long rebuilds, such as those of a dev server.
+// If a node is notified again while its update is still running, the
+// running update's context is cancelled and the node is updated again once
+// it has returned, with the fresh state. Updates waiting for a worker are
+// started in priority order, see Prioritizer. The zero value is ready to use.
+type Scheduler struct {
+	// Workers is the maximum number of updates running at once. If zero,
+	// the number is not limited.
+	Workers int
+
+	// OnError, if not nil, is called with the error of every update that
+	// fails, wrapped in a *NodeError. Errors of updates whose context was
+	// cancelled are not reported.
+	OnError func(err error)
+
+	mu      sync.Mutex
+	builds  map[Node]*build
+	queue   []*build
+	running int
+	seq     uint64
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// build is a running or queued update of a node.
+type build struct {
+	node    ContextNode
+	seq     uint64 // queue order among nodes of equal priority
+	running bool
+	again   bool   // update again once the running update returns
+	reason  Reason // passed to a ReasonNode
+	cancel  context.CancelFunc
+}
+
+// Wrap returns a node that forwards Paths and Priority to node and schedules
+// an update of node when it is updated. Register the returned node with the
+// Watcher instead of node.
+func (s *Scheduler) Wrap(node ContextNode) Node {
+	return &scheduledNode{s: s, node: node}
+}
+
+// Wait waits until no update is running or queued.
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Close cancels the running updates, drops the queued ones and waits for the
+// running ones to return. Nodes notified afterwards are not updated.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for _, b := range s.queue {
+		delete(s.builds, b.node)
+		s.wg.Done()
+	}
+	s.queue = nil
+	for _, b := range s.builds {
+		b.again = false
+		b.cancel()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// schedule queues an update of node, or cancels and restarts its running
+// update. An update coalescing several notifications for different reasons
+// is made with ReasonChange.
+func (s *Scheduler) schedule(node ContextNode, reason Reason) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if b, ok := s.builds[node]; ok {
+		if b.running {
+			if !b.again {
+				b.reason = reason
+			}
+			b.again = true
+			b.cancel()
+		}
+		if b.reason != reason {
+			b.reason = ReasonChange
+		}
+		// a queued update will see the fresh state when it starts
+		return
+	}
+	if s.builds == nil {
+		s.builds = make(map[Node]*build)
+	}
+	b := &build{node: node, reason: reason}
+	s.builds[node] = b
+	s.wg.Add(1)
+	s.enqueue(b)
+}
+
+// enqueue adds b to the queue and starts as many queued updates as there
+// are free workers. s.mu must be held.
+func (s *Scheduler) enqueue(b *build) {
+	s.seq++
+	b.seq = s.seq
+	s.queue = append(s.queue, b)
+	slices.SortStableFunc(s.queue, func(a, b *build) int {
+		if c := cmp.Compare(priority(b.node), priority(a.node)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.seq, b.seq)
+	})
+	for len(s.queue) > 0 && (s.Workers <= 0 || s.running < s.Workers) {
+		next := s.queue[0]
+		s.queue = s.queue[1:]
+		s.start(next)
+	}
+}
+
+// start runs the update b on a new goroutine. s.mu must be held.
+func (s *Scheduler) start(b *build) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.running, b.cancel = true, cancel
+	s.running++
+	reason := b.reason
+	go func() {
+		err := update(ctx, b.node, nil, reason)
+		cancelled := ctx.Err() != nil
+		cancel()
+		if err != nil && !(cancelled && errors.Is(err, context.Canceled)) && s.OnError != nil {
+			s.OnError(err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.running--
+		b.running = false
+		if b.again && !s.closed {
+			b.again = false
+			s.enqueue(b)
+			return
+		}
+		delete(s.builds, b.node)
+		s.wg.Done()
+		if len(s.queue) > 0 {
+			next := s.queue[0]
+			s.queue = s.queue[1:]
+			s.start(next)
+		}
+	}()
+}
+
+type scheduledNode struct {
+	s    *Scheduler
+	node ContextNode
+}
+
+func (n *scheduledNode) Paths() []string { return n.node.Paths() }
+
+func (n *scheduledNode) Priority() int { return priority(n.node) }
+
+func (n *scheduledNode) Updated() error {
+	n.s.schedule(n.node, ReasonChange)
+	return nil
+}
+
+// UpdatedReason implements ReasonNode, so that the reason is passed on to
+// node if it is a ReasonNode.
+func (n *scheduledNode) UpdatedReason(ctx context.Context, reason Reason) error {
+	n.s.schedule(n.node, reason)
+	return nil
+}
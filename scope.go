@@ -0,0 +1,132 @@
+package watch
+
+import (
+	"path/filepath"
+	"slices"
+)
+
+// Scope returns a view of w for the part of the tree under prefix, such as
+// one project of a multi-project dev server, so that each project can manage
+// its own nodes while one scan loop drives them all. The scope is a Watcher
+// with nodes of its own: Register, Unregister, Nodes, Paths and Events
+// concern only them. Its nodes name their paths as w's nodes do, but the
+// paths outside prefix are ignored, as if excluded by Ignore; a prefix of ""
+// or "." covers the whole tree.
+//
+// The scope's configuration and ignore rules are copied from w when Scope is
+// called, except for Hooks, Metrics and Adaptive. Every Scan, ScanContext,
+// ScanResult and ScanPaths of w scans its open scopes after its own nodes,
+// merging their results into w's, and the scope may still be scanned on its
+// own. Unless the scope is given a StatCache of its own, a scan of w shares
+// one StatCache with the scope, w's or, if w has none, one created for the
+// scan, so a path watched by both is statted once per scan.
+//
+// Closing the scope detaches its nodes, as Close does, and removes it from
+// w; closing w closes its scopes. Scope is safe for concurrent use.
+func (w *Watcher) Scope(prefix string) *Watcher {
+	s := &Watcher{
+		FS:               w.FS,
+		Strict:           w.Strict,
+		Detect:           w.Detect,
+		Detector:         w.Detector,
+		NewHash:          w.NewHash,
+		NotifyExisting:   w.NotifyExisting,
+		Symlinks:         w.Symlinks,
+		ListDirs:         w.ListDirs,
+		ListSubtrees:     w.ListSubtrees,
+		DetectMode:       w.DetectMode,
+		RemoveGrace:      w.RemoveGrace,
+		PathNormalizer:   w.PathNormalizer,
+		Retry:            w.Retry,
+		UpdateRetry:      w.UpdateRetry,
+		Journal:          w.Journal,
+		UpdateAllAbsorbs: w.UpdateAllAbsorbs,
+		MaxPaths:         w.MaxPaths,
+		OnPathLimit:      w.OnPathLimit,
+		Filter:           w.Filter,
+		Debounce:         w.Debounce,
+		RateLimit:        w.RateLimit,
+		Clock:            w.Clock,
+		Compare:          w.Compare,
+		ErrorHandler:     w.ErrorHandler,
+		Concurrency:      w.Concurrency,
+		StatConcurrency:  w.StatConcurrency,
+		ignore:           slices.Clone(w.ignore),
+		parent:           w,
+		prefix:           splitPath(filepath.ToSlash(prefix)),
+	}
+	w.scopesMu.Lock()
+	closed := w.closed.Load()
+	if !closed {
+		w.scopes = append(w.scopes, s)
+	}
+	w.scopesMu.Unlock()
+	if closed {
+		s.Close()
+	}
+	return s
+}
+
+// Scopes returns the open scopes of w, in the order they were created.
+func (w *Watcher) Scopes() []*Watcher {
+	w.scopesMu.Lock()
+	defer w.scopesMu.Unlock()
+	return slices.Clone(w.scopes)
+}
+
+// removeScope removes s from the scopes of w.
+func (w *Watcher) removeScope(s *Watcher) {
+	w.scopesMu.Lock()
+	defer w.scopesMu.Unlock()
+	if i := slices.Index(w.scopes, s); i >= 0 {
+		w.scopes = slices.Delete(w.scopes, i, i+1)
+	}
+}
+
+// closeScopes closes the scopes of w.
+func (w *Watcher) closeScopes() {
+	w.scopesMu.Lock()
+	scopes := w.scopes
+	w.scopes = nil
+	w.scopesMu.Unlock()
+	for _, s := range scopes {
+		s.Close()
+	}
+}
+
+// outOfScope reports whether the path with the given segments is outside the
+// prefix of a scope. The directories containing the prefix are in scope if
+// isDir is set, so that walkers descend into them, but are not watched.
+func (w *Watcher) outOfScope(segments []string, isDir bool) bool {
+	n := min(len(segments), len(w.prefix))
+	if !slices.Equal(segments[:n], w.prefix[:n]) {
+		return true
+	}
+	return len(segments) < len(w.prefix) && !isDir
+}
+
+// merge adds the results of a scan of a scope to r. A scope closed
+// concurrently is skipped.
+func (r *ScanResult) merge(s ScanResult) {
+	if len(s.Errors) == 1 && s.Errors[0] == ErrClosed {
+		return
+	}
+	r.Updated = r.Updated || s.Updated
+	r.Changed = mergeSorted(r.Changed, s.Changed)
+	r.Deleted = mergeSorted(r.Deleted, s.Deleted)
+	r.Notified = append(r.Notified, s.Notified...)
+	r.Unreached = append(r.Unreached, s.Unreached...)
+	r.Errors = append(r.Errors, s.Errors...)
+	r.StatErrors += s.StatErrors
+	r.Duration += s.Duration
+}
+
+// mergeSorted merges the sorted paths of b into a.
+func mergeSorted(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+	a = append(a, b...)
+	slices.Sort(a)
+	return slices.Compact(a)
+}
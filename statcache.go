@@ -0,0 +1,227 @@
+package watch
+
+import (
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// StatCache shares the results of stat calls between Watchers, so that a
+// path watched by several of them, such as a header included by the nodes
+// of many, is statted once per scan cycle rather than once per Watcher.
+// Results are keyed by file system and path, after normalization by each
+// Watcher's PathNormalizer. Only successful stats and ErrNotExist are
+// cached, so other errors are still retried under each Watcher's Retry
+// policy. Content hashes are not cached. A StatCache is safe for concurrent
+// use.
+//
+// A Group with a StatCache uses it for every Watcher without its own and
+// clears it at the start of each Group scan, so results are only shared
+// within a scan cycle.
+type StatCache struct {
+	// TTL is how long a result is reused. If zero, results are valid for
+	// one scan cycle: a Watcher clears its StatCache at the start of every
+	// Scan, and a Group clears its own at the start of every Group scan.
+	TTL time.Duration
+
+	// Clock is the source of time used for TTL. If nil, the system clock is
+	// used.
+	Clock Clock
+
+	mu      sync.Mutex
+	roots   []fs.FS
+	entries map[statKey]statResult
+	gen     uint64 // incremented by Reset
+	hits    uint64
+	misses  uint64
+}
+
+type statKey struct {
+	root  int // index in StatCache.roots, or -1 for the OS
+	path  string
+	lstat bool
+}
+
+type statResult struct {
+	info fs.FileInfo
+	link string
+	err  error
+	at   time.Time
+}
+
+// Reset forgets all cached results.
+func (c *StatCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roots = nil
+	c.entries = nil
+	c.gen++
+}
+
+// Forget forgets the cached results for paths, in every file system, so
+// that changes reported by another source, such as an editor, are seen by
+// the next stat.
+func (c *StatCache) Forget(paths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) == 0 {
+		return
+	}
+	for _, p := range paths {
+		for root := -1; root < len(c.roots); root++ {
+			delete(c.entries, statKey{root: root, path: p})
+			delete(c.entries, statKey{root: root, path: p, lstat: true})
+		}
+	}
+}
+
+// Stats returns the number of lookups answered from the cache and the
+// number of stat calls made, since the StatCache was created.
+func (c *StatCache) Stats() (hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+func (c *StatCache) clock() Clock {
+	if c.Clock == nil {
+		return systemClock{}
+	}
+	return c.Clock
+}
+
+// key returns the cache key of p in fsys. c.mu must be held.
+func (c *StatCache) key(fsys fs.FS, p string, lstat bool) statKey {
+	k := statKey{root: -1, path: p, lstat: lstat}
+	if fsys == nil {
+		return k
+	}
+	for i, root := range c.roots {
+		if sameFS(root, fsys) {
+			k.root = i
+			return k
+		}
+	}
+	c.roots = append(c.roots, fsys)
+	k.root = len(c.roots) - 1
+	return k
+}
+
+// stat returns the cached result for p in fsys, calling do to stat it if
+// there is none or it has expired. lstat distinguishes results that do not
+// follow symbolic links.
+func (c *StatCache) stat(fsys fs.FS, p string, lstat bool, do func() (fs.FileInfo, string, error)) (fs.FileInfo, string, error) {
+	now := c.clock().Now()
+	c.mu.Lock()
+	k := c.key(fsys, p, lstat)
+	r, ok := c.entries[k]
+	if ok && (c.TTL <= 0 || now.Sub(r.at) < c.TTL) {
+		c.hits++
+		c.mu.Unlock()
+		return r.info, r.link, r.err
+	}
+	c.misses++
+	gen := c.gen
+	c.mu.Unlock()
+
+	info, link, err := do()
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		c.mu.Lock()
+		// a result from before a Reset is not kept
+		if c.gen == gen {
+			if c.entries == nil {
+				c.entries = make(map[statKey]statResult)
+			}
+			c.entries[k] = statResult{info: info, link: link, err: err, at: now}
+		}
+		c.mu.Unlock()
+	}
+	return info, link, err
+}
+
+// newCycle starts a scan cycle of w and returns the StatCache used by w and
+// its scopes during the cycle: w's own, else shared, the cache of the cycle
+// scanning w, else that of w's Group or parent, else a new one if w has
+// scopes. A cache other than shared is cleared unless its results have a
+// TTL. w.busy must be held.
+func (w *Watcher) newCycle(shared *StatCache) *StatCache {
+	c := w.StatCache
+	if c == nil && shared != nil {
+		return shared
+	}
+	if c == nil {
+		c = w.configuredCache()
+	}
+	if c == nil {
+		if len(w.Scopes()) > 0 {
+			return new(StatCache)
+		}
+		return nil
+	}
+	if c.TTL <= 0 && c != shared {
+		c.Reset()
+	}
+	return c
+}
+
+// configuredCache returns the StatCache of w, else that of its Group, else
+// that of the Watcher it is a scope of, if any.
+func (w *Watcher) configuredCache() *StatCache {
+	switch {
+	case w.StatCache != nil:
+		return w.StatCache
+	case w.group != nil && w.group.StatCache != nil:
+		return w.group.StatCache
+	case w.parent != nil:
+		return w.parent.configuredCache()
+	}
+	return nil
+}
+
+// statCache returns the StatCache used by w, if any: that of the current
+// scan cycle, or the configured one between cycles.
+func (w *Watcher) statCache() *StatCache {
+	if c := w.cycle.Load(); c != nil {
+		return c
+	}
+	return w.configuredCache()
+}
+
+// cachedStat stats p in fsys through w's StatCache, if it has one.
+func (w *Watcher) cachedStat(fsys fs.FS, p string) (fs.FileInfo, error) {
+	c := w.statCache()
+	if c == nil {
+		return statFile(fsys, p)
+	}
+	info, _, err := c.stat(fsys, p, false, func() (fs.FileInfo, string, error) {
+		info, err := statFile(fsys, p)
+		return info, "", err
+	})
+	return info, err
+}
+
+// cachedResult returns the result of a stat of p in fsys made elsewhere,
+// such as by StatMany, recording it in w's StatCache, if it has one. A result
+// already in the cache is returned instead.
+func (w *Watcher) cachedResult(fsys fs.FS, p string, info fs.FileInfo, err error) (fs.FileInfo, error) {
+	c := w.statCache()
+	if c == nil {
+		return info, err
+	}
+	info, _, err = c.stat(fsys, p, false, func() (fs.FileInfo, string, error) {
+		return info, "", err
+	})
+	return info, err
+}
+
+// cachedLstat is like cachedStat for lstatFile.
+func (w *Watcher) cachedLstat(fsys fs.FS, p string) (fs.FileInfo, string, error) {
+	c := w.statCache()
+	if c == nil {
+		return lstatFile(fsys, p)
+	}
+	return c.stat(fsys, p, true, func() (fs.FileInfo, string, error) {
+		return lstatFile(fsys, p)
+	})
+}
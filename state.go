@@ -0,0 +1,258 @@
+package watch
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"time"
+)
+
+// stateVersion is the version of the format written by SaveState.
+const stateVersion = 2
+
+type savedState struct {
+	Version int                  `json:"version"`
+	Paths   map[string]savedPath `json:"paths"`
+}
+
+type savedPath struct {
+	Missing bool          `json:"missing,omitempty"`
+	ModTime time.Time     `json:"modTime,omitzero"`
+	Size    int64         `json:"size,omitempty"`
+	Mode    fs.FileMode   `json:"mode,omitempty"`
+	Sum     []byte        `json:"sum,omitempty"`
+	ID      *fileIdentity `json:"id,omitempty"`
+	Link    string        `json:"link,omitempty"`
+	Entries []string      `json:"entries,omitzero"`
+	Target  *savedPath    `json:"target,omitempty"`
+}
+
+// State is the state of the watched paths at some point in time, as
+// returned by Snapshot. It records the modification time, size, identity and,
+// where known, content digest of each path, and whether it was missing. The
+// zero value is an empty State. A State encodes to JSON in the format written
+// by SaveState.
+type State struct {
+	paths map[string]savedPath
+}
+
+// Paths returns the sorted paths recorded in s, including missing ones.
+func (s State) Paths() []string {
+	return slices.Sorted(maps.Keys(s.paths))
+}
+
+// Info returns the recorded state of path, or nil if path was missing. ok
+// is false if path is not recorded in s. The returned fs.FileInfo has an
+// empty name.
+func (s State) Info(path string) (info fs.FileInfo, ok bool) {
+	saved, ok := s.paths[path]
+	if !ok || saved.Missing {
+		return nil, ok
+	}
+	return saved.info(), true
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s State) MarshalJSON() ([]byte, error) {
+	paths := s.paths
+	if paths == nil {
+		paths = map[string]savedPath{}
+	}
+	return json.Marshal(savedState{Version: stateVersion, Paths: paths})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *State) UnmarshalJSON(data []byte) error {
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if state.Version != stateVersion {
+		return fmt.Errorf("watch: unsupported state version %d", state.Version)
+	}
+	s.paths = state.Paths
+	return nil
+}
+
+// Snapshot stats the paths of every registered node now and returns their
+// state, without recording it or notifying any node, so that tools can
+// compare the watched tree at two points in time with Diff, such as before
+// and after running a build step. Paths of nodes whose Interval has not
+// elapsed are reported as recorded by the last Scan, and digests are only
+// computed for the paths of nodes using DetectHash. Like SaveState, it
+// leaves out the paths of nodes registered with RegisterFS.
+func (w *Watcher) Snapshot() (State, error) {
+	if err := w.begin(); err != nil {
+		return State{}, err
+	}
+	defer w.busy.Unlock()
+	if err := w.checkFS(); err != nil {
+		return State{}, err
+	}
+	s := w.detect(context.Background(), false, w.clock().Now())
+	defer w.release(s)
+	state := State{paths: make(map[string]savedPath, len(s.entries))}
+	for i := range s.entries {
+		e := &s.entries[i]
+		if e.root != 0 {
+			continue
+		}
+		if e.skip {
+			if e.prev != nil {
+				state.paths[e.path] = savePath(e.prev.info, e.prev.extra())
+			}
+			continue
+		}
+		if err := e.errs[0]; e.info == nil && err != nil && !errors.Is(err, fs.ErrNotExist) {
+			// unknown, rather than missing
+			continue
+		}
+		state.paths[e.path] = savePath(newFileStat(e.info), pathExtra{sum: e.sum, link: e.link, target: newFileStat(e.target), entries: e.entries})
+	}
+	return state, nil
+}
+
+// Diff returns the changes between the states a and b, sorted by path: a
+// Create for each path missing or not recorded in a that exists in b, a
+// Remove for each path that exists in a but is missing in b, and a Write for
+// each path whose modification time, size or identity differ, or whose
+// digests differ if both states have one. A removed and a created path with
+// the same file identity are reported as a Rename. Paths not recorded in b
+// are not compared.
+func Diff(a, b State) []Event {
+	var created, removed, events []Event
+	for _, p := range b.Paths() {
+		after := b.paths[p]
+		before, ok := a.paths[p]
+		switch {
+		case after.Missing && ok && !before.Missing:
+			removed = append(removed, Event{Op: Remove, Path: p})
+		case after.Missing:
+		case !ok || before.Missing:
+			created = append(created, Event{Op: Create, Path: p})
+		case before.changed(&after):
+			events = append(events, Event{Op: Write, Path: p})
+		}
+	}
+	// pair removed and created paths of the same file
+	for _, r := range removed {
+		id := a.paths[r.Path].ID
+		i := slices.IndexFunc(created, func(c Event) bool {
+			return id != nil && c.Op == Create && b.paths[c.Path].ID != nil && *b.paths[c.Path].ID == *id
+		})
+		if i < 0 {
+			events = append(events, r)
+			continue
+		}
+		created[i] = Event{Op: Rename, Path: created[i].Path, OldPath: r.Path}
+	}
+	events = append(events, created...)
+	slices.SortFunc(events, func(x, y Event) int {
+		return cmp.Compare(x.Path, y.Path)
+	})
+	return events
+}
+
+// changed reports whether the path recorded by sp changed in other.
+func (sp *savedPath) changed(other *savedPath) bool {
+	if sp.Sum != nil && other.Sum != nil {
+		return !bytes.Equal(sp.Sum, other.Sum)
+	}
+	return !sp.ModTime.Equal(other.ModTime) || sp.Size != other.Size || sp.Mode.IsDir() != other.Mode.IsDir() ||
+		(sp.ID == nil) != (other.ID == nil) || sp.ID != nil && *sp.ID != *other.ID ||
+		sp.Link != other.Link || !slices.Equal(sp.Entries, other.Entries)
+}
+
+// state returns the state recorded in the path table.
+func (w *Watcher) state() State {
+	state := State{paths: make(map[string]savedPath, len(w.paths))}
+	for key, stat := range w.paths {
+		if key.root != 0 {
+			// only the paths of FS are saved
+			continue
+		}
+		state.paths[w.path(key)] = savePath(stat.info, stat.extra())
+	}
+	return state
+}
+
+// SaveState writes the modification time, size and content digest of every
+// path seen by the last call to Scan to wr as JSON. A Watcher restored with
+// LoadState compares against the saved state on its next Scan, so changes made
+// while the process was not running are reported. The paths of nodes
+// registered with RegisterFS are not saved.
+func (w *Watcher) SaveState(wr io.Writer) error {
+	return json.NewEncoder(wr).Encode(w.state())
+}
+
+// LoadState restores the path table written by SaveState. Paths already
+// known to the Watcher are replaced. Register the nodes that reference the
+// saved paths before the next call to Scan, as paths not referenced by any
+// node are forgotten by Scan.
+func (w *Watcher) LoadState(r io.Reader) error {
+	if !w.initialized {
+		w.init()
+	}
+	var state State
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return err
+	}
+	for p, saved := range state.paths {
+		stat := new(pathStat)
+		if !saved.Missing {
+			stat.info = saved.info()
+		}
+		var target *fileStat
+		if saved.Target != nil {
+			target = saved.Target.info()
+		}
+		stat.setExtra(pathExtra{sum: saved.Sum, link: saved.Link, target: target, entries: saved.Entries})
+		w.paths[w.key(0, p)] = stat
+	}
+	return nil
+}
+
+// savePath returns the saved form of a path with the given info, which is
+// nil if the path is missing, and extra state.
+func savePath(info *fileStat, x pathExtra) savedPath {
+	if info == nil {
+		return savedPath{Missing: true}
+	}
+	saved := saveInfo(info)
+	saved.Sum = x.sum
+	saved.Link = x.link
+	saved.Entries = x.entries
+	if x.target != nil {
+		target := saveInfo(x.target)
+		saved.Target = &target
+	}
+	return saved
+}
+
+func saveInfo(info fs.FileInfo) savedPath {
+	saved := savedPath{
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+	}
+	if id, ok := fileID(info); ok {
+		saved.ID = &id
+	}
+	return saved
+}
+
+// info returns the file info of a path restored by LoadState.
+func (sp *savedPath) info() *fileStat {
+	fi := &fileStat{sec: sp.ModTime.Unix(), nsec: int32(sp.ModTime.Nanosecond()), mode: sp.Mode, size: sp.Size}
+	if sp.ID != nil {
+		fi.id, fi.hasID = *sp.ID, true
+	}
+	return fi
+}
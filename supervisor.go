@@ -0,0 +1,241 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// DefaultGracePeriod is the time a Supervisor with a zero GracePeriod gives
+// its process to exit before killing it.
+const DefaultGracePeriod = 5 * time.Second
+
+// Supervisor is a Node that keeps a long-running process, such as a
+// development server, running, and restarts it whenever any of its Files
+// change. A restart first asks the running process to exit by sending it
+// SIGTERM, and kills it if it has not exited after GracePeriod; on platforms
+// without signals it is killed right away. On Unix the signals are sent to
+// the process group of the process, so that the processes it started exit
+// too.
+//
+// A process that exits on its own is restarted after Backoff, which doubles
+// on every consecutive exit, so that a server that crashes on startup is not
+// restarted in a tight loop. A Supervisor must not be copied after first
+// use.
+//
+//	s := &watch.Supervisor{
+//		Command: []string{"go", "run", "./cmd/server"},
+//		Stdout:  os.Stdout,
+//		Stderr:  os.Stderr,
+//		Backoff: time.Second,
+//		Clock:   w.Clock,
+//	}
+//	w.Register(&watch.GlobNode{Watcher: w, Patterns: []string{"**/*.go"}, Node: s})
+//	if err := s.Start(); err != nil {
+//		log.Fatal(err)
+//	}
+//	defer s.Stop()
+type Supervisor struct {
+	// Files lists the paths watched by the node.
+	Files []string
+
+	// Command is the program to run and its arguments.
+	Command []string
+
+	// Dir is the working directory of the process. If empty, the process
+	// runs in the current directory.
+	Dir string
+
+	// Env lists environment variables, in the form "key=value", added to
+	// the environment of the current process for the process.
+	Env []string
+
+	// Stdin, Stdout and Stderr are connected to the process. If nil, they
+	// are connected to the null device.
+	Stdin          io.Reader
+	Stdout, Stderr io.Writer
+
+	// GracePeriod is the time the process is given to exit after SIGTERM
+	// before it is killed. If zero, DefaultGracePeriod is used.
+	GracePeriod time.Duration
+
+	// Backoff is the delay before restarting a process that exited on its
+	// own. It doubles for every consecutive exit, up to MaxBackoff if it is
+	// not zero, and is reset when the process is restarted by a change or
+	// by Start. If zero, a process that exits is only started again by the
+	// next change.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Clock, if not nil, times Backoff, such as the Clock of the Watcher
+	// the node is registered with. If nil, the system clock is used.
+	Clock Clock
+
+	// OnExit, if not nil, is called with the result of every run that
+	// exited on its own, before it is restarted, and with the error of a
+	// failed restart.
+	OnExit func(err error)
+
+	mu      sync.Mutex
+	proc    *process
+	pending chan struct{} // closed to cancel the restart after an exit
+	attempt int           // consecutive exits
+}
+
+// process is a started run of the Supervisor's command.
+type process struct {
+	cmd  *exec.Cmd
+	done chan struct{} // closed once the process has exited
+	err  error
+}
+
+// Paths implements Node.
+func (s *Supervisor) Paths() []string {
+	return s.Files
+}
+
+// Updated implements Node by restarting the process.
+func (s *Supervisor) Updated() error {
+	return s.Start()
+}
+
+// Start starts the process, stopping the running one first if there is one,
+// as Stop does.
+func (s *Supervisor) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop()
+	s.attempt = 0
+	return s.start()
+}
+
+// Stop stops the process, if it is running, and cancels any pending restart.
+// The process is not started again until the next change or call to Start.
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stop()
+}
+
+// Detached implements Detacher by calling Stop.
+func (s *Supervisor) Detached() {
+	s.Stop()
+}
+
+// Pid returns the process ID of the running process, or 0 if none is
+// running.
+func (s *Supervisor) Pid() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.proc == nil {
+		return 0
+	}
+	return s.proc.cmd.Process.Pid
+}
+
+// start starts a new process. s.mu must be held.
+func (s *Supervisor) start() error {
+	if len(s.Command) == 0 {
+		return errors.New("watch: Supervisor has no command")
+	}
+	c := &CommandNode{Dir: s.Dir, Env: s.Env, Stdin: s.Stdin, Stdout: s.Stdout, Stderr: s.Stderr}
+	// the process is stopped by stop rather than by a context
+	cmd := c.newCmd(context.Background(), s.Command)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s: %w", s.Command[0], err)
+	}
+	p := &process{cmd: cmd, done: make(chan struct{})}
+	s.proc = p
+	go func() {
+		p.err = cmd.Wait()
+		close(p.done)
+		s.exited(p)
+	}()
+	return nil
+}
+
+// exited handles the exit of p, restarting it after the backoff delay unless
+// it was stopped.
+func (s *Supervisor) exited(p *process) {
+	s.mu.Lock()
+	if s.proc != p {
+		// stopped or replaced
+		s.mu.Unlock()
+		return
+	}
+	s.proc = nil
+	if s.Backoff > 0 {
+		pending := make(chan struct{})
+		s.pending = pending
+		go s.restart(pending, backoff(s.Backoff, s.MaxBackoff, s.attempt))
+		s.attempt++
+	}
+	s.mu.Unlock()
+	if s.OnExit != nil {
+		err := p.err
+		if err != nil {
+			err = fmt.Errorf("%s: %w", s.Command[0], err)
+		}
+		s.OnExit(err)
+	}
+}
+
+// restart starts a new process after delay, as measured by the Clock,
+// unless pending is closed first.
+func (s *Supervisor) restart(pending chan struct{}, delay time.Duration) {
+	clock := s.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+	t := clock.NewTicker(delay)
+	defer t.Stop()
+	select {
+	case <-t.C():
+	case <-pending:
+		return
+	}
+	s.mu.Lock()
+	if s.pending != pending {
+		s.mu.Unlock()
+		return
+	}
+	s.pending = nil
+	err := s.start()
+	s.mu.Unlock()
+	if err != nil && s.OnExit != nil {
+		s.OnExit(err)
+	}
+}
+
+// stop cancels a pending restart and stops the running process, waiting for
+// it to exit. s.mu must be held.
+func (s *Supervisor) stop() {
+	if s.pending != nil {
+		close(s.pending)
+		s.pending = nil
+	}
+	p := s.proc
+	if p == nil {
+		return
+	}
+	s.proc = nil
+	grace := s.GracePeriod
+	if grace <= 0 {
+		grace = DefaultGracePeriod
+	}
+	if err := terminateCommand(p.cmd); err == nil {
+		t := time.NewTimer(grace)
+		defer t.Stop()
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+		}
+	}
+	killCommand(p.cmd)
+	<-p.done
+}
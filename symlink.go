@@ -0,0 +1,56 @@
+package watch
+
+import "io/fs"
+
+// SymlinkMode selects how a Watcher treats watched paths that are symbolic
+// links.
+type SymlinkMode int
+
+const (
+	// SymlinkFollow stats the target of a symbolic link, so changes to the
+	// link itself are not seen and broken links appear not to exist.
+	SymlinkFollow SymlinkMode = iota
+
+	// SymlinkLink stats the symbolic link itself without following it. A
+	// change is reported when the link is modified or retargeted. Broken
+	// links are watched like any other file.
+	SymlinkLink
+
+	// SymlinkBoth watches both the symbolic link and its target. A change is
+	// reported when the link is retargeted, or when either the link or its
+	// target is modified, created or removed.
+	SymlinkBoth
+)
+
+// statLink stats path according to Symlinks and records the results in e.
+func (e *scanEntry) statLink(w *Watcher) (fs.FileInfo, error) {
+	if w.Symlinks == SymlinkFollow {
+		if e.batched {
+			info, err := e.info, e.errs[0]
+			e.batched, e.info, e.errs[0] = false, nil, nil
+			return w.cachedResult(e.fsys, e.path, info, err)
+		}
+		return w.cachedStat(e.fsys, e.path)
+	}
+	info, link, err := w.cachedLstat(e.fsys, e.path)
+	e.link = link
+	if info != nil && link != "" && w.Symlinks == SymlinkBoth {
+		target, err := w.cachedStat(e.fsys, e.path)
+		e.target = target
+		e.errs[1] = err
+	}
+	return info, err
+}
+
+// linkChanged reports whether the symbolic link recorded in e differs from
+// the one recorded in prev.
+func (e *scanEntry) linkChanged(prev *pathStat) bool {
+	x := prev.extra()
+	if e.link != x.link {
+		return true
+	}
+	if (e.target == nil) != (x.target == nil) {
+		return true
+	}
+	return e.target != nil && !e.target.ModTime().Equal(x.target.ModTime())
+}
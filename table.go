@@ -0,0 +1,159 @@
+package watch
+
+import (
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// pathKey identifies a watched path within the file system of root. To keep
+// the path table small for large trees, the directory part of the path is
+// interned in the Watcher's dirTable and only the rest is stored in the key.
+type pathKey struct {
+	root int32
+	dir  uint32 // index in dirTable.names
+	base string // the path after dir, starting with its separator if any
+}
+
+// dirTable interns the directories of the watched paths, so that each
+// directory name is stored once rather than as the prefix of every path
+// below it.
+type dirTable struct {
+	ids   map[string]uint32
+	names []string
+	free  []uint32
+	used  []bool // reused by sweep
+}
+
+// intern returns the index of dir, adding it if necessary.
+func (t *dirTable) intern(dir string) uint32 {
+	if id, ok := t.ids[dir]; ok {
+		return id
+	}
+	if t.ids == nil {
+		t.ids = make(map[string]uint32)
+	}
+	dir = strings.Clone(dir)
+	var id uint32
+	if n := len(t.free); n > 0 {
+		id, t.free = t.free[n-1], t.free[:n-1]
+		t.names[id] = dir
+	} else {
+		id = uint32(len(t.names))
+		t.names = append(t.names, dir)
+	}
+	t.ids[dir] = id
+	return id
+}
+
+// sweep forgets the directories that no path in paths refers to.
+func (t *dirTable) sweep(paths map[pathKey]*pathStat) {
+	used := slices.Grow(t.used[:0], len(t.names))[:len(t.names)]
+	clear(used)
+	t.used = used
+	for key := range paths {
+		used[key.dir] = true
+	}
+	for id, ok := range used {
+		if name := t.names[id]; !ok && t.ids[name] == uint32(id) {
+			delete(t.ids, name)
+			t.names[id] = ""
+			t.free = append(t.free, uint32(id))
+		}
+	}
+}
+
+// splitDir splits p after the directory it is in, keeping the separator in
+// the second half so that the two concatenate back to p.
+func splitDir(p string) (dir, rest string) {
+	i := strings.LastIndexAny(p, `/\`)
+	if i < 0 {
+		return "", p
+	}
+	return p[:i], p[i:]
+}
+
+// key returns the key of path p in the file system of root.
+func (w *Watcher) key(root int, p string) pathKey {
+	dir, rest := splitDir(p)
+	return pathKey{root: int32(root), dir: w.dirs.intern(dir), base: rest}
+}
+
+// lookup returns the key of path p in the file system of root, if a
+// directory of such a path is known.
+func (w *Watcher) lookup(root int, p string) (pathKey, bool) {
+	dir, rest := splitDir(p)
+	id, ok := w.dirs.ids[dir]
+	return pathKey{root: int32(root), dir: id, base: rest}, ok
+}
+
+// path returns the path identified by key.
+func (w *Watcher) path(key pathKey) string {
+	return w.dirs.names[key.dir] + key.base
+}
+
+// fileStat is the part of an fs.FileInfo that is kept between scans. File
+// infos from the operating system carry the file name and the whole stat
+// structure, which is several times larger. A fileStat implements
+// fs.FileInfo, without a name, so that it can stand in for the original.
+type fileStat struct {
+	sec   int64
+	nsec  int32
+	mode  fs.FileMode
+	size  int64
+	id    fileIdentity
+	hasID bool
+}
+
+// newFileStat returns the fileStat of info, or nil if info is nil.
+func newFileStat(info fs.FileInfo) *fileStat {
+	if info == nil {
+		return nil
+	}
+	return reuseFileStat(nil, info)
+}
+
+// reuseFileStat is like newFileStat, but returns prev if it records the same
+// state as info, to avoid allocating for paths that did not change. A
+// fileStat is never modified once recorded, since in-flight scans may still
+// refer to it.
+func reuseFileStat(prev *fileStat, info fs.FileInfo) *fileStat {
+	if info == nil {
+		return nil
+	}
+	if fi, ok := info.(*fileStat); ok {
+		return fi
+	}
+	t := info.ModTime()
+	fi := fileStat{sec: t.Unix(), nsec: int32(t.Nanosecond()), mode: info.Mode(), size: info.Size()}
+	fi.id, fi.hasID = fileID(info)
+	if prev != nil && *prev == fi {
+		return prev
+	}
+	n := fi
+	return &n
+}
+
+func (fi *fileStat) Name() string       { return "" }
+func (fi *fileStat) Size() int64        { return fi.size }
+func (fi *fileStat) Mode() fs.FileMode  { return fi.mode }
+func (fi *fileStat) ModTime() time.Time { return time.Unix(fi.sec, int64(fi.nsec)) }
+func (fi *fileStat) IsDir() bool        { return fi.mode.IsDir() }
+
+func (fi *fileStat) Sys() any {
+	if !fi.hasID {
+		return nil
+	}
+	return &fi.id
+}
+
+// namedStat is a recorded fileStat returned by Watcher.Info, with the name
+// of its path.
+type namedStat struct {
+	*fileStat
+	name string
+}
+
+func (fi *namedStat) Name() string { return fi.name }
+func (fi *namedStat) Sys() any     { return nil }
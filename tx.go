@@ -0,0 +1,121 @@
+package watch
+
+import "io/fs"
+
+// Tx collects the registrations of a call to Batch.
+type Tx struct {
+	ops      []txOp
+	existing *bool
+}
+
+type txOp struct {
+	node       Node
+	unregister bool
+	fsys       fs.FS // for RegisterFS
+	hasFS      bool
+	opts       *NodeOptions // for RegisterWithOptions
+}
+
+// Register registers node when the batch is applied, like
+// Watcher.Register.
+func (tx *Tx) Register(node Node) {
+	tx.ops = append(tx.ops, txOp{node: node})
+}
+
+// RegisterFS registers node with the file system fsys when the batch is
+// applied, like Watcher.RegisterFS.
+func (tx *Tx) RegisterFS(fsys fs.FS, node Node) {
+	tx.ops = append(tx.ops, txOp{node: node, fsys: fsys, hasFS: true})
+}
+
+// RegisterWithOptions registers node with options when the batch is
+// applied, like Watcher.RegisterWithOptions.
+func (tx *Tx) RegisterWithOptions(node Node, opts NodeOptions) {
+	tx.ops = append(tx.ops, txOp{node: node, opts: &opts})
+}
+
+// Unregister unregisters node when the batch is applied, like
+// Watcher.Unregister.
+func (tx *Tx) Unregister(node Node) {
+	tx.ops = append(tx.ops, txOp{node: node, unregister: true})
+}
+
+// NotifyExisting overrides Watcher.NotifyExisting for the nodes registered
+// by the batch, so that the first Scan after it notifies all of them of
+// their existing paths, or none of them. Nodes implementing
+// ExistingNotifier still decide for themselves.
+func (tx *Tx) NotifyExisting(notify bool) {
+	tx.existing = &notify
+}
+
+// Batch calls fn to collect registrations and unregistrations, and then
+// applies them all at once, in the order they were made, so that no Scan
+// sees only some of them. Unlike Register, Batch may be called concurrently
+// with Scan: it waits for a running Scan to finish. If the Watcher has been
+// closed, nothing is applied and ErrClosed is returned.
+//
+//	w.Batch(func(tx *watch.Tx) {
+//		for _, page := range pages {
+//			tx.Register(page)
+//		}
+//		tx.Unregister(oldIndex)
+//	})
+func (w *Watcher) Batch(fn func(tx *Tx)) error {
+	var tx Tx
+	fn(&tx)
+	if err := w.begin(); err != nil {
+		return err
+	}
+	defer w.busy.Unlock()
+	w.apply(&tx)
+	return nil
+}
+
+// BatchLater is like Batch, but may be called from Updated, including with
+// Concurrency above 1, and returns without waiting: fn is called and its
+// registrations applied once the running scan or UpdateAll has notified its
+// nodes, or, if none is running, by the next call to a scanning method or
+// Batch. fn is called with the Watcher locked, so it must not call the
+// Watcher's methods. Batches still queued when the Watcher is closed are
+// dropped.
+func (w *Watcher) BatchLater(fn func(tx *Tx)) {
+	w.laterMu.Lock()
+	defer w.laterMu.Unlock()
+	w.later = append(w.later, fn)
+}
+
+// applyLater applies the batches queued by BatchLater, in order. w.busy
+// must be held.
+func (w *Watcher) applyLater() {
+	w.laterMu.Lock()
+	later := w.later
+	w.later = nil
+	w.laterMu.Unlock()
+	for _, fn := range later {
+		var tx Tx
+		fn(&tx)
+		w.apply(&tx)
+	}
+}
+
+// apply applies the registrations of tx. w.busy must be held.
+func (w *Watcher) apply(tx *Tx) {
+	for _, op := range tx.ops {
+		if op.unregister {
+			w.Unregister(op.node)
+			continue
+		}
+		_, registered := w.nodes[op.node]
+		switch {
+		case op.hasFS:
+			w.RegisterFS(op.fsys, op.node)
+		case op.opts != nil:
+			w.RegisterWithOptions(op.node, *op.opts)
+		default:
+			w.Register(op.node)
+		}
+		if _, fresh := w.fresh[op.node]; !registered && fresh && tx.existing != nil {
+			w.batchNotify[op.node] = *tx.existing
+		}
+	}
+}
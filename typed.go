@@ -0,0 +1,50 @@
+package watch
+
+import "errors"
+
+// TypedWatcher is a Watcher whose nodes all have the concrete type T, so
+// that registered nodes and the nodes that errors are attributed to can be
+// enumerated without type assertions. The embedded Watcher provides Scan,
+// Run, configuration and the other methods. The zero value is ready to use.
+type TypedWatcher[T Node] struct {
+	Watcher
+}
+
+// Register registers node like Watcher.Register.
+func (tw *TypedWatcher[T]) Register(node T) error {
+	return tw.Watcher.Register(node)
+}
+
+// RegisterWithOptions registers node like Watcher.RegisterWithOptions.
+func (tw *TypedWatcher[T]) RegisterWithOptions(node T, opts NodeOptions) error {
+	return tw.Watcher.RegisterWithOptions(node, opts)
+}
+
+// Unregister unregisters node like Watcher.Unregister.
+func (tw *TypedWatcher[T]) Unregister(node T) {
+	tw.Watcher.Unregister(node)
+}
+
+// Nodes returns the registered nodes in registration order.
+func (tw *TypedWatcher[T]) Nodes() []T {
+	var nodes []T
+	for _, node := range tw.sorted() {
+		if n, ok := node.(T); ok {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// NodeOf returns the node an error returned by Scan or UpdateAll is
+// attributed to, as recorded by the *NodeError in its chain.
+func (tw *TypedWatcher[T]) NodeOf(err error) (T, bool) {
+	var ne *NodeError
+	if errors.As(err, &ne) {
+		if n, ok := ne.Node.(T); ok {
+			return n, true
+		}
+	}
+	var zero T
+	return zero, false
+}
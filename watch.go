@@ -3,8 +3,16 @@
 package watch
 
 import (
+	"cmp"
+	"context"
+	"hash"
 	"io/fs"
-	"os"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Node is an interface for a set of files that should be watched. A Node is
@@ -26,25 +34,293 @@ type Node interface {
 // reference have been updated. Nodes are registered via Register and
 // unregistered via Unregister. The zero-value of Watcher is ready to
 // use. Scan is used to check for file updates and calls Updated
-// synchronously on all registerd nodes with updates.
+// synchronously on all registerd nodes with updates, in a deterministic
+// order: after their dependencies, then as ordered by Compare, and then in
+// registration order. Updated may be called from multiple goroutines if
+// Concurrency is greater than one.
 type Watcher struct {
-	FS          fs.FS
+	// FS is the file system watched paths refer to. If nil, or if it does
+	// not implement fs.StatFS and Strict is not set, the operating system's
+	// file system is used.
+	FS fs.FS
+
+	// Strict makes the Watcher perform all file operations through FS,
+	// never falling back to the operating system's file system. Scan
+	// returns ErrNotStatFS if FS does not implement fs.StatFS.
+	Strict bool
+
+	// Detect selects how the Watcher decides that a file has changed. The
+	// zero value, DetectModTimeSize, compares modification times and sizes.
+	Detect Detection
+
+	// Detector, if not nil, decides whether files have changed in place of
+	// the StatDetector or HashDetector selected by Detect and
+	// NodeOptions.Detect, for detection strategies of its own. Detect still
+	// selects whether a change in size alone calls it.
+	Detector Detector
+
+	// NewHash returns the hash used to digest file contents when Detect is
+	// DetectHash. If nil, 64-bit FNV-1a is used.
+	NewHash func() hash.Hash
+
+	// NotifyExisting makes the first Scan after a node is registered notify
+	// the node if any of its paths exist, as if they had just been created,
+	// so that nodes can build their initial state from Updated. If false,
+	// only changes made after that Scan are reported: a path that does not
+	// exist at that Scan is reported with a Create event by the first later
+	// Scan that finds it, but one created before that Scan is not. An
+	// ExistingNotifier decides for itself.
+	NotifyExisting bool
+
+	// Symlinks selects how watched paths that are symbolic links are
+	// handled. The zero value follows links.
+	Symlinks SymlinkMode
+
+	// ListDirs makes Scan read the entries of every watched directory and
+	// report the directory as changed when an entry is added or removed,
+	// even on platforms and file systems that do not update the
+	// modification time of directories reliably. Nodes see a Create or
+	// Remove event for each such entry. Entries excluded by Ignore are not
+	// listed.
+	ListDirs bool
+
+	// ListSubtrees makes a directory added to a directory listed because of
+	// ListDirs be reported together with everything beneath it: the node
+	// watching the listed directory sees a Create event for each path of
+	// the new subtree, such as the intermediate directories and file of
+	// assets/new/deep/file.png, in the same notification. Paths excluded by
+	// Ignore are left out.
+	ListSubtrees bool
+
+	// DetectMode makes Scan also compare the mode of watched paths, so that
+	// a change of permissions alone, which need not touch the contents or
+	// the modification time, is reported as a Chmod event. If the contents
+	// changed too, the change is reported as a Write.
+	DetectMode bool
+
+	// RemoveGrace, if not zero, delays reporting the removal of a watched
+	// path until it has been missing for RemoveGrace, so that files that
+	// compilers and bundlers delete and immediately recreate are not
+	// reported as removed and then created. A path that reappears within
+	// the grace period is reported as a single Write if it changed, and not
+	// at all otherwise; one that does not is reported as removed by the
+	// first Scan after the grace period has elapsed. Until then, Info
+	// reports the path as it was before it went missing. A rename between
+	// two watched paths is reported as a Create of the new path, and later
+	// a Remove of the old one.
+	RemoveGrace time.Duration
+
+	// PathNormalizer, if not nil, maps every path returned by Node.Paths,
+	// Producer.Outputs and passed to ScanPaths to the path that is watched,
+	// so that different spellings of the same file are statted once and
+	// notify every node referencing them. The normalized paths are the ones
+	// statted and reported by ChangedPaths, Events and Peek. CleanPath and
+	// FoldCase are normalizers for case-sensitive and case-insensitive file
+	// systems.
+	PathNormalizer func(path string) string
+
+	// StatCache, if not nil, shares stat results with other Watchers using
+	// the same cache. If nil, the StatCache of the Watcher's Group is used,
+	// if any.
+	StatCache *StatCache
+
+	// Retry configures retries of failing stats and how many consecutive
+	// scans a path must fail before its error is reported.
+	Retry RetryPolicy
+
+	// UpdateRetry configures retries of nodes whose update returned an
+	// error, on later scans in which none of their paths changed.
+	UpdateRetry UpdateRetryPolicy
+
+	// Hooks are called around scans and notifications.
+	Hooks Hooks
+
+	// Journal, if not nil, records every event detected by a scan.
+	Journal *Journal
+
+	// UpdateAllAbsorbs makes UpdateAll first record the current state of
+	// the watched paths, as Scan does but without notifying any node or
+	// recording events in the Journal, and drop the changes waiting for
+	// Debounce, RateLimit, Resume or UpdateRetry. The next Scan then does
+	// not notify nodes again for changes the forced update already covered,
+	// such as those made before a refresh at startup. Stat errors are
+	// returned by UpdateAll.
+	UpdateAllAbsorbs bool
+
+	// MaxPaths, if not zero, caps the number of distinct paths tracked, so
+	// that a bad glob or recursive watch cannot grow the path table without
+	// bound. A Scan that finds more tracks the first MaxPaths paths, in the
+	// order their nodes were registered, drops the rest, and returns a
+	// *PathLimitError listing the nodes that reference the most paths.
+	MaxPaths int
+
+	// OnPathLimit, if not nil, is called with the error of every Scan that
+	// finds more paths than MaxPaths.
+	OnPathLimit func(err *PathLimitError)
+
+	// Filter, if not nil, is called with the path and kind of every event
+	// detected by a scan, such as each entry added to a directory listed by
+	// ListDirs, and drops the event if it returns false. A node is only
+	// notified of the events its filter keeps, so noise such as editor swap
+	// files never reaches debouncing or Updated; DefaultFilter drops the
+	// common kinds. For a Rename, the new path is passed. Unlike Ignore, the
+	// paths are still checked, and the Journal still records every event.
+	// NodeOptions.Filter overrides it per node.
+	Filter func(path string, op Op) bool
+
+	// Debounce is the quiet period a node's paths must remain unchanged
+	// before the node is notified. Changes detected during the period are
+	// coalesced into a single call to Updated on the first Scan after it
+	// elapses. If zero, nodes are notified on the Scan that detects a change.
+	Debounce time.Duration
+
+	// RateLimit is the minimum time between two notifications of a node,
+	// for nodes whose paths are rewritten constantly. Changes detected
+	// sooner are held and coalesced into a single call to Updated on the
+	// first Scan after the limit has passed. If zero, notifications are not
+	// limited.
+	RateLimit time.Duration
+
+	// Clock is the source of time used for Debounce and Run. If nil, the
+	// system clock is used.
+	Clock Clock
+
+	// Adaptive lets Run lengthen its polling interval while nothing changes
+	// and shorten it again as soon as something does. If its Max is zero,
+	// Run polls at a fixed interval.
+	Adaptive AdaptiveInterval
+
+	// Compare, if not nil, orders nodes that are ready to be notified at the
+	// same time, like the comparison function of slices.SortFunc. Nodes are
+	// always notified after the nodes they depend on, and nodes that compare
+	// equal are notified in registration order. If nil, nodes implementing
+	// Prioritizer are notified first, in decreasing order of priority.
+	Compare func(a, b Node) int
+
+	// Metrics, if not nil, is called at the end of every scan with its
+	// statistics.
+	Metrics Metrics
+
+	// ErrorHandler, if not nil, is called from Scan with each error
+	// encountered while statting or reading a watched path. The errors are
+	// also returned from Scan as *StatError values.
+	ErrorHandler func(path string, err error)
+
+	// Concurrency is the maximum number of nodes notified in parallel. Nodes
+	// are still notified after the nodes they depend on. If zero or one,
+	// nodes are notified one at a time.
+	Concurrency int
+
+	// StatConcurrency is the number of goroutines used to stat and read
+	// watched paths during Scan. If zero or one, paths are statted one at
+	// a time. FS must be safe for concurrent use if it is greater than one.
+	// It helps when each stat waits on a slow device or a network; on a
+	// local disk stats are cheap and it gains little.
+	StatConcurrency int
+
 	initialized bool
-	nodes       map[Node]struct{}
-	paths       map[string]*pathStat
+	nodes       map[Node]uint64 // registration sequence numbers
+	registered  uint64
+	paths       map[pathKey]*pathStat
+	dirs        dirTable          // the directories of the keys of paths
+	spare       *scan             // the buffers of the last scan, for reuse
+	updated     map[Node]struct{} // the nodes to notify, reused by process
+	roots       []fs.FS           // file systems of nodes registered with RegisterFS
+	nodeRoot    map[Node]int      // see rootFS
+	fresh       map[Node]struct{} // registered since the last Scan
+	batchNotify map[Node]bool     // NotifyExisting overridden by Batch
+	options     map[Node]NodeOptions
+	checked     map[Node]time.Time // last check of nodes with an Interval
+	produced    map[Node]map[string]fs.FileInfo
+	pending     map[Node]time.Time
+	held        map[Node]struct{}
+	lastUpdate  map[Node]time.Time     // for RateLimit
+	failed      map[Node]updateFailure // for UpdateRetry
+	changes     map[Node]map[string]struct{}
+	notified    map[Node][]string
+	queued      map[Node][]Event // events awaiting notification
+	events      map[Node][]Event // events of the last notification
+	paused      atomic.Bool
+	profile     atomic.Pointer[profiler] // set while profiling
+	lastProfile atomic.Pointer[profiler]
+	deps        map[Node]map[Node]struct{}
+	ignore      []ignoreRule
+	statsMu     sync.Mutex
+	stats       Stats
+	busy        sync.Mutex // held while scanning and notifying
+	closed      atomic.Bool
+	doneOnce    sync.Once
+	done        chan struct{}
+	group       *Group
+	parent      *Watcher   // the Watcher of a Scope
+	prefix      []string   // the segments of the prefix of a Scope
+	scopesMu    sync.Mutex // guards scopes
+	scopes      []*Watcher
+	laterMu     sync.Mutex // guards later
+	later       []func(tx *Tx)
+	cycle       atomic.Pointer[StatCache] // the StatCache of the current scan cycle
 }
 
+// pathStat is the recorded state of a watched path. The state that most
+// paths do not have is kept separately, to keep the table small.
 type pathStat struct {
-	info    fs.FileInfo
-	visited bool
-	updated bool
-	nodes   map[Node]struct{}
+	info     *fileStat
+	more     *pathExtra // nil if all its fields are zero
+	failures int        // consecutive scans that failed to stat the path
+	nodes    []Node
+}
+
+// pathExtra is the state recorded for paths that are hashed, are symbolic
+// links or are listed directories.
+type pathExtra struct {
+	sum     []byte
+	link    string
+	target  *fileStat
+	entries []string  // the listing of a directory, if ListDirs is set
+	missing time.Time // when the path went missing, within RemoveGrace
+}
+
+// extra returns the extra state of the path, which is zero if ps is nil.
+func (ps *pathStat) extra() pathExtra {
+	if ps == nil || ps.more == nil {
+		return pathExtra{}
+	}
+	return *ps.more
+}
+
+// setExtra records the extra state of the path.
+func (ps *pathStat) setExtra(x pathExtra) {
+	if x.sum == nil && x.link == "" && x.target == nil && x.entries == nil && x.missing.IsZero() {
+		ps.more = nil
+		return
+	}
+	more := x
+	ps.more = &more
 }
 
 func (w *Watcher) init() {
 	w.initialized = true
-	w.nodes = make(map[Node]struct{})
-	w.paths = make(map[string]*pathStat)
+	w.nodes = make(map[Node]uint64)
+	w.paths = make(map[pathKey]*pathStat)
+	w.dirs = dirTable{}
+	w.spare = nil
+	w.updated = make(map[Node]struct{})
+	w.roots = nil
+	w.nodeRoot = make(map[Node]int)
+	w.fresh = make(map[Node]struct{})
+	w.batchNotify = make(map[Node]bool)
+	w.options = make(map[Node]NodeOptions)
+	w.checked = make(map[Node]time.Time)
+	w.produced = make(map[Node]map[string]fs.FileInfo)
+	w.pending = make(map[Node]time.Time)
+	w.held = make(map[Node]struct{})
+	w.lastUpdate = make(map[Node]time.Time)
+	w.failed = make(map[Node]updateFailure)
+	w.changes = make(map[Node]map[string]struct{})
+	w.notified = make(map[Node][]string)
+	w.queued = make(map[Node][]Event)
+	w.events = make(map[Node][]Event)
+	w.deps = make(map[Node]map[Node]struct{})
 }
 
 // Empty returns true if the watcher is not observing any nodes.
@@ -52,15 +328,84 @@ func (w *Watcher) Empty() bool {
 	return len(w.nodes) == 0
 }
 
-// Register registers a node to be observed on sucessive calls to Scan.
-func (w *Watcher) Register(node Node) {
+// Nodes returns the registered nodes in registration order.
+func (w *Watcher) Nodes() []Node {
+	return w.sorted()
+}
+
+// Paths returns the sorted paths watched by the last scan, after
+// normalization by PathNormalizer and excluding ignored paths. A path watched
+// in several file systems is listed once.
+func (w *Watcher) Paths() []string {
+	paths := make([]string, 0, len(w.paths))
+	for key := range w.paths {
+		paths = append(paths, w.path(key))
+	}
+	slices.Sort(paths)
+	return slices.Compact(paths)
+}
+
+// NodesForPath returns the registered nodes that referenced path during the
+// last scan, in any file system, in registration order.
+func (w *Watcher) NodesForPath(path string) []Node {
+	path = w.normalize(path)
+	var nodes []Node
+	for root := range len(w.roots) + 1 {
+		key, ok := w.lookup(root, path)
+		stat := w.paths[key]
+		if !ok || stat == nil {
+			continue
+		}
+		for _, node := range stat.nodes {
+			if _, ok := w.nodes[node]; ok && !slices.Contains(nodes, node) {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	slices.SortFunc(nodes, func(a, b Node) int {
+		return cmp.Compare(w.nodes[a], w.nodes[b])
+	})
+	return nodes
+}
+
+// Info returns the file information of path recorded by the last scan, so
+// that nodes and tools can compare it with the state of other files, such as
+// the outputs built from path, without statting it again. It reports false
+// if path was not watched by the last scan, or did not exist. A path watched
+// in several file systems is reported from the first one in which it exists,
+// the Watcher's own FS first. The modification time, size and mode are those
+// the Watcher compares, so with SymlinkLink they describe the link itself;
+// Sys returns nil. Like NodesForPath, Info may be called from Updated.
+func (w *Watcher) Info(path string) (fs.FileInfo, bool) {
+	path = w.normalize(path)
+	for root := range len(w.roots) + 1 {
+		key, ok := w.lookup(root, path)
+		stat := w.paths[key]
+		if !ok || stat == nil || stat.info == nil {
+			continue
+		}
+		return &namedStat{stat.info, filepath.Base(path)}, true
+	}
+	return nil, false
+}
+
+// Register registers a node to be observed on sucessive calls to Scan. It
+// returns ErrClosed if the Watcher has been closed.
+func (w *Watcher) Register(node Node) error {
+	if w.closed.Load() {
+		return ErrClosed
+	}
 	if !w.initialized {
 		w.init()
 	}
 	if _, ok := w.nodes[node]; ok {
-		return
+		return nil
 	}
-	w.nodes[node] = struct{}{}
+	w.registered++
+	w.nodes[node] = w.registered
+	w.fresh[node] = struct{}{}
+	w.attached(node)
+	return nil
 }
 
 // Unregister unregisters a node from being observed on sucessive calls to Scan.
@@ -68,89 +413,493 @@ func (w *Watcher) Unregister(node Node) {
 	if !w.initialized {
 		w.init()
 	}
+	_, registered := w.nodes[node]
 	delete(w.nodes, node)
+	delete(w.fresh, node)
+	delete(w.batchNotify, node)
+	delete(w.options, node)
+	delete(w.checked, node)
+	delete(w.produced, node)
+	delete(w.pending, node)
+	delete(w.held, node)
+	delete(w.lastUpdate, node)
+	delete(w.failed, node)
+	delete(w.nodeRoot, node)
+	delete(w.changes, node)
+	delete(w.notified, node)
+	delete(w.queued, node)
+	delete(w.events, node)
+	w.removeNode(node)
+	if registered {
+		detached(node)
+	}
 }
 
-// UpdateAll calls Updated on all registered nodes. Does not modify the files,
-// so Scan may still trigger changes.
-func (w *Watcher) UpdateAll() []error {
-	var errors []error
-	for node := range w.nodes {
-		if err := node.Updated(); err != nil {
-			errors = append(errors, err)
+// UpdateAll calls Updated on all registered nodes in dependency order, using
+// up to Concurrency goroutines. Does not modify the files, so Scan may still
+// trigger changes, unless UpdateAllAbsorbs is set.
+func (w *Watcher) UpdateAll() ScanErrors {
+	if err := w.begin(); err != nil {
+		return []error{err}
+	}
+	defer w.busy.Unlock()
+	var (
+		mu     sync.Mutex
+		errors []error
+	)
+	if w.UpdateAllAbsorbs {
+		if err := w.checkFS(); err != nil {
+			return []error{err}
 		}
+		errors = w.absorbChanges()
 	}
+	for _, level := range w.levels(w.order(w.all())) {
+		w.dispatch(level, func(node Node) {
+			if !w.Hooks.beforeUpdate(node, nil) {
+				return
+			}
+			err := w.timeUpdate(node, func() error { return update(context.Background(), node, nil, ReasonForced) })
+			w.Hooks.afterUpdate(node, nil, err)
+			if err != nil {
+				mu.Lock()
+				errors = append(errors, err)
+				mu.Unlock()
+			}
+		})
+	}
+	w.countNodeErrors(errors)
+	w.absorb(w.sorted())
+	w.applyLater()
 	return errors
 }
 
+// UpdateNode is like UpdateAll for a single registered node, such as one a
+// user asks to rebuild. Since it holds the same lock as Scan, the update
+// does not overlap other updates of node. UpdateAllAbsorbs does not apply.
+// UpdateNode does nothing if node is not registered.
+func (w *Watcher) UpdateNode(node Node) ScanErrors {
+	if err := w.begin(); err != nil {
+		return []error{err}
+	}
+	defer w.busy.Unlock()
+	if _, ok := w.nodes[node]; !ok || !w.Hooks.beforeUpdate(node, nil) {
+		return nil
+	}
+	var errs ScanErrors
+	err := w.timeUpdate(node, func() error { return update(context.Background(), node, nil, ReasonForced) })
+	w.Hooks.afterUpdate(node, nil, err)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	w.countNodeErrors(errs)
+	w.absorb([]Node{node})
+	w.applyLater()
+	return errs
+}
+
+// absorbChanges records the current state of the watched paths without
+// notifying any node, and drops the changes waiting to be delivered, for
+// UpdateAllAbsorbs. It returns the errors of the scan.
+func (w *Watcher) absorbChanges() []error {
+	s := w.detect(context.Background(), false, w.clock().Now())
+	defer w.release(s)
+	w.commit(s)
+	clear(w.fresh)
+	clear(w.batchNotify)
+	clear(w.pending)
+	clear(w.held)
+	clear(w.changes)
+	clear(w.queued)
+	clear(w.failed)
+	errs := s.errors
+	if err := w.limitError(s); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
 // Scan synchronously calls Updated on each registered Node that references a path
 // where a file has been updated or created since the last call to Scan.
 // The first time Scan is called, Updated will not be called for existing
-// files.
-func (w *Watcher) Scan() (bool, []error) {
-	if !w.initialized {
-		w.init()
+// files unless NotifyExisting is set. If Debounce is set, notification is
+// deferred until the node's paths have been quiet for the debounce period.
+// Scan reports whether any node was notified, along with any *StatError
+// encountered while scanning followed by the errors returned by Updated,
+// each wrapped in a *NodeError, as ScanErrors.
+func (w *Watcher) Scan() (bool, ScanErrors) {
+	updated, _, errs := w.ScanContext(context.Background())
+	return updated, errs
+}
+
+// ScanContext is like Scan, but stops once ctx is done. If ctx is done while
+// paths are being statted, ScanContext stops waiting for them, even if a stat
+// call is blocked, and returns the paths it did not reach; their state is
+// left as it was so the next scan checks them again. Nodes are not notified
+// once ctx is done: the nodes not notified yet, including those affected by
+// changes in the paths that were reached, are notified by the next scan
+// instead. In either case ctx.Err() is included in the returned errors.
+func (w *Watcher) ScanContext(ctx context.Context) (updated bool, unreached []string, errs ScanErrors) {
+	r := w.ScanResult(ctx)
+	return r.Updated, r.Unreached, r.Errors
+}
+
+// ScanPaths is like Scan, but only checks the given paths, for callers that
+// know which files changed, such as from an editor's save notification. It
+// notifies the nodes that referenced the paths during the last Scan, so
+// paths that are not yet watched are ignored, and nodes registered since the
+// last Scan are not considered. Results for the paths cached by the
+// StatCache are not used. Debounced and held notifications that are due are
+// delivered as by Scan. Pollers are not polled.
+func (w *Watcher) ScanPaths(paths ...string) (bool, ScanErrors) {
+	r := w.scanPaths(paths)
+	for _, s := range w.Scopes() {
+		updated, errs := s.ScanPaths(paths...)
+		r.merge(ScanResult{Updated: updated, Errors: errs})
 	}
+	return r.Updated, r.Errors
+}
 
-	// reset all paths
-	for _, stat := range w.paths {
-		stat.visited = false
-		stat.updated = false
+// scanPaths is ScanPaths without the scopes of w.
+func (w *Watcher) scanPaths(paths []string) ScanResult {
+	if err := w.begin(); err != nil {
+		return ScanResult{Errors: []error{err}}
+	}
+	defer w.busy.Unlock()
+	start := w.clock().Now()
+	if err := w.checkFS(); err != nil {
+		return ScanResult{Errors: []error{err}}
+	}
+	if c := w.statCache(); c != nil {
+		normalized := make([]string, len(paths))
+		for i, p := range paths {
+			normalized[i] = w.normalize(p)
+		}
+		c.Forget(normalized...)
+	}
+	w.Hooks.beforeScan()
+	return w.process(context.Background(), w.detectPaths(context.Background(), paths, nil), start)
+}
+
+// ScanNode is like ScanPaths for the current paths of a registered node. The
+// other nodes referencing those paths are notified too.
+func (w *Watcher) ScanNode(node Node) (bool, ScanErrors) {
+	if err := w.begin(); err != nil {
+		return false, []error{err}
+	}
+	defer w.busy.Unlock()
+	if _, ok := w.nodes[node]; !ok {
+		return false, nil
+	}
+	start := w.clock().Now()
+	if err := w.checkFS(); err != nil {
+		return false, []error{err}
+	}
+	w.Hooks.beforeScan()
+	r := w.process(context.Background(), w.detectPaths(context.Background(), node.Paths(), node), start)
+	return r.Updated, r.Errors
+}
+
+// process commits s and notifies the nodes affected by the changes it
+// detected, subject to Debounce and Pause. start is the time the scan
+// started, for Metrics. Nodes not notified before ctx is done, or vetoed by
+// Hooks.BeforeUpdate, are held for the next scan.
+func (w *Watcher) process(ctx context.Context, s *scan, start time.Time) ScanResult {
+	defer w.release(s)
+	w.commit(s)
+	errors := s.errors
+	if w.ErrorHandler != nil {
+		for _, err := range errors {
+			err := err.(*StatError)
+			w.ErrorHandler(err.Path, err.Err)
+		}
+	}
+	if err := w.limitError(s); err != nil {
+		errors = append(errors, err)
+		if w.OnPathLimit != nil {
+			w.OnPathLimit(err)
+		}
 	}
 
-	// scan all paths and determine which have changed
-	for node := range w.nodes {
-		for _, path := range node.Paths() {
-			stat, pathExistedAlready := w.paths[path]
-			if stat == nil {
-				stat = new(pathStat)
-				w.paths[path] = stat
+	// collect updated nodes
+	now := w.clock().Now()
+	paused := w.paused.Load()
+	updatedNodes := w.updated
+	clear(updatedNodes)
+	ready := updatedNodes
+	if paused {
+		ready = w.held
+	}
+	mark := func(node Node) {
+		if w.debounce(node) > 0 {
+			w.pending[node] = now
+		} else {
+			ready[node] = struct{}{}
+		}
+	}
+	journaled := map[Event]uint64{}
+	for i := range s.entries {
+		e := &s.entries[i]
+		if !e.updated {
+			continue
+		}
+		events := e.events
+		if events == nil {
+			events = []Event{e.event()}
+		}
+		if w.Journal != nil {
+			if err := w.journal(events, journaled); err != nil {
+				errors = append(errors, err)
 			}
-			if stat.visited {
-				stat.nodes[node] = struct{}{}
+		}
+		for _, node := range e.nodes {
+			if w.ownChange(node, e) || w.verified(node, e) {
 				continue
 			}
-			stat.visited = true
-			stat.nodes = map[Node]struct{}{node: {}}
-			var info os.FileInfo
-			if fsys, ok := w.FS.(fs.StatFS); ok {
-				info, _ = fsys.Stat(path)
-			} else {
-				info, _ = os.Stat(path)
+			events := w.filterEvents(node, events)
+			if len(events) == 0 {
+				continue
 			}
-			if info != nil {
-				if stat.info != nil {
-					if !stat.info.ModTime().Equal(info.ModTime()) {
-						stat.updated = true
-					}
-				} else if pathExistedAlready {
-					stat.updated = true
-				}
-				stat.info = info
+			w.addChange(node, e.path)
+			for _, ev := range events {
+				w.addEvent(node, ev)
 			}
+			mark(node)
 		}
 	}
+	if !s.partial {
+		w.existing(s, func(node Node, path string) {
+			if f := w.filter(node); f != nil && !f(path, Create) {
+				return
+			}
+			w.addChange(node, path)
+			w.addEvent(node, Event{Op: Create, Path: path})
+			mark(node)
+		})
+		clear(w.fresh)
+		clear(w.batchNotify)
+	}
+	for _, node := range s.polled {
+		mark(node)
+	}
 
-	// delete unused paths and collect updated nodes
-	updatedNodes := map[Node]struct{}{}
-	for path, stat := range w.paths {
-		if !stat.visited {
-			delete(w.paths, path)
+	// collect debounced nodes whose quiet period has elapsed
+	for node, last := range w.pending {
+		if now.Sub(last) >= w.debounce(node) {
+			delete(w.pending, node)
+			ready[node] = struct{}{}
 		}
-		if stat.updated {
-			for node := range stat.nodes {
-				updatedNodes[node] = struct{}{}
-			}
+	}
+
+	// retry nodes whose last update failed
+	for node, f := range w.failed {
+		if !now.Before(f.next) {
+			ready[node] = struct{}{}
+		}
+	}
+
+	// deliver changes accumulated while paused
+	if !paused {
+		for node := range w.held {
+			updatedNodes[node] = struct{}{}
 		}
+		clear(w.held)
 	}
 
-	// notify nodes
-	var errors []error
+	// hold back nodes notified less than their rate limit ago
 	for node := range updatedNodes {
-		if err := node.Updated(); err != nil {
-			errors = append(errors, err)
+		if last, ok := w.lastUpdate[node]; ok && now.Sub(last) < w.rateLimit(node) {
+			delete(updatedNodes, node)
+			w.held[node] = struct{}{}
+		}
+	}
+
+	// record the changes that caused each notification
+	clear(w.notified)
+	clear(w.events)
+	for node := range updatedNodes {
+		w.notified[node] = slices.Sorted(maps.Keys(w.changes[node]))
+		w.events[node] = w.queued[node]
+		delete(w.changes, node)
+		delete(w.queued, node)
+	}
+
+	// notify nodes and their dependents
+	notified, skipped, errs := w.notify(ctx, updatedNodes)
+	errors = append(errors, errs...)
+	w.countNodeErrors(errs)
+	w.retryFailed(notified, errs, now)
+	w.absorb(notified)
+	for _, node := range notified {
+		if w.rateLimit(node) > 0 {
+			w.lastUpdate[node] = now
+		}
+	}
+	for _, node := range skipped {
+		for _, path := range w.notified[node] {
+			w.addChange(node, path)
+		}
+		w.queued[node] = w.events[node]
+		delete(w.notified, node)
+		delete(w.events, node)
+		w.held[node] = struct{}{}
+	}
+	w.applyLater()
+	if s.ctxErr == nil && len(skipped) > 0 {
+		s.ctxErr = ctx.Err()
+	}
+	if s.ctxErr != nil {
+		errors = append(errors, s.ctxErr)
+	}
+
+	stats := w.record(s, start, len(notified), len(errors))
+	return s.result(len(updatedNodes) > 0, notified, errors, stats)
+}
+
+// ScanErr is like Scan, but returns the errors as a single error, the
+// ScanErrors of the scan, or nil if there were none.
+func (w *Watcher) ScanErr() (bool, error) {
+	updated, errs := w.Scan()
+	return updated, errs.Err()
+}
+
+// ChangedPaths returns the sorted paths of node whose changes caused it to be
+// notified by the most recent call to Scan, including changes coalesced by
+// Debounce or accumulated while paused. It may be called from Updated to
+// rebuild incrementally. It returns nil if node was not notified by the most
+// recent Scan, or was only notified because one of its dependencies was.
+func (w *Watcher) ChangedPaths(node Node) []string {
+	return w.notified[node]
+}
+
+// addChange records that path changed for a node awaiting notification.
+func (w *Watcher) addChange(node Node, path string) {
+	paths := w.changes[node]
+	if paths == nil {
+		paths = make(map[string]struct{})
+		w.changes[node] = paths
+	}
+	paths[path] = struct{}{}
+}
+
+// Peek detects changes like Scan, but neither calls Updated nor records the
+// detected state, so the changes are still reported by the next Scan. It
+// returns the sorted changed paths and the nodes that Scan would notify for
+// them, including their dependents, in notification order. Debounce and
+// Pause are not taken into account, ErrorHandler is not called, and Pollers
+// are not polled.
+func (w *Watcher) Peek() ([]string, []Node, ScanErrors) {
+	if err := w.begin(); err != nil {
+		return nil, nil, []error{err}
+	}
+	defer w.busy.Unlock()
+	if err := w.checkFS(); err != nil {
+		return nil, nil, []error{err}
+	}
+	s := w.detect(context.Background(), false, w.clock().Now())
+	defer w.release(s)
+	var paths []string
+	updated := map[Node]struct{}{}
+	for i := range s.entries {
+		e := &s.entries[i]
+		if !e.updated {
+			continue
+		}
+		events := e.events
+		if events == nil {
+			events = []Event{e.event()}
+		}
+		filtered := true // every node's filter dropped the change
+		for _, node := range e.nodes {
+			if len(w.filterEvents(node, events)) == 0 {
+				continue
+			}
+			filtered = false
+			if !w.ownChange(node, e) && !w.verified(node, e) {
+				updated[node] = struct{}{}
+			}
+		}
+		if !filtered {
+			paths = append(paths, e.path)
+		}
+	}
+	w.existing(s, func(node Node, path string) {
+		if f := w.filter(node); f != nil && !f(path, Create) {
+			return
 		}
+		paths = append(paths, path)
+		updated[node] = struct{}{}
+	})
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+	var nodes []Node
+	if len(updated) > 0 {
+		nodes = w.order(updated)
 	}
+	errs := s.errors
+	if err := w.limitError(s); err != nil {
+		errs = append(errs, err)
+	}
+	return paths, nodes, errs
+}
+
+// existing calls fn for each path in s that exists and belongs to a node
+// registered since the last Scan, if NotifyExisting is set or the node is an
+// ExistingNotifier asking for it.
+func (w *Watcher) existing(s *scan, fn func(node Node, path string)) {
+	if len(w.fresh) == 0 {
+		return
+	}
+	for _, e := range s.entries {
+		if e.info == nil {
+			continue
+		}
+		for _, node := range e.nodes {
+			if _, ok := w.fresh[node]; ok && w.notifyExisting(node) {
+				fn(node, e.path)
+			}
+		}
+	}
+}
 
-	return len(updatedNodes) > 0, errors
+// Pause suspends notifications. Scan continues to detect changes while the
+// Watcher is paused, but the affected nodes are only notified, in a single
+// batch, by the first Scan after Resume. Pause and Resume may be called
+// concurrently with Scan.
+func (w *Watcher) Pause() {
+	w.paused.Store(true)
+}
+
+// Resume resumes notifications suspended by Pause.
+func (w *Watcher) Resume() {
+	w.paused.Store(false)
+}
+
+// Paused reports whether notifications are suspended by Pause.
+func (w *Watcher) Paused() bool {
+	return w.paused.Load()
+}
+
+// changed reports whether the file at path in fsys has changed since stat was
+// recorded, given its current info, and returns the fingerprint to record.
+// A change in file identity, or in size unless detect is DetectModTime,
+// counts as a modification even if the modification time is equal. Unless
+// the Detector is a StatDetector, the fingerprint is recomputed when the file
+// was modified, and any error computing it is returned.
+func (w *Watcher) changed(fsys fs.FS, path string, detect Detection, stat *pathStat, info fs.FileInfo) (bool, []byte, error) {
+	d := w.detector(detect)
+	sd, isStat := d.(StatDetector)
+	if !isStat {
+		sd = StatDetector{IgnoreSize: detect == DetectModTime}
+	}
+	modified := sd.modified(stat.info, info)
+	prev := stat.extra().sum
+	if isStat || !modified && prev != nil {
+		return modified, prev, nil
+	}
+	cur, err := fingerprint(d, fsys, path, info)
+	if err != nil || prev == nil {
+		// without both fingerprints, fall back to the stat
+		return modified, cur, err
+	}
+	return d.Changed(prev, cur), cur, nil
 }
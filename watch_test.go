@@ -1,12 +1,28 @@
 package watch_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
 	"os"
-	"path"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchtest"
 )
 
 type testNode struct {
@@ -27,102 +43,3342 @@ func (tn *testNode) Updated() error {
 }
 
 func TestWatcher(t *testing.T) {
-	wd := os.TempDir()
-	defer os.RemoveAll(wd)
-
 	t.Run("doesn't notify existing file", func(t *testing.T) {
-		w := new(watch.Watcher)
-		p := path.Join(wd, "single_file.txt")
-		defer os.Remove(p)
-		n := testNode{path: p}
-		w.Register(&n)
-		os.Create(p)
+		fsys := new(watchtest.FS)
+		w := &watch.Watcher{FS: fsys}
+		n := watchtest.NewNode("single_file.txt")
+		w.Register(n)
+		fsys.Touch("single_file.txt")
 		w.Scan()
-		if n.updated != 0 {
-			t.Errorf("updated should be 0")
-		}
+		watchtest.ExpectUpdated(t, n, 0)
 	})
 
 	t.Run("watches single file update", func(t *testing.T) {
-		w := new(watch.Watcher)
-		p := path.Join(wd, "single_file.txt")
-		defer os.Remove(p)
-		n := testNode{path: p}
-		w.Register(&n)
+		fsys := new(watchtest.FS)
+		w := &watch.Watcher{FS: fsys}
+		n := watchtest.NewNode("single_file.txt")
+		w.Register(n)
+		w.Scan()
+		watchtest.ExpectUpdated(t, n, 0)
+
+		fsys.Touch("single_file.txt")
+		w.Scan()
+		watchtest.ExpectUpdated(t, n, 1)
+
+		fsys.Advance(time.Second)
+		fsys.Touch("single_file.txt")
+		w.Scan()
+		watchtest.ExpectUpdated(t, n, 2)
+	})
+
+	t.Run("register and unregister node", func(t *testing.T) {
+		fsys := new(watchtest.FS)
+		w := &watch.Watcher{FS: fsys}
+		n := watchtest.NewNode("single_file.txt")
+		w.Register(n)
+		w.Scan()
+		fsys.Touch("single_file.txt")
+		w.Scan()
+		w.Unregister(n)
+		fsys.Advance(time.Second)
+		fsys.Touch("single_file.txt")
+		w.Scan()
+		watchtest.ExpectUpdated(t, n, 1)
+	})
+
+	t.Run("watches single file with dependency update", func(t *testing.T) {
+		fsys := new(watchtest.FS)
+		w := &watch.Watcher{FS: fsys}
+		n := watchtest.NewNode("dep_file.txt", "dep_file_2.txt", "main_file.txt")
+
+		fsys.Touch("main_file.txt")
+		w.Register(n)
+		w.Scan()
+		watchtest.ExpectUpdated(t, n, 0)
+
+		fsys.Touch("dep_file.txt")
+		w.Scan()
+		watchtest.ExpectUpdated(t, n, 1)
+
+		fsys.Touch("dep_file_2.txt")
+		w.Scan()
+		watchtest.ExpectUpdated(t, n, 2)
+
+		fsys.Advance(time.Second)
+		fsys.Touch("dep_file.txt")
+		w.Scan()
+		watchtest.ExpectUpdated(t, n, 3)
+
+		fsys.Advance(time.Second)
+		fsys.Touch("dep_file_2.txt")
+		w.Scan()
+		watchtest.ExpectUpdated(t, n, 4)
+	})
+}
+
+func TestDetectHash(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("a"), ModTime: t0}}
+	w := &watch.Watcher{FS: fsys, Detect: watch.DetectHash}
+	n := testNode{path: "a.txt"}
+	w.Register(&n)
+	w.Scan()
+
+	t.Run("ignores touch", func(t *testing.T) {
+		fsys["a.txt"] = &fstest.MapFile{Data: []byte("a"), ModTime: t0.Add(time.Second)}
 		w.Scan()
 		if n.updated != 0 {
 			t.Errorf("updated should be 0")
 		}
+	})
 
-		os.Create(p)
+	t.Run("notifies content change", func(t *testing.T) {
+		fsys["a.txt"] = &fstest.MapFile{Data: []byte("b"), ModTime: t0.Add(2 * time.Second)}
 		w.Scan()
 		if n.updated != 1 {
 			t.Errorf("updated should be 1")
 		}
+	})
+}
 
-		os.Chtimes(p, time.Now(), time.Now())
+func TestDetectSize(t *testing.T) {
+	t0 := time.Now().Truncate(2 * time.Second)
+	for _, tt := range []struct {
+		detect watch.Detection
+		want   int
+	}{
+		{watch.DetectModTimeSize, 1},
+		{watch.DetectModTime, 0},
+	} {
+		// a second write within the modification time granularity
+		fsys := fstest.MapFS{"a.txt": {Data: []byte("a"), ModTime: t0}}
+		w := &watch.Watcher{FS: fsys, Detect: tt.detect}
+		n := testNode{path: "a.txt"}
+		w.Register(&n)
 		w.Scan()
-		if n.updated != 2 {
-			t.Errorf("updated should be 2")
+		fsys["a.txt"] = &fstest.MapFile{Data: []byte("ab"), ModTime: t0}
+		w.Scan()
+		if n.updated != tt.want {
+			t.Errorf("detection %d should update the node %d times, got %d", tt.detect, tt.want, n.updated)
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	clock := new(watchtest.Clock)
+	fsys := &watchtest.FS{Clock: clock}
+	fsys.WriteFile("a.txt", nil)
+	w := &watch.Watcher{FS: fsys, Debounce: 20 * time.Millisecond, Clock: clock}
+	n := testNode{path: "a.txt"}
+	w.Register(&n)
+	w.Scan()
+
+	for range 3 {
+		clock.Advance(10 * time.Millisecond)
+		fsys.WriteFile("a.txt", nil)
+		w.Scan()
+	}
+	if n.updated != 0 {
+		t.Errorf("updated should be 0 during burst")
+	}
+
+	clock.Advance(10 * time.Millisecond)
+	w.Scan()
+	if n.updated != 0 {
+		t.Errorf("updated should be 0 before quiet period elapses")
+	}
+	clock.Advance(10 * time.Millisecond)
+	w.Scan()
+	if n.updated != 1 {
+		t.Errorf("updated should be 1 after quiet period")
+	}
+	w.Scan()
+	if n.updated != 1 {
+		t.Errorf("updated should still be 1")
+	}
+}
+
+func TestRun(t *testing.T) {
+	clock := new(watchtest.Clock)
+	fsys := &watchtest.FS{Clock: clock}
+	fsys.WriteFile("a.txt", nil)
+	w := &watch.Watcher{FS: fsys, Clock: clock}
+	n := testNode{path: "a.txt"}
+	w.Register(&n)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scans := make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx, time.Second, func(updated bool, errs watch.ScanErrors) {
+			if len(errs) > 0 {
+				t.Error(errs)
+			}
+			scans <- updated
+		})
+	}()
+	if <-scans {
+		t.Errorf("first scan should not report an update")
+	}
+	fsys.WriteFile("a.txt", []byte("a"))
+	clock.Advance(time.Second)
+	if !<-scans {
+		t.Errorf("scan after tick should report an update")
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run should return context.Canceled, got %v", err)
+	}
+}
+
+type orderNode struct {
+	testNode
+	name  string
+	order *[]string
+}
+
+func (on *orderNode) Updated() error {
+	*on.order = append(*on.order, on.name)
+	return on.testNode.Updated()
+}
+
+func TestDependencies(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"a.txt": {ModTime: t0}, "b.txt": {ModTime: t0}}
+	w := &watch.Watcher{FS: fsys}
+	var order []string
+	a := &orderNode{testNode: testNode{path: "a.txt"}, name: "a", order: &order}
+	b := &orderNode{testNode: testNode{path: "b.txt"}, name: "b", order: &order}
+	c := &orderNode{testNode: testNode{path: "c.txt"}, name: "c", order: &order}
+	w.Register(c)
+	w.Register(b)
+	w.Register(a)
+	if err := w.AddDependency(c, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddDependency(b, a); err != nil {
+		t.Fatal(err)
+	}
+	w.Scan()
+
+	t.Run("propagates in dependency order", func(t *testing.T) {
+		fsys["a.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+		w.Scan()
+		if got := strings.Join(order, ","); got != "a,b,c" {
+			t.Errorf("order should be a,b,c, got %s", got)
 		}
 	})
 
-	t.Run("register and unregister node", func(t *testing.T) {
+	t.Run("rejects cycles", func(t *testing.T) {
+		if err := w.AddDependency(a, c); !errors.Is(err, watch.ErrCycle) {
+			t.Errorf("expected ErrCycle, got %v", err)
+		}
+		if err := w.AddDependency(a, a); !errors.Is(err, watch.ErrCycle) {
+			t.Errorf("expected ErrCycle, got %v", err)
+		}
+	})
+}
+
+// failFS is a StatFS that fails to stat the paths in fail.
+type failFS struct {
+	fstest.MapFS
+	fail map[string]error
+}
+
+func (f failFS) Stat(name string) (fs.FileInfo, error) {
+	if err := f.fail[name]; err != nil {
+		return nil, err
+	}
+	return f.MapFS.Stat(name)
+}
+
+func TestStatErrors(t *testing.T) {
+	errDenied := errors.New("denied")
+	fsys := failFS{
+		MapFS: fstest.MapFS{"a.txt": {}},
+		fail:  map[string]error{"b.txt": errDenied},
+	}
+	var handled []string
+	w := &watch.Watcher{
+		FS:           fsys,
+		ErrorHandler: func(path string, err error) { handled = append(handled, path) },
+	}
+	n := testNode{path: "a.txt", deps: []string{"b.txt", "missing.txt"}}
+	w.Register(&n)
+	_, errs := w.Scan()
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	var statErr *watch.StatError
+	if !errors.As(errs[0], &statErr) || statErr.Path != "b.txt" || !errors.Is(errs[0], errDenied) {
+		t.Errorf("expected StatError for b.txt, got %v", errs[0])
+	}
+	if len(handled) != 1 || handled[0] != "b.txt" {
+		t.Errorf("ErrorHandler should be called for b.txt, got %v", handled)
+	}
+}
+
+// barrierNode blocks in Updated until all nodes sharing its barrier are
+// being updated at the same time.
+type barrierNode struct {
+	path    string
+	barrier *sync.WaitGroup
+}
+
+func (bn *barrierNode) Paths() []string { return []string{bn.path} }
+
+func (bn *barrierNode) Updated() error {
+	bn.barrier.Done()
+	done := make(chan struct{})
+	go func() {
+		bn.barrier.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(time.Second):
+		return errors.New("nodes were not updated concurrently")
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{}
+	w := &watch.Watcher{FS: fsys, Concurrency: 3, StatConcurrency: 3}
+	var barrier sync.WaitGroup
+	for _, p := range []string{"a", "b", "c"} {
+		fsys[p] = &fstest.MapFile{ModTime: t0}
+		w.Register(&barrierNode{path: p, barrier: &barrier})
+	}
+	w.Scan()
+
+	barrier.Add(3)
+	for _, p := range []string{"a", "b", "c"} {
+		fsys[p] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	}
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Error(errs)
+	}
+}
+
+// BenchmarkScan compares StatConcurrency values on a local disk, where a
+// stat is cheap and mostly served from cache, and on a file system that
+// adds latency to every stat, where concurrent stats overlap the waits.
+func BenchmarkScan(b *testing.B) {
+	b.Run("disk", func(b *testing.B) {
+		const files = 10000
+		dir := b.TempDir()
+		n := &testNode{path: filepath.Join(dir, "root.txt")}
+		for i := range files {
+			p := filepath.Join(dir, fmt.Sprintf("%d.txt", i))
+			if err := os.WriteFile(p, nil, 0o644); err != nil {
+				b.Fatal(err)
+			}
+			n.deps = append(n.deps, p)
+		}
+		benchmarkStatConcurrency(b, nil, n)
+	})
+	b.Run("latency", func(b *testing.B) {
+		const files = 200
+		fsys := fstest.MapFS{}
+		n := &testNode{path: "root.txt"}
+		for i := range files {
+			p := fmt.Sprintf("%d.txt", i)
+			fsys[p] = &fstest.MapFile{}
+			n.deps = append(n.deps, p)
+		}
+		benchmarkStatConcurrency(b, latencyFS{fsys, 100 * time.Microsecond}, n)
+	})
+}
+
+func benchmarkStatConcurrency(b *testing.B, fsys fs.FS, n watch.Node) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("StatConcurrency=%d", workers), func(b *testing.B) {
+			w := &watch.Watcher{FS: fsys, StatConcurrency: workers}
+			w.Register(n)
+			w.Scan()
+			for b.Loop() {
+				w.Scan()
+			}
+		})
+	}
+}
+
+// latencyFS sleeps for latency before every Stat, like a network file system
+// that needs one round trip per stat.
+type latencyFS struct {
+	fs.StatFS
+	latency time.Duration
+}
+
+func (f latencyFS) Stat(name string) (fs.FileInfo, error) {
+	time.Sleep(f.latency)
+	return f.StatFS.Stat(name)
+}
+
+// BenchmarkScanAllocs tracks the allocations of a Scan that finds no
+// changes, with overlapping nodes and an in-memory file system.
+func BenchmarkScanAllocs(b *testing.B) {
+	const nodes, files = 10, 1000
+	fsys := fstest.MapFS{}
+	for i := range files {
+		fsys[fmt.Sprintf("dir%d/%d.txt", i%10, i)] = &fstest.MapFile{}
+	}
+	w := &watch.Watcher{FS: fsys}
+	for n := range nodes {
+		node := new(testNode)
+		for i := n * files / nodes; i < (n+2)*files/nodes && i < files; i++ {
+			node.deps = append(node.deps, fmt.Sprintf("dir%d/%d.txt", i%10, i))
+		}
+		node.path = node.deps[0]
+		w.Register(node)
+	}
+	w.Scan()
+	b.ReportAllocs()
+	for b.Loop() {
+		w.Scan()
+	}
+}
+
+// BenchmarkPathTable reports the memory the path table retains per watched
+// path for a large tree watched through a GlobNode, whose paths are built
+// afresh on every scan.
+func BenchmarkPathTable(b *testing.B) {
+	const dirs, files = 100, 200
+	root := b.TempDir()
+	for d := range dirs {
+		dir := filepath.Join(root, "src", fmt.Sprintf("package%03d", d))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			b.Fatal(err)
+		}
+		for f := range files {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%03d.go", f)), nil, 0o644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	pattern := filepath.ToSlash(filepath.Join(root, "src", "**", "*.go"))
+	var retained int64
+	var paths int
+	for b.Loop() {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
 		w := new(watch.Watcher)
-		p := path.Join(wd, "single_file.txt")
-		defer os.Remove(p)
-		n := testNode{path: p}
+		w.Register(&watch.GlobNode{Watcher: w, Patterns: []string{pattern}})
+		w.Scan()
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		paths = len(w.Paths())
+		runtime.KeepAlive(w)
+		// HeapAlloc can shrink if other garbage is collected in between.
+		retained = max(int64(after.HeapAlloc)-int64(before.HeapAlloc), 0)
+	}
+	b.ReportMetric(float64(retained)/float64(paths), "B/path")
+}
+
+func TestPause(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"a.txt": {ModTime: t0}, "b.txt": {ModTime: t0}}
+	w := &watch.Watcher{FS: fsys}
+	n := testNode{path: "a.txt", deps: []string{"b.txt"}}
+	w.Register(&n)
+	w.Scan()
+
+	w.Pause()
+	fsys["a.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+	fsys["b.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+	if n.updated != 0 {
+		t.Errorf("updated should be 0 while paused")
+	}
+
+	w.Resume()
+	if changed, _ := w.Scan(); !changed {
+		t.Errorf("Scan after Resume should report changes")
+	}
+	if n.updated != 1 {
+		t.Errorf("updated should be 1 after resume")
+	}
+	w.Scan()
+	if n.updated != 1 {
+		t.Errorf("updated should still be 1")
+	}
+}
+
+func TestState(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"a.txt": {ModTime: t0}, "b.txt": {ModTime: t0}}
+	w := &watch.Watcher{FS: fsys}
+	w.Register(&testNode{path: "a.txt", deps: []string{"b.txt", "c.txt"}})
+	w.Scan()
+	var buf bytes.Buffer
+	if err := w.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// change files while no watcher is running
+	fsys["a.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	fsys["c.txt"] = &fstest.MapFile{ModTime: t0}
+
+	w = &watch.Watcher{FS: fsys}
+	if err := w.LoadState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	n := testNode{path: "a.txt", deps: []string{"c.txt"}}
+	unchanged := testNode{path: "b.txt"}
+	w.Register(&n)
+	w.Register(&unchanged)
+	w.Scan()
+	if n.updated != 1 {
+		t.Errorf("updated should be 1 after restoring state")
+	}
+	if unchanged.updated != 0 {
+		t.Errorf("unchanged node should not be updated")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	fsys := new(watchtest.FS)
+	for _, name := range []string{"a.txt", "b.txt", "d.txt", "f.txt"} {
+		fsys.WriteFile(name, []byte(name))
+	}
+	w := &watch.Watcher{FS: fsys}
+	n := &testNode{path: "a.txt", deps: []string{"b.txt", "c.txt", "d.txt", "e.txt", "f.txt"}}
+	w.Register(n)
+	w.Scan()
+	before, err := w.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := before.Info("c.txt"); !ok || info != nil {
+		t.Errorf("c.txt should be recorded as missing, got %v, %v", info, ok)
+	}
+
+	// a build step changes the tree
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", []byte("aa"))
+	fsys.Remove("b.txt")
+	fsys.WriteFile("c.txt", nil)
+	fsys.Rename("d.txt", "e.txt")
+	after, err := w.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []watch.Event{
+		{Op: watch.Write, Path: "a.txt"},
+		{Op: watch.Remove, Path: "b.txt"},
+		{Op: watch.Create, Path: "c.txt"},
+		{Op: watch.Rename, Path: "e.txt", OldPath: "d.txt"},
+	}
+	if got := watch.Diff(before, after); !slices.Equal(got, want) {
+		t.Errorf("diff should be %v, got %v", want, got)
+	}
+	if n.updated != 0 {
+		t.Error("Snapshot should not notify nodes")
+	}
+
+	data, err := json.Marshal(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded watch.State
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got := watch.Diff(decoded, after); !slices.Equal(got, want) {
+		t.Errorf("diff of a decoded state should be %v, got %v", want, got)
+	}
+	if w.Scan(); n.updated != 1 {
+		t.Error("changes seen by Snapshot should still be reported by Scan")
+	}
+}
+
+func TestPeek(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"a.txt": {ModTime: t0}, "b.txt": {ModTime: t0}}
+	w := &watch.Watcher{FS: fsys}
+	a := &testNode{path: "a.txt"}
+	b := &testNode{path: "b.txt"}
+	w.Register(a)
+	w.Register(b)
+	w.AddDependency(b, a)
+	w.Scan()
+
+	fsys["a.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	for range 2 {
+		paths, nodes, errs := w.Peek()
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if !slices.Equal(paths, []string{"a.txt"}) {
+			t.Errorf("paths should be [a.txt], got %v", paths)
+		}
+		if len(nodes) != 2 || nodes[0] != watch.Node(a) || nodes[1] != watch.Node(b) {
+			t.Errorf("nodes should be [a b], got %v", nodes)
+		}
+	}
+	if a.updated != 0 || b.updated != 0 {
+		t.Errorf("Peek should not notify nodes")
+	}
+	w.Scan()
+	if a.updated != 1 || b.updated != 1 {
+		t.Errorf("Scan should still notify after Peek")
+	}
+}
+
+// changedNode records the paths reported by ChangedPaths during Updated.
+type changedNode struct {
+	testNode
+	w       *watch.Watcher
+	changed []string
+}
+
+func (cn *changedNode) Updated() error {
+	cn.changed = cn.w.ChangedPaths(cn)
+	return cn.testNode.Updated()
+}
+
+func TestChangedPaths(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"a.txt": {ModTime: t0}, "b.txt": {ModTime: t0}, "c.txt": {ModTime: t0}}
+	w := &watch.Watcher{FS: fsys}
+	n := &changedNode{testNode: testNode{path: "a.txt", deps: []string{"b.txt", "c.txt"}}, w: w}
+	w.Register(n)
+	w.Scan()
+
+	w.Pause()
+	fsys["c.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+	w.Resume()
+	fsys["a.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+	if want := []string{"a.txt", "c.txt"}; !slices.Equal(n.changed, want) {
+		t.Errorf("changed paths should be %v, got %v", want, n.changed)
+	}
+	if got := w.ChangedPaths(n); !slices.Equal(got, n.changed) {
+		t.Errorf("changed paths should remain valid after Scan, got %v", got)
+	}
+	w.Scan()
+	if got := w.ChangedPaths(n); got != nil {
+		t.Errorf("changed paths should be reset by the next Scan, got %v", got)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	t0 := time.Now()
+	newFS := func() fstest.MapFS {
+		return fstest.MapFS{
+			"old.txt":  {ModTime: t0},
+			"new.txt":  {ModTime: t0.Add(-time.Hour)},
+			"link.txt": {Data: []byte("old.txt"), Mode: fs.ModeSymlink, ModTime: t0},
+		}
+	}
+
+	t.Run("link detects retarget", func(t *testing.T) {
+		fsys := newFS()
+		fsys["new.txt"] = &fstest.MapFile{ModTime: t0}
+		w := &watch.Watcher{FS: fsys, Symlinks: watch.SymlinkLink}
+		n := testNode{path: "link.txt"}
+		w.Register(&n)
+		w.Scan()
+		fsys["link.txt"] = &fstest.MapFile{Data: []byte("new.txt"), Mode: fs.ModeSymlink, ModTime: t0}
+		w.Scan()
+		if n.updated != 1 {
+			t.Errorf("updated should be 1 after retarget")
+		}
+	})
+
+	t.Run("link watches broken links", func(t *testing.T) {
+		fsys := newFS()
+		delete(fsys, "old.txt")
+		w := &watch.Watcher{FS: fsys, Symlinks: watch.SymlinkLink}
+		n := testNode{path: "link.txt"}
+		w.Register(&n)
+		w.Scan()
+		fsys["link.txt"] = &fstest.MapFile{Data: []byte("gone.txt"), Mode: fs.ModeSymlink, ModTime: t0}
+		w.Scan()
+		if n.updated != 1 {
+			t.Errorf("updated should be 1 after broken link is retargeted")
+		}
+	})
+
+	t.Run("both detects target change", func(t *testing.T) {
+		fsys := newFS()
+		w := &watch.Watcher{FS: fsys, Symlinks: watch.SymlinkBoth}
+		n := testNode{path: "link.txt"}
 		w.Register(&n)
 		w.Scan()
-		os.Create(p)
+		fsys["old.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+		w.Scan()
+		if n.updated != 1 {
+			t.Errorf("updated should be 1 after target changes")
+		}
+		fsys["link.txt"] = &fstest.MapFile{Data: []byte("new.txt"), Mode: fs.ModeSymlink, ModTime: t0}
+		w.Scan()
+		if n.updated != 2 {
+			t.Errorf("updated should be 2 after retarget")
+		}
+	})
+}
+
+func TestAtomicSave(t *testing.T) {
+	t.Run("size change with equal mtime", func(t *testing.T) {
+		t0 := time.Now()
+		fsys := fstest.MapFS{"a.txt": {Data: []byte("a"), ModTime: t0}}
+		w := &watch.Watcher{FS: fsys}
+		n := testNode{path: "a.txt"}
+		w.Register(&n)
 		w.Scan()
-		w.Unregister(&n)
-		os.Chtimes(p, time.Now(), time.Now())
+		fsys["a.txt"] = &fstest.MapFile{Data: []byte("ab"), ModTime: t0}
 		w.Scan()
 		if n.updated != 1 {
 			t.Errorf("updated should be 1")
 		}
 	})
 
-	t.Run("watches single file with dependency update", func(t *testing.T) {
+	t.Run("rename over with equal mtime", func(t *testing.T) {
+		dir := t.TempDir()
+		p := filepath.Join(dir, "a.txt")
+		tmp := filepath.Join(dir, "a.txt.tmp")
+		mtime := time.Now().Truncate(time.Second)
+		os.WriteFile(p, []byte("a"), 0o644)
+		os.Chtimes(p, mtime, mtime)
 		w := new(watch.Watcher)
-		mainPath := path.Join(wd, "main_file.txt")
-		depPath := path.Join(wd, "dep_file.txt")
-		depPath2 := path.Join(wd, "dep_file_2.txt")
-		defer os.Remove(mainPath)
-		defer os.Remove(depPath)
-		defer os.Remove(depPath2)
-		n := testNode{path: mainPath, deps: []string{depPath, depPath2}}
-
-		os.Create(mainPath)
+		n := testNode{path: p}
 		w.Register(&n)
 		w.Scan()
-		if n.updated != 0 {
-			t.Errorf("updated should be 0")
+
+		os.WriteFile(tmp, []byte("b"), 0o644)
+		os.Chtimes(tmp, mtime, mtime)
+		if err := os.Rename(tmp, p); err != nil {
+			t.Fatal(err)
 		}
+		w.Scan()
+		if runtime.GOOS != "windows" && n.updated != 1 {
+			t.Errorf("updated should be 1")
+		}
+	})
+}
+
+// openFS only implements fs.FS.
+type openFS struct{ fsys fs.FS }
+
+func (o openFS) Open(name string) (fs.File, error) { return o.fsys.Open(name) }
+
+func TestStrict(t *testing.T) {
+	t.Run("requires StatFS", func(t *testing.T) {
+		for _, fsys := range []fs.FS{nil, openFS{fstest.MapFS{}}} {
+			w := &watch.Watcher{FS: fsys, Strict: true}
+			w.Register(&testNode{path: "a.txt"})
+			if _, errs := w.Scan(); len(errs) != 1 || !errors.Is(errs[0], watch.ErrNotStatFS) {
+				t.Errorf("expected ErrNotStatFS, got %v", errs)
+			}
+		}
+	})
 
-		os.Create(depPath)
+	t.Run("uses FS only", func(t *testing.T) {
+		fsys := new(watchtest.FS)
+		fsys.WriteFile("a.txt", []byte("a"))
+		w := &watch.Watcher{FS: fsys, Strict: true, Detect: watch.DetectHash}
+		n := testNode{path: "a.txt"}
+		w.Register(&n)
 		w.Scan()
+		fsys.Advance(time.Second)
+		fsys.WriteFile("a.txt", []byte("b"))
+		if _, errs := w.Scan(); len(errs) > 0 {
+			t.Fatal(errs)
+		}
 		if n.updated != 1 {
 			t.Errorf("updated should be 1")
 		}
+	})
+}
+
+func TestFile(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	fsys.WriteFile("b.txt", nil)
+	w := &watch.Watcher{FS: fsys}
+	var a, ab int
+	w.Register(watch.File("a.txt", func() error { a++; return nil }))
+	w.Register(watch.Files([]string{"a.txt", "b.txt"}, func() error { ab++; return nil }))
+	w.Register(watch.File("b.txt", nil))
+	w.Scan()
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("b.txt", nil)
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if a != 0 || ab != 1 {
+		t.Errorf("expected a=0 ab=1, got a=%d ab=%d", a, ab)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", nil)
+	w.Scan()
+	if a != 1 || ab != 2 {
+		t.Errorf("expected a=1 ab=2, got a=%d ab=%d", a, ab)
+	}
+}
+
+type batchNode struct {
+	paths []string
+	calls [][]string
+}
+
+func (b *batchNode) Paths() []string { return b.paths }
+
+func (b *batchNode) Updated() error { panic("Updated should not be called on a BatchNode") }
+
+func (b *batchNode) UpdatedPaths(paths []string) error {
+	b.calls = append(b.calls, paths)
+	return nil
+}
+
+func TestBatchNode(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	fsys.WriteFile("b.txt", nil)
+	fsys.WriteFile("c.txt", nil)
+	w := &watch.Watcher{FS: fsys}
+	n := &batchNode{paths: []string{"a.txt", "b.txt", "c.txt"}}
+	w.Register(n)
+	w.Scan()
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("c.txt", nil)
+	fsys.WriteFile("a.txt", nil)
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(n.calls) != 1 || !slices.Equal(n.calls[0], []string{"a.txt", "c.txt"}) {
+		t.Errorf("expected one call with [a.txt c.txt], got %v", n.calls)
+	}
+
+	w.UpdateAll()
+	if len(n.calls) != 2 || n.calls[1] != nil {
+		t.Errorf("UpdateAll should call UpdatedPaths with no paths, got %v", n.calls)
+	}
+}
+
+type priorityNode struct {
+	orderNode
+	priority int
+}
+
+func (pn *priorityNode) Priority() int { return pn.priority }
+
+func TestNotificationOrder(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	scan := func(w *watch.Watcher) {
+		fsys.Advance(time.Second)
+		fsys.WriteFile("a.txt", nil)
+		if _, errs := w.Scan(); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+	}
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
 
-		os.Create(depPath2)
+	t.Run("registration", func(t *testing.T) {
+		w := &watch.Watcher{FS: fsys}
+		var order []string
+		for _, name := range names {
+			w.Register(&orderNode{testNode: testNode{path: "a.txt"}, name: name, order: &order})
+		}
 		w.Scan()
-		if n.updated != 2 {
-			t.Errorf("updated should be 2")
+		for range 10 {
+			order = nil
+			scan(w)
+			if !slices.Equal(order, names) {
+				t.Fatalf("nodes should be notified in registration order, got %v", order)
+			}
+		}
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		w := &watch.Watcher{FS: fsys}
+		var order []string
+		a := &priorityNode{orderNode{testNode{path: "a.txt"}, "a", &order}, 0}
+		b := &priorityNode{orderNode{testNode{path: "a.txt"}, "b", &order}, 0}
+		config := &priorityNode{orderNode{testNode{path: "a.txt"}, "config", &order}, 10}
+		dep := &priorityNode{orderNode{testNode{path: "a.txt"}, "dep", &order}, 20}
+		for _, n := range []watch.Node{a, b, config, dep} {
+			w.Register(n)
+		}
+		// dep has the highest priority but must still wait for a
+		if err := w.AddDependency(dep, a); err != nil {
+			t.Fatal(err)
 		}
+		w.Scan()
+		scan(w)
+		if want := []string{"config", "a", "dep", "b"}; !slices.Equal(order, want) {
+			t.Errorf("order should be %v, got %v", want, order)
+		}
+	})
+
+	t.Run("compare", func(t *testing.T) {
+		var order []string
+		w := &watch.Watcher{FS: fsys, Compare: func(a, b watch.Node) int {
+			return strings.Compare(b.(*orderNode).name, a.(*orderNode).name)
+		}}
+		for _, name := range names {
+			w.Register(&orderNode{testNode: testNode{path: "a.txt"}, name: name, order: &order})
+		}
+		w.Scan()
+		scan(w)
+		want := slices.Clone(names)
+		slices.Reverse(want)
+		if !slices.Equal(order, want) {
+			t.Errorf("order should be %v, got %v", want, order)
+		}
+	})
+}
+
+type panicNode struct{ testNode }
+
+func (pn *panicNode) Updated() error { panic("boom") }
+
+func TestPanicRecovery(t *testing.T) {
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprint("concurrency ", concurrency), func(t *testing.T) {
+			fsys := new(watchtest.FS)
+			fsys.WriteFile("a.txt", nil)
+			w := &watch.Watcher{FS: fsys, Concurrency: concurrency}
+			bad := &panicNode{testNode{path: "a.txt"}}
+			good := &testNode{path: "a.txt"}
+			dependent := &testNode{path: "b.txt"}
+			w.Register(bad)
+			w.Register(good)
+			w.Register(dependent)
+			if err := w.AddDependency(dependent, bad); err != nil {
+				t.Fatal(err)
+			}
+			w.Scan()
+
+			fsys.Advance(time.Second)
+			fsys.WriteFile("a.txt", nil)
+			_, errs := w.Scan()
+			var perr *watch.PanicError
+			if len(errs) != 1 || !errors.As(errs[0], &perr) {
+				t.Fatalf("expected a *PanicError, got %v", errs)
+			}
+			if perr.Node != bad || perr.Value != "boom" || len(perr.Stack) == 0 {
+				t.Errorf("unexpected PanicError %+v", perr)
+			}
+			if good.updated != 1 {
+				t.Errorf("other nodes should still be notified")
+			}
+			if dependent.updated != 0 {
+				t.Errorf("dependents of a panicking node should not be notified")
+			}
+		})
+	}
+}
+
+type errNode struct {
+	testNode
+	err error
+}
+
+func (en *errNode) Updated() error { return en.err }
+
+// flakyNode fails its first failures updates.
+type flakyNode struct {
+	testNode
+	w        *watch.Watcher
+	failures int
+	changed  []string
+}
+
+func (fn *flakyNode) Updated() error {
+	fn.changed = fn.w.ChangedPaths(fn)
+	if fn.testNode.Updated(); fn.updated <= fn.failures {
+		return errors.New("parse error")
+	}
+	return nil
+}
+
+func TestUpdateRetry(t *testing.T) {
+	clock := new(watchtest.Clock)
+	fsys := &watchtest.FS{Clock: clock}
+	fsys.WriteFile("a.tmpl", []byte("{{"))
+	w := &watch.Watcher{FS: fsys, Clock: clock, UpdateRetry: watch.UpdateRetryPolicy{Attempts: 2, Backoff: time.Second}}
+	n := &flakyNode{testNode: testNode{path: "a.tmpl"}, w: w, failures: 5}
+	w.Register(n)
+	w.Scan()
+
+	clock.Advance(time.Second)
+	fsys.WriteFile("a.tmpl", []byte("{{.}"))
+	w.Scan()
+	w.Scan()
+	if n.updated != 1 {
+		t.Fatalf("a failed node should not be retried before its backoff, got %d updates", n.updated)
+	}
+	clock.Advance(time.Second)
+	if _, errs := w.Scan(); n.updated != 2 || len(errs) != 1 {
+		t.Errorf("a failed node should be retried after its backoff, got %d updates and %v", n.updated, errs)
+	}
+	if !slices.Equal(n.changed, []string{"a.tmpl"}) {
+		t.Errorf("a retry should report the original changes, got %v", n.changed)
+	}
+	clock.Advance(time.Second)
+	w.Scan()
+	if n.updated != 2 {
+		t.Errorf("the backoff should double, got %d updates", n.updated)
+	}
+	for range 5 {
+		clock.Advance(time.Second)
+		w.Scan()
+	}
+	if n.updated != 3 {
+		t.Errorf("a node should only be retried Attempts times, got %d updates", n.updated)
+	}
 
-		os.Chtimes(depPath, time.Now(), time.Now())
+	// retry without limit until the update succeeds
+	w.RegisterWithOptions(n, watch.NodeOptions{UpdateRetry: &watch.UpdateRetryPolicy{Attempts: -1}})
+	clock.Advance(time.Second)
+	fsys.WriteFile("a.tmpl", []byte("{{.}}"))
+	for range 5 {
 		w.Scan()
-		if n.updated != 3 {
-			t.Errorf("updated should be 3")
+	}
+	if n.updated != 6 {
+		t.Errorf("a node should be retried until it succeeds, got %d updates", n.updated)
+	}
+}
+
+func TestCommandNode(t *testing.T) {
+	for _, name := range []string{"echo", "sh", "sleep"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s not available", name)
 		}
+	}
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	fsys.WriteFile("b.txt", nil)
+	w := &watch.Watcher{FS: fsys}
+	var out bytes.Buffer
+	n := &watch.CommandNode{Files: []string{"a.txt", "b.txt"}, Command: []string{"echo", "first={path}", "{paths}"}, Stdout: &out}
+	w.Register(n)
+	w.Scan()
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("b.txt", []byte("b"))
+	fsys.WriteFile("a.txt", []byte("a"))
+	if _, errs := w.Scan(); errs != nil {
+		t.Fatal(errs)
+	}
+	if got, want := out.String(), "first=a.txt a.txt b.txt\n"; got != want {
+		t.Errorf("output should be %q, got %q", want, got)
+	}
 
-		os.Chtimes(depPath2, time.Now(), time.Now())
+	t.Run("behind a glob", func(t *testing.T) {
+		out.Reset()
+		fsys := new(watchtest.FS)
+		fsys.WriteFile("src/a.txt", nil)
+		w := &watch.Watcher{FS: fsys}
+		cmd := &watch.CommandNode{Command: []string{"echo", "{paths}"}, Stdout: &out}
+		w.Register(&watch.GlobNode{Watcher: w, Patterns: []string{"src/*.txt"}, Node: cmd})
+		w.Scan()
+
+		fsys.Advance(time.Second)
+		fsys.WriteFile("src/a.txt", []byte("a"))
+		fsys.WriteFile("src/b.txt", nil)
+		if _, errs := w.Scan(); errs != nil {
+			t.Fatal(errs)
+		}
+		if got, want := out.String(), "src/a.txt\n"; got != want {
+			t.Errorf("output should be %q, got %q", want, got)
+		}
+		out.Reset()
+		fsys.Advance(time.Second)
+		fsys.WriteFile("src/b.txt", []byte("b"))
 		w.Scan()
-		if n.updated != 4 {
-			t.Errorf("updated should be 4")
+		if got, want := out.String(), "src/b.txt\n"; got != want {
+			t.Errorf("created files should be matched, output should be %q, got %q", want, got)
+		}
+	})
+
+	t.Run("environment and errors", func(t *testing.T) {
+		out.Reset()
+		n := &watch.CommandNode{Command: []string{"sh", "-c", "echo $WATCH_TEST; exit 3"}, Env: []string{"WATCH_TEST=set"}, Stdout: &out}
+		var exit *exec.ExitError
+		if err := n.Updated(); !errors.As(err, &exit) || exit.ExitCode() != 3 {
+			t.Errorf("expected exit status 3, got %v", err)
+		}
+		if out.String() != "set\n" {
+			t.Errorf("Env should be passed to the command, got %q", out.String())
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		n := &watch.CommandNode{Command: []string{"sleep", "10"}, Timeout: 10 * time.Millisecond}
+		start := time.Now()
+		if err := n.Updated(); err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("expected a timeout, got %v", err)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Error("the command should be killed after Timeout")
 		}
 	})
+
+	t.Run("restart", func(t *testing.T) {
+		exits := make(chan error, 3)
+		n := &watch.CommandNode{Command: []string{"sleep", "10"}, Restart: true, OnExit: func(err error) { exits <- err }}
+		start := time.Now()
+		for range 2 {
+			if err := n.Updated(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cw := &watch.Watcher{}
+		cw.Register(n)
+		cw.Close()
+		if time.Since(start) > 5*time.Second {
+			t.Error("restarting and closing should kill the running command")
+		}
+		if len(exits) != 0 {
+			t.Errorf("OnExit should not be called for killed runs, got %v", <-exits)
+		}
+
+		n.Command = []string{"sh", "-c", "exit 1"}
+		n.Updated()
+		if err := <-exits; err == nil {
+			t.Error("OnExit should be called with the exit status")
+		}
+	})
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor polls cond for up to 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals are not supported")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	t.Run("restart on change", func(t *testing.T) {
+		fsys := new(watchtest.FS)
+		fsys.WriteFile("main.go", nil)
+		w := &watch.Watcher{FS: fsys}
+		var out syncBuffer
+		s := &watch.Supervisor{
+			Files:   []string{"main.go"},
+			Command: []string{"sh", "-c", `trap 'echo term; exit 0' TERM; echo up; while :; do sleep 0.05; done`},
+			Stdout:  &out,
+		}
+		w.Register(s)
+		defer w.Close()
+		w.Scan()
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "the server to start", func() bool { return out.String() == "up\n" })
+		first := s.Pid()
+
+		fsys.Advance(time.Second)
+		fsys.WriteFile("main.go", []byte("package main"))
+		if _, errs := w.Scan(); errs != nil {
+			t.Fatal(errs)
+		}
+		waitFor(t, "the server to restart", func() bool { return out.String() == "up\nterm\nup\n" })
+		if pid := s.Pid(); pid == 0 || pid == first {
+			t.Errorf("a change should start a new process, got pid %d after %d", pid, first)
+		}
+	})
+
+	t.Run("grace period", func(t *testing.T) {
+		var out syncBuffer
+		s := &watch.Supervisor{Command: []string{"sh", "-c", `trap '' TERM; echo up; while :; do sleep 0.05; done`}, Stdout: &out, GracePeriod: 100 * time.Millisecond}
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "the process to start", func() bool { return out.String() != "" })
+		start := time.Now()
+		s.Stop()
+		if d := time.Since(start); d < 100*time.Millisecond || d > 5*time.Second {
+			t.Errorf("a process ignoring SIGTERM should be killed after the grace period, took %v", d)
+		}
+		if s.Pid() != 0 {
+			t.Error("no process should be running after Stop")
+		}
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		var mu sync.Mutex
+		var exits []error
+		s := &watch.Supervisor{Command: []string{"sh", "-c", "exit 2"}, Backoff: time.Millisecond, MaxBackoff: 20 * time.Millisecond, OnExit: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			exits = append(exits, err)
+		}}
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "restarts", func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(exits) >= 3
+		})
+		s.Stop()
+		mu.Lock()
+		defer mu.Unlock()
+		var exit *exec.ExitError
+		if !errors.As(exits[0], &exit) || exit.ExitCode() != 2 {
+			t.Errorf("OnExit should get the exit status, got %v", exits[0])
+		}
+	})
+
+	t.Run("backoff clock", func(t *testing.T) {
+		clock := new(watchtest.Clock)
+		var exits atomic.Int32
+		s := &watch.Supervisor{Command: []string{"sh", "-c", "exit 2"}, Backoff: time.Hour, Clock: clock, OnExit: func(error) {
+			exits.Add(1)
+		}}
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Stop()
+		waitFor(t, "the process to exit", func() bool { return exits.Load() == 1 })
+		time.Sleep(50 * time.Millisecond)
+		if exits.Load() != 1 {
+			t.Fatal("the process should not restart before the Clock reaches the backoff")
+		}
+		waitFor(t, "the restart", func() bool {
+			clock.Advance(time.Hour)
+			return exits.Load() >= 2
+		})
+	})
+}
+
+func TestNodeError(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	errBuild := errors.New("build failed")
+	w := &watch.Watcher{FS: fsys}
+	n := &errNode{testNode{path: "a.txt"}, errBuild}
+	w.Register(n)
+	w.Scan()
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", nil)
+	_, err := w.ScanErr()
+	var nerr *watch.NodeError
+	if !errors.As(err, &nerr) || nerr.Node != n || nerr.Path != "a.txt" || !errors.Is(err, errBuild) {
+		t.Errorf("expected a *NodeError for a.txt wrapping errBuild, got %v", err)
+	}
+	if want := "watch: *watch_test.errNode: a.txt: build failed"; err.Error() != want {
+		t.Errorf("error should be %q, got %q", want, err.Error())
+	}
+
+	errs := w.UpdateAll()
+	if len(errs) != 1 || !errors.As(errs[0], &nerr) || nerr.Path != "" {
+		t.Errorf("expected a *NodeError without a path, got %v", errs)
+	}
+
+	if _, err := w.ScanErr(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestNotifyExisting(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	w := &watch.Watcher{FS: fsys, NotifyExisting: true}
+	a := &testNode{path: "a.txt"}
+	missing := &testNode{path: "missing.txt"}
+	w.Register(a)
+	w.Register(missing)
+
+	paths, nodes, _ := w.Peek()
+	if !slices.Equal(paths, []string{"a.txt"}) || len(nodes) != 1 {
+		t.Errorf("Peek should report existing paths, got %v %v", paths, nodes)
+	}
+	if updated, _ := w.Scan(); !updated || a.updated != 1 || missing.updated != 0 {
+		t.Errorf("first scan should notify nodes with existing paths")
+	}
+	if !slices.Equal(w.ChangedPaths(a), []string{"a.txt"}) {
+		t.Errorf("ChangedPaths should report existing paths, got %v", w.ChangedPaths(a))
+	}
+	if updated, _ := w.Scan(); updated {
+		t.Errorf("later scans should only report changes")
+	}
+
+	// nodes registered later are notified on their first scan
+	b := &testNode{path: "a.txt"}
+	w.Register(b)
+	w.Scan()
+	if a.updated != 1 || b.updated != 1 {
+		t.Errorf("only the new node should be notified, got a=%d b=%d", a.updated, b.updated)
+	}
+}
+
+func TestScanPaths(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	fsys.WriteFile("b.txt", nil)
+	w := &watch.Watcher{FS: fsys}
+	a := &testNode{path: "a.txt"}
+	b := &testNode{path: "b.txt"}
+	ab := &testNode{path: "a.txt", deps: []string{"b.txt"}}
+	w.Register(a)
+	w.Register(b)
+	w.Register(ab)
+	w.Scan()
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", nil)
+	fsys.WriteFile("b.txt", nil)
+	if updated, errs := w.ScanPaths("b.txt", "unwatched.txt"); !updated || len(errs) > 0 {
+		t.Fatalf("ScanPaths should report an update, got %v", errs)
+	}
+	if a.updated != 0 || b.updated != 1 || ab.updated != 1 {
+		t.Errorf("only nodes watching b.txt should be notified, got a=%d b=%d ab=%d", a.updated, b.updated, ab.updated)
+	}
+
+	// a.txt was not visited, so a full scan still reports it
+	w.Scan()
+	if a.updated != 1 || b.updated != 1 || ab.updated != 2 {
+		t.Errorf("full scan should report a.txt, got a=%d b=%d ab=%d", a.updated, b.updated, ab.updated)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", nil)
+	w.ScanNode(a)
+	if a.updated != 2 || ab.updated != 3 {
+		t.Errorf("ScanNode should notify every node watching a.txt, got a=%d ab=%d", a.updated, ab.updated)
+	}
+	w.Unregister(ab)
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", nil)
+	w.ScanPaths("a.txt")
+	if a.updated != 3 || ab.updated != 3 {
+		t.Errorf("unregistered nodes should not be notified, got a=%d ab=%d", a.updated, ab.updated)
+	}
+}
+
+type metricsRecorder []watch.ScanStats
+
+func (m *metricsRecorder) ObserveScan(s watch.ScanStats) { *m = append(*m, s) }
+
+func TestStats(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	fsys.WriteFile("b.txt", nil)
+	var m metricsRecorder
+	w := &watch.Watcher{FS: fsys, Metrics: &m, Clock: new(watchtest.Clock)}
+	w.Register(&testNode{path: "a.txt", deps: []string{"b.txt"}})
+	failing := &errNode{testNode{path: "b.txt"}, errors.New("failed")}
+	w.Register(failing)
+	w.Scan()
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("b.txt", nil)
+	w.Scan()
+
+	want := []watch.ScanStats{
+		{Paths: 2},
+		{Paths: 2, Changes: 1, Notified: 2, Errors: 1},
+	}
+	if !slices.Equal(m, want) {
+		t.Errorf("scan stats should be %+v, got %+v", want, m)
+	}
+	got := w.Stats()
+	if got.Nodes != 2 || got.Paths != 2 || got.Scans != 2 || got.Stats != 4 ||
+		got.Changes != 1 || got.Notified != 2 || got.Errors != 1 || got.LastScan != want[1] {
+		t.Errorf("unexpected stats %+v", got)
+	}
+	if len(got.NodeErrors) != 1 || got.NodeErrors[failing] != 1 {
+		t.Errorf("the error should be counted for its node, got %v", got.NodeErrors)
+	}
+	w.UpdateAll()
+	if got := w.Stats().NodeErrors[failing]; got != 2 {
+		t.Errorf("errors of UpdateAll should be counted too, got %d", got)
+	}
+	w.Unregister(failing)
+	if got := w.Stats().NodeErrors[failing]; got != 2 {
+		t.Errorf("the errors of an unregistered node should still be counted, got %d", got)
+	}
+}
+
+func TestNodeOptions(t *testing.T) {
+	clock := new(watchtest.Clock)
+	fsys := &watchtest.FS{Clock: clock}
+	fsys.WriteFile("asset.bin", []byte("a"))
+	fsys.WriteFile("shader.glsl", []byte("a"))
+	w := &watch.Watcher{FS: fsys, Clock: clock}
+	asset := &testNode{path: "asset.bin"}
+	shader := &testNode{path: "shader.glsl"}
+	hash := watch.DetectHash
+	w.RegisterWithOptions(asset, watch.NodeOptions{Interval: time.Minute})
+	w.RegisterWithOptions(shader, watch.NodeOptions{Detect: &hash, Debounce: time.Second})
+	w.Scan()
+
+	t.Run("interval", func(t *testing.T) {
+		clock.Advance(time.Second)
+		fsys.WriteFile("asset.bin", []byte("b"))
+		w.Scan()
+		if asset.updated != 0 {
+			t.Errorf("asset should not be checked before its interval")
+		}
+		if got := w.Stats().LastScan.Paths; got != 1 {
+			t.Errorf("only one path should be statted, got %d", got)
+		}
+		clock.Advance(time.Minute)
+		w.Scan()
+		if asset.updated != 1 {
+			t.Errorf("asset should be checked once its interval elapses")
+		}
+	})
+
+	t.Run("detect and debounce", func(t *testing.T) {
+		// touching without modifying is not reported with DetectHash
+		clock.Advance(time.Second)
+		fsys.Chtimes("shader.glsl", clock.Now())
+		w.Scan()
+		clock.Advance(time.Second)
+		w.Scan()
+		if shader.updated != 0 {
+			t.Errorf("touch should not be reported with DetectHash")
+		}
+
+		fsys.WriteFile("shader.glsl", []byte("b"))
+		w.Scan()
+		if shader.updated != 0 {
+			t.Errorf("shader should be debounced")
+		}
+		clock.Advance(time.Second)
+		w.Scan()
+		if shader.updated != 1 {
+			t.Errorf("shader should be notified after its debounce period")
+		}
+	})
+
+	t.Run("shared path", func(t *testing.T) {
+		// only the node using DetectHash ignores a touch of a shared path
+		copier := &testNode{path: "shader.glsl"}
+		w.Register(copier)
+		w.Scan()
+		clock.Advance(time.Second)
+		fsys.Chtimes("shader.glsl", clock.Now())
+		w.Scan()
+		clock.Advance(time.Second)
+		w.Scan()
+		if copier.updated != 1 || shader.updated != 1 {
+			t.Errorf("touch should only notify the node without DetectHash, got %d and %d", copier.updated, shader.updated)
+		}
+	})
+}
+
+type producerNode struct {
+	testNode
+	fsys *watchtest.FS
+}
+
+func (pn *producerNode) Outputs() []string { return []string{"out/a.o"} }
+
+func (pn *producerNode) Updated() error {
+	pn.fsys.WriteFile("out/a.o", []byte(fmt.Sprint(pn.updated)))
+	return pn.testNode.Updated()
+}
+
+func TestProducer(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.c", nil)
+	fsys.WriteFile("out/a.o", nil)
+	w := &watch.Watcher{FS: fsys}
+	// the producer watches its own output, as a node watching a whole
+	// directory tree would
+	p := &producerNode{testNode{path: "a.c", deps: []string{"out/a.o"}}, fsys}
+	consumer := &testNode{path: "out/a.o"}
+	w.Register(p)
+	w.Register(consumer)
+	w.Scan()
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.c", nil)
+	w.Scan()
+	if p.updated != 1 {
+		t.Fatalf("producer should be notified of its input")
+	}
+	fsys.Advance(time.Second)
+	w.Scan()
+	if p.updated != 1 {
+		t.Errorf("producer should not be notified of its own output")
+	}
+	if consumer.updated != 1 {
+		t.Errorf("consumer should be notified of the output")
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("out/a.o", []byte("external"))
+	w.Scan()
+	if p.updated != 2 {
+		t.Errorf("producer should be notified of external edits to its output")
+	}
+}
+
+type blockingNode struct {
+	testNode
+	started, release chan struct{}
+}
+
+func (bn *blockingNode) Updated() error {
+	close(bn.started)
+	<-bn.release
+	return bn.testNode.Updated()
+}
+
+func TestClose(t *testing.T) {
+	clock := new(watchtest.Clock)
+	fsys := &watchtest.FS{Clock: clock}
+	fsys.WriteFile("a.txt", nil)
+	w := &watch.Watcher{FS: fsys, Clock: clock}
+	n := &blockingNode{testNode{path: "a.txt"}, make(chan struct{}), make(chan struct{})}
+	w.Register(n)
+	w.Scan()
+
+	// Close waits for an in-flight notification to finish
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", []byte("a"))
+	run := make(chan error)
+	go func() { run <- w.Run(context.Background(), time.Second, nil) }()
+	<-n.started
+	closed := make(chan error)
+	go func() { closed <- w.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Close should wait for Updated to return")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(n.release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-run; err != watch.ErrClosed {
+		t.Errorf("Run should return ErrClosed, got %v", err)
+	}
+
+	if err := w.Register(&testNode{path: "b.txt"}); err != watch.ErrClosed {
+		t.Errorf("Register should return ErrClosed, got %v", err)
+	}
+	if _, errs := w.Scan(); len(errs) != 1 || errs[0] != watch.ErrClosed {
+		t.Errorf("Scan should return ErrClosed, got %v", errs)
+	}
+	if !w.Empty() {
+		t.Errorf("Close should unregister all nodes")
+	}
+	if err := w.Close(); err != watch.ErrClosed {
+		t.Errorf("second Close should return ErrClosed, got %v", err)
+	}
+}
+
+// hangFS blocks stats of one path until release is closed.
+type hangFS struct {
+	*watchtest.FS
+	path    string
+	release chan struct{}
+}
+
+func (h *hangFS) Stat(name string) (fs.FileInfo, error) {
+	if name == h.path {
+		<-h.release
+	}
+	return h.FS.Stat(name)
+}
+
+func TestScanContext(t *testing.T) {
+	t.Run("stat", func(t *testing.T) {
+		mem := new(watchtest.FS)
+		mem.WriteFile("a.txt", nil)
+		mem.WriteFile("slow.txt", nil)
+		fsys := &hangFS{mem, "slow.txt", make(chan struct{})}
+		w := &watch.Watcher{FS: fsys, StatConcurrency: 2}
+		a := &testNode{path: "a.txt"}
+		slow := &testNode{path: "slow.txt"}
+		w.Register(a)
+		w.Register(slow)
+		close(fsys.release)
+		w.Scan()
+
+		fsys.release = make(chan struct{})
+		mem.Advance(time.Second)
+		mem.WriteFile("a.txt", nil)
+		mem.WriteFile("slow.txt", nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, unreached, errs := w.ScanContext(ctx)
+		if a.updated != 0 {
+			t.Errorf("nodes should not be notified once the context is done")
+		}
+		if !slices.Equal(unreached, []string{"slow.txt"}) {
+			t.Errorf("slow.txt should be unreached, got %v", unreached)
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], context.DeadlineExceeded) {
+			t.Errorf("expected DeadlineExceeded, got %v", errs)
+		}
+
+		close(fsys.release)
+		w.Scan()
+		if a.updated != 1 || slow.updated != 1 {
+			t.Errorf("held nodes and unreached paths should be handled by the next scan")
+		}
+	})
+
+	t.Run("notify", func(t *testing.T) {
+		fsys := new(watchtest.FS)
+		fsys.WriteFile("a.txt", nil)
+		w := &watch.Watcher{FS: fsys}
+		ctx, cancel := context.WithCancel(context.Background())
+		first := watch.File("a.txt", func() error { cancel(); return nil })
+		second := &testNode{path: "a.txt"}
+		w.Register(first)
+		w.Register(second)
+		w.Scan()
+
+		fsys.Advance(time.Second)
+		fsys.WriteFile("a.txt", nil)
+		_, _, errs := w.ScanContext(ctx)
+		if second.updated != 0 {
+			t.Errorf("nodes should not be notified once the context is done")
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+			t.Errorf("expected Canceled, got %v", errs)
+		}
+		w.Scan()
+		if second.updated != 1 || !slices.Equal(w.ChangedPaths(second), []string{"a.txt"}) {
+			t.Errorf("skipped nodes should be notified by the next scan")
+		}
+	})
+}
+
+type eventsNode struct {
+	testNode
+	w      *watch.Watcher
+	events []watch.Event
+}
+
+func (en *eventsNode) Updated() error {
+	en.events = en.w.Events(en)
+	return en.testNode.Updated()
+}
+
+func TestEvents(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", []byte("a"))
+	fsys.WriteFile("c.txt", []byte("c"))
+	w := &watch.Watcher{FS: fsys}
+	n := &eventsNode{testNode: testNode{path: "a.txt", deps: []string{"b.txt", "c.txt"}}, w: w}
+	w.Register(n)
+	w.Scan()
+
+	check := func(want ...watch.Event) {
+		t.Helper()
+		n.events = nil
+		if w.Scan(); !slices.Equal(n.events, want) {
+			t.Errorf("events should be %v, got %v", want, n.events)
+		}
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", []byte("aa"))
+	check(watch.Event{Op: watch.Write, Path: "a.txt"})
+
+	fsys.Rename("a.txt", "b.txt")
+	check(watch.Event{Op: watch.Rename, Path: "b.txt", OldPath: "a.txt"})
+
+	fsys.Remove("c.txt")
+	check(watch.Event{Op: watch.Remove, Path: "c.txt"})
+
+	fsys.WriteFile("c.txt", []byte("c"))
+	check(watch.Event{Op: watch.Create, Path: "c.txt"})
+
+	fsys.Rename("b.txt", "d.txt")
+	check(watch.Event{Op: watch.Rename, Path: "d.txt", OldPath: "b.txt"})
+	if got := w.Events(n); len(got) != 1 {
+		t.Errorf("events should remain valid after Scan, got %v", got)
+	}
+
+	check()
+	if got := w.Events(n); got != nil {
+		t.Errorf("events should be reset by the next Scan, got %v", got)
+	}
+}
+
+func TestListDirs(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("dir/a.txt", []byte("a"))
+	w := &watch.Watcher{FS: fsys, ListDirs: true}
+	w.Ignore("*.tmp")
+	n := &eventsNode{testNode: testNode{path: "dir"}, w: w}
+	w.Register(n)
+	w.Scan()
+
+	// watchtest.FS never updates the modification time of directories
+	fsys.WriteFile("dir/b.txt", []byte("b"))
+	if updated, errs := w.Scan(); !updated || len(errs) > 0 {
+		t.Fatalf("adding a file should update the directory, got %v, %v", updated, errs)
+	}
+	if want := []watch.Event{{Op: watch.Create, Path: "dir/b.txt"}}; !slices.Equal(n.events, want) {
+		t.Errorf("events should be %v, got %v", want, n.events)
+	}
+
+	fsys.Remove("dir/a.txt")
+	w.Scan()
+	if want := []watch.Event{{Op: watch.Remove, Path: "dir/a.txt"}}; !slices.Equal(n.events, want) {
+		t.Errorf("events should be %v, got %v", want, n.events)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("dir/b.txt", []byte("bb"))
+	fsys.WriteFile("dir/c.tmp", []byte("c"))
+	if updated, _ := w.Scan(); updated {
+		t.Error("modifying a file or adding an ignored one should not update the directory")
+	}
+	if n.updated != 2 {
+		t.Errorf("node should be updated twice, got %d", n.updated)
+	}
+
+	w.ListDirs = false
+	fsys.WriteFile("dir/d.txt", []byte("d"))
+	if updated, _ := w.Scan(); updated {
+		t.Error("directory entries should only be compared when ListDirs is set")
+	}
+}
+
+func TestListSubtrees(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("assets/a.png", nil)
+	w := &watch.Watcher{FS: fsys, ListDirs: true, ListSubtrees: true}
+	w.Ignore("*.tmp")
+	n := &eventsNode{testNode: testNode{path: "assets"}, w: w}
+	w.Register(n)
+	w.Scan()
+
+	fsys.WriteFile("assets/new/deep/file.png", nil)
+	fsys.WriteFile("assets/new/deep/file.tmp", nil)
+	fsys.WriteFile("assets/new/b.png", nil)
+	w.Scan()
+	want := []watch.Event{
+		{Op: watch.Create, Path: "assets/new"},
+		{Op: watch.Create, Path: "assets/new/b.png"},
+		{Op: watch.Create, Path: "assets/new/deep"},
+		{Op: watch.Create, Path: "assets/new/deep/file.png"},
+	}
+	if !slices.Equal(n.events, want) || n.updated != 1 {
+		t.Errorf("a new subtree should be reported in one notification as %v, got %v in %d", want, n.events, n.updated)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("src/a.go", nil)
+	w := &watch.Watcher{FS: fsys, ListDirs: true, Filter: watch.DefaultFilter}
+	n := watchtest.NewNode("src")
+	n.Watcher = w
+	w.Register(n)
+	all := watchtest.NewNode("src")
+	all.Watcher = w
+	w.RegisterWithOptions(all, watch.NodeOptions{Filter: func(string, watch.Op) bool { return true }})
+	w.Scan()
+
+	fsys.WriteFile("src/b.go", nil)
+	fsys.WriteFile("src/.b.go.swp", nil)
+	w.Scan()
+	if got, want := n.Events(), []watch.Event{{Op: watch.Create, Path: "src/b.go"}}; !slices.Equal(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+
+	// a change made only of noise does not notify the node
+	fsys.Remove("src/.b.go.swp")
+	fsys.WriteFile("src/b.go~", nil)
+	if paths, nodes, _ := w.Peek(); len(paths) != 1 || len(nodes) != 1 || nodes[0] != all {
+		t.Errorf("Peek should only report the node without a filter, got %v, %v", paths, nodes)
+	}
+	w.Scan()
+	watchtest.ExpectUpdated(t, n, 1)
+	watchtest.ExpectUpdated(t, all, 2)
+	if got := len(all.Events()); got != 2 {
+		t.Errorf("a node whose filter keeps everything should get every event, got %v", all.Events())
+	}
+
+	for p, want := range map[string]bool{
+		"src/main.go":      true,
+		"src/.main.go.swp": false,
+		"src/main.go.swo":  false,
+		"src/main.go~":     false,
+		"src/.#main.go":    false,
+		"src/#main.go#":    false,
+		"src/4913":         false,
+		".DS_Store":        false,
+		`dir\Thumbs.db`:    false,
+		"src/#":            true,
+	} {
+		if got := watch.DefaultFilter(p, watch.Create); got != want {
+			t.Errorf("DefaultFilter(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func TestMaxPaths(t *testing.T) {
+	fsys := new(watchtest.FS)
+	for i := range 10 {
+		fsys.WriteFile(fmt.Sprintf("gen/%d.txt", i), nil)
+	}
+	fsys.WriteFile("a.txt", nil)
+	var limits []*watch.PathLimitError
+	w := &watch.Watcher{FS: fsys, MaxPaths: 5, OnPathLimit: func(err *watch.PathLimitError) {
+		limits = append(limits, err)
+	}}
+	a := watchtest.NewNode("a.txt")
+	a.Watcher = w
+	w.Register(a)
+	glob := &watch.GlobNode{Watcher: w, Patterns: []string{"gen/*.txt"}}
+	w.Register(glob)
+	_, errs := w.Scan()
+	var limit *watch.PathLimitError
+	if len(errs) != 1 || !errors.As(errs[0], &limit) {
+		t.Fatalf("expected a PathLimitError, got %v", errs)
+	}
+	// the glob references the gen directory and its 10 files
+	if limit.Limit != 5 || limit.Paths != 12 || len(limit.Nodes) != 2 || limit.Nodes[0].Node != glob || limit.Nodes[0].Paths != 11 {
+		t.Errorf("unexpected error %+v", limit)
+	}
+	if !strings.Contains(limit.Error(), `["gen/*.txt"] (11)`) {
+		t.Errorf("the error should name the offending pattern, got %q", limit.Error())
+	}
+	if len(limits) != 1 || limits[0] != limit {
+		t.Errorf("OnPathLimit should be called with the error, got %v", limits)
+	}
+
+	// the paths registered first are still watched
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", []byte("a"))
+	w.Scan()
+	watchtest.ExpectUpdated(t, a, 1)
+	if paths, _, _ := w.Peek(); len(paths) != 0 {
+		t.Errorf("expected no changes, got %v", paths)
+	}
+}
+
+func TestAppearNode(t *testing.T) {
+	fsys := new(watchtest.FS)
+	w := &watch.Watcher{FS: fsys}
+	calls := map[string]int{}
+	appear := func(path string, existing bool) *watch.AppearNode {
+		n := &watch.AppearNode{Watcher: w, Path: path, Existing: existing, Appeared: func() error {
+			calls[path]++
+			return nil
+		}}
+		w.Register(n)
+		return n
+	}
+	sock := appear("server.sock", false)
+	fsys.WriteFile("before.txt", nil)
+	appear("before.txt", false)
+	// created between Register and the first Scan
+	appear("ready", true)
+	fsys.WriteFile("ready", nil)
+	w.Scan()
+	if calls["ready"] != 1 || calls["before.txt"] != 0 || calls["server.sock"] != 0 {
+		t.Errorf("only the Existing node should see its file appear on the first Scan, got %v", calls)
+	}
+
+	fsys.WriteFile("server.sock", nil)
+	fsys.Remove("before.txt")
+	w.Scan()
+	if calls["server.sock"] != 1 || !sock.Done() {
+		t.Errorf("the file should have appeared, got %v", calls)
+	}
+	fsys.Advance(time.Second)
+	fsys.WriteFile("server.sock", []byte("x"))
+	fsys.WriteFile("before.txt", nil)
+	w.Scan()
+	fsys.Remove("server.sock")
+	fsys.Remove("ready")
+	w.Scan()
+	fsys.WriteFile("server.sock", nil)
+	fsys.WriteFile("ready", nil)
+	w.Scan()
+	if want := map[string]int{"server.sock": 1, "ready": 1, "before.txt": 1}; !maps.Equal(calls, want) {
+		t.Errorf("each file should appear exactly once, got %v, want %v", calls, want)
+	}
+}
+
+// versionDetector only reports a change to the first line of a file.
+type versionDetector struct{ calls int }
+
+func (d *versionDetector) Fingerprint(path string, info fs.FileInfo, fsys fs.FS) (watch.Fingerprint, error) {
+	d.calls++
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	return line, nil
+}
+
+func (d *versionDetector) Changed(prev, cur watch.Fingerprint) bool {
+	return !bytes.Equal(prev, cur)
+}
+
+func TestDetector(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("app.cfg", []byte("v1\nfoo"))
+	d := new(versionDetector)
+	w := &watch.Watcher{FS: fsys, Detector: d}
+	n := watchtest.NewNode("app.cfg")
+	w.Register(n)
+	w.Scan()
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("app.cfg", []byte("v1\nbar"))
+	w.Scan()
+	watchtest.ExpectUpdated(t, n, 0)
+	w.Scan()
+	if d.calls != 2 {
+		t.Errorf("the Detector should only be called when the stat changes, got %d calls", d.calls)
+	}
+	fsys.Advance(time.Second)
+	fsys.WriteFile("app.cfg", []byte("v2\nbar"))
+	w.Scan()
+	watchtest.ExpectUpdated(t, n, 1)
+
+	// with DetectModTime, a change in size alone does not call the Detector
+	calls := d.calls
+	w.Detect = watch.DetectModTime
+	fsys.WriteFile("app.cfg", []byte("v2\nbarbaz"))
+	w.Scan()
+	if d.calls != calls {
+		t.Errorf("the Detector should not be called for a change in size with DetectModTime")
+	}
+
+	// the stock detectors behave like the Detection they mirror
+	for _, tc := range []struct {
+		d    watch.Detector
+		want int
+	}{
+		{watch.StatDetector{}, 2},
+		{watch.StatDetector{IgnoreSize: true}, 1},
+		{watch.HashDetector{}, 1},
+	} {
+		fsys := new(watchtest.FS)
+		fsys.WriteFile("a.txt", []byte("a"))
+		w := &watch.Watcher{FS: fsys, Detector: tc.d}
+		n := watchtest.NewNode("a.txt")
+		w.Register(n)
+		w.Scan()
+		fsys.WriteFile("a.txt", []byte("bb"))
+		w.Scan()
+		fsys.Advance(time.Second)
+		fsys.WriteFile("a.txt", []byte("bb"))
+		w.Scan()
+		if n.Updates() != tc.want {
+			t.Errorf("%T%+v: got %d updates, want %d", tc.d, tc.d, n.Updates(), tc.want)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	fsys.WriteFile("b.txt", nil)
+	w := &watch.Watcher{FS: fsys}
+	old := watchtest.NewNode("a.txt")
+	w.Register(old)
+	w.Scan()
+
+	// batches may be applied while another goroutine scans
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 50 {
+			w.Scan()
+		}
+	}()
+	a, b := watchtest.NewNode("a.txt"), watchtest.NewNode("b.txt")
+	err := w.Batch(func(tx *watch.Tx) {
+		tx.Register(a)
+		tx.RegisterWithOptions(b, watch.NodeOptions{RateLimit: time.Minute})
+		tx.Unregister(old)
+		tx.NotifyExisting(true)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if nodes := w.Nodes(); len(nodes) != 2 || nodes[0] != a || nodes[1] != b {
+		t.Errorf("unexpected nodes %v", nodes)
+	}
+	w.Scan()
+	watchtest.ExpectUpdated(t, a, 1)
+	watchtest.ExpectUpdated(t, b, 1)
+	watchtest.ExpectUpdated(t, old, 0)
+
+	w.Close()
+	if err := w.Batch(func(tx *watch.Tx) { tx.Register(old) }); err != watch.ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestDetectMode(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("hook.sh", []byte("#!/bin/sh"))
+	w := &watch.Watcher{FS: fsys}
+	n := &eventsNode{testNode: testNode{path: "hook.sh"}, w: w}
+	w.Register(n)
+	w.Scan()
+
+	fsys.Chmod("hook.sh", 0o755)
+	if updated, _ := w.Scan(); updated {
+		t.Error("a mode change should only be reported when DetectMode is set")
+	}
+
+	w.DetectMode = true
+	fsys.Chmod("hook.sh", 0o700)
+	if updated, _ := w.Scan(); !updated {
+		t.Fatal("a permission change should update the node")
+	}
+	if want := []watch.Event{{Op: watch.Chmod, Path: "hook.sh"}}; !slices.Equal(n.events, want) {
+		t.Errorf("events should be %v, got %v", want, n.events)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("hook.sh", []byte("#!/bin/bash"))
+	fsys.Chmod("hook.sh", 0o755)
+	w.Scan()
+	if want := []watch.Event{{Op: watch.Write, Path: "hook.sh"}}; !slices.Equal(n.events, want) {
+		t.Errorf("a write with a mode change should be a Write, got %v", n.events)
+	}
+
+	// modes survive SaveState and LoadState
+	var buf bytes.Buffer
+	if err := w.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	w = &watch.Watcher{FS: fsys, DetectMode: true}
+	if err := w.LoadState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := &testNode{path: "hook.sh"}
+	w.Register(restored)
+	w.Scan()
+	if restored.updated != 0 {
+		t.Errorf("an unchanged mode should not be reported after LoadState, got %d updates", restored.updated)
+	}
+}
+
+func TestPathNormalizer(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"dir/foo.go": {ModTime: t0}}
+	w := &watch.Watcher{FS: fsys, PathNormalizer: watch.FoldCase}
+	a := &testNode{path: "dir/Foo.go"}
+	b := &testNode{path: "dir/../dir/./foo.go"}
+	w.Register(a)
+	w.Register(b)
+	w.Scan()
+	if got := w.Stats().Paths; got != 1 {
+		t.Errorf("both spellings should be watched as one path, got %d paths", got)
+	}
+
+	fsys["dir/foo.go"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+	if a.updated != 1 || b.updated != 1 {
+		t.Errorf("both nodes should be updated once, got %d and %d", a.updated, b.updated)
+	}
+	if got, want := w.ChangedPaths(a), []string{"dir/foo.go"}; !slices.Equal(got, want) {
+		t.Errorf("changed paths should be normalized to %v, got %v", want, got)
+	}
+
+	if got := watch.CleanPath(`a/./b/../c.txt`); got != "a/c.txt" {
+		t.Errorf("CleanPath should clean paths, got %q", got)
+	}
+	if got := watch.CleanPath("//server/share/a/../b.txt"); got != "//server/share/b.txt" {
+		t.Errorf("CleanPath should keep the prefix of UNC paths, got %q", got)
+	}
+}
+
+// flakyFS fails the next fails calls to Stat.
+type flakyFS struct {
+	fstest.MapFS
+	fails int
+}
+
+func (f *flakyFS) Stat(name string) (fs.FileInfo, error) {
+	if f.fails > 0 {
+		f.fails--
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: errors.New("stale file handle")}
+	}
+	return f.MapFS.Stat(name)
+}
+
+func TestRetry(t *testing.T) {
+	t0 := time.Now()
+	fsys := &flakyFS{MapFS: fstest.MapFS{"a.txt": {ModTime: t0}}}
+	w := &watch.Watcher{FS: fsys, Strict: true, Retry: watch.RetryPolicy{Attempts: 2, Failures: 3}}
+	n := &testNode{path: "a.txt"}
+	w.Register(n)
+	w.Scan()
+
+	fsys.fails = 2
+	if updated, errs := w.Scan(); updated || len(errs) > 0 {
+		t.Errorf("retried stats should succeed, got %v, %v", updated, errs)
+	}
+
+	for i := 1; i <= 3; i++ {
+		fsys.fails = 3
+		_, errs := w.Scan()
+		if i < 3 && len(errs) > 0 {
+			t.Errorf("failure %d should not be reported yet, got %v", i, errs)
+		}
+		if i == 3 && len(errs) != 1 {
+			t.Errorf("failure %d should be reported, got %v", i, errs)
+		}
+	}
+	if n.updated != 0 {
+		t.Errorf("failing stats should not update the node, got %d", n.updated)
+	}
+
+	fsys.MapFS["a.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	if updated, errs := w.Scan(); !updated || len(errs) > 0 {
+		t.Errorf("changes should be detected once stats succeed, got %v, %v", updated, errs)
+	}
+	fsys.fails = 3
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Errorf("a success should reset the failure count, got %v", errs)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	clock := new(watchtest.Clock)
+	assets := &watchtest.FS{Clock: clock}
+	configs := &watchtest.FS{Clock: clock}
+	assets.WriteFile("a.css", []byte("a"))
+	configs.WriteFile("c.json", []byte("c"))
+	wa := &watch.Watcher{FS: assets, Clock: clock}
+	wc := &watch.Watcher{FS: configs, Clock: clock, Debounce: time.Millisecond}
+	na, nc := &testNode{path: "a.css"}, &testNode{path: "c.json"}
+	wa.Register(na)
+	wc.Register(nc)
+
+	g := &watch.Group{Parallel: true, Debounce: time.Second, Clock: clock}
+	if err := g.Add(wa, wc); err != nil {
+		t.Fatal(err)
+	}
+	g.Scan()
+
+	clock.Advance(time.Millisecond)
+	assets.WriteFile("a.css", []byte("aa"))
+	configs.WriteFile("c.json", []byte("cc"))
+	g.Scan()
+	clock.Advance(time.Millisecond)
+	if updated, errs := g.Scan(); !updated || len(errs) > 0 {
+		t.Errorf("group should be updated, got %v, %v", updated, errs)
+	}
+	if na.updated != 0 || nc.updated != 1 {
+		t.Errorf("the group's Debounce should only apply to watchers without their own, got %d and %d", na.updated, nc.updated)
+	}
+	clock.Advance(time.Second)
+	g.Scan()
+	if na.updated != 1 {
+		t.Errorf("debounced node should be updated, got %d", na.updated)
+	}
+
+	// Run stops notifying once its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w1, w2 := &watch.Watcher{FS: assets}, &watch.Watcher{FS: configs}
+	w1.Register(watch.NodeFunc(func() []string { return []string{"a.css"} }, func() error {
+		cancel()
+		return nil
+	}))
+	n2 := &testNode{path: "c.json"}
+	w2.Register(n2)
+	g2 := new(watch.Group)
+	g2.Add(w1, w2)
+	g2.Scan()
+	clock.Advance(time.Second)
+	assets.WriteFile("a.css", []byte("aaa"))
+	configs.WriteFile("c.json", []byte("ccc"))
+	if err := g2.Run(ctx, time.Minute, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run should return the context's error, got %v", err)
+	}
+	if n2.updated != 0 {
+		t.Errorf("the watchers scanned after ctx is done should hold their nodes, got %d updates", n2.updated)
+	}
+
+	done := make(chan error)
+	go func() { done <- g.Run(context.Background(), time.Minute, nil) }()
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; !errors.Is(err, watch.ErrClosed) {
+		t.Errorf("Run should return ErrClosed, got %v", err)
+	}
+	if err := wa.Register(na); !errors.Is(err, watch.ErrClosed) {
+		t.Errorf("Close should close the watchers, got %v", err)
+	}
+	if _, errs := g.Scan(); len(errs) != 1 || !errors.Is(errs[0], watch.ErrClosed) {
+		t.Errorf("Scan should return ErrClosed after Close, got %v", errs)
+	}
+}
+
+func TestTypedWatcher(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"a.txt": {ModTime: t0}, "b.txt": {ModTime: t0}}
+	tw := &watch.TypedWatcher[*errNode]{}
+	tw.FS = fsys
+	a := &errNode{testNode: testNode{path: "a.txt"}, err: errors.New("build failed")}
+	b := &errNode{testNode: testNode{path: "b.txt"}}
+	tw.Register(a)
+	tw.Register(b)
+	if got := tw.Nodes(); !slices.Equal(got, []*errNode{a, b}) {
+		t.Errorf("nodes should be %v, got %v", []*errNode{a, b}, got)
+	}
+	tw.Scan()
+
+	fsys["a.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	_, errs := tw.Scan()
+	if len(errs) != 1 {
+		t.Fatalf("scan should return one error, got %v", errs)
+	}
+	if n, ok := tw.NodeOf(errs[0]); !ok || n != a {
+		t.Errorf("error should be attributed to a, got %v, %v", n, ok)
+	}
+	if _, ok := tw.NodeOf(errors.New("other")); ok {
+		t.Error("errors without a node should not be attributed")
+	}
+
+	tw.Unregister(a)
+	if got := tw.Nodes(); !slices.Equal(got, []*errNode{b}) {
+		t.Errorf("nodes should be [b] after Unregister, got %v", got)
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"a.txt": {ModTime: t0}, "b.txt": {ModTime: t0}}
+	w := &watch.Watcher{FS: fsys}
+	a := &testNode{path: "a.txt", deps: []string{"shared.txt"}}
+	b := &testNode{path: "b.txt", deps: []string{"shared.txt"}}
+	w.Register(a)
+	w.Register(b)
+	if got := w.Nodes(); !slices.Equal(got, []watch.Node{a, b}) {
+		t.Errorf("nodes should be [a b], got %v", got)
+	}
+	if got := w.Paths(); len(got) != 0 {
+		t.Errorf("no paths should be watched before Scan, got %v", got)
+	}
+	w.Scan()
+	if got, want := w.Paths(), []string{"a.txt", "b.txt", "shared.txt"}; !slices.Equal(got, want) {
+		t.Errorf("paths should be %v, got %v", want, got)
+	}
+	if got := w.NodesForPath("shared.txt"); !slices.Equal(got, []watch.Node{a, b}) {
+		t.Errorf("shared.txt should be referenced by [a b], got %v", got)
+	}
+	w.Unregister(a)
+	if got := w.NodesForPath("shared.txt"); !slices.Equal(got, []watch.Node{b}) {
+		t.Errorf("unregistered nodes should not be listed, got %v", got)
+	}
+	if got := w.NodesForPath("missing.txt"); got != nil {
+		t.Errorf("unwatched paths should have no nodes, got %v", got)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"a.txt": {ModTime: t0}}
+	locked := true
+	var log []string
+	w := &watch.Watcher{FS: fsys, Hooks: watch.Hooks{
+		BeforeScan: func() { log = append(log, "before scan") },
+		AfterScan: func(s watch.ScanStats) {
+			log = append(log, fmt.Sprintf("after scan: %d notified", s.Notified))
+		},
+		BeforeUpdate: func(node watch.Node, paths []string) bool {
+			log = append(log, fmt.Sprintf("before update: %v", paths))
+			return !locked
+		},
+		AfterUpdate: func(node watch.Node, paths []string, err error) {
+			log = append(log, fmt.Sprintf("after update: %v, %v", paths, err))
+		},
+	}}
+	n := &testNode{path: "a.txt"}
+	w.Register(n)
+	w.Scan()
+
+	fsys["a.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+	if n.updated != 0 {
+		t.Errorf("vetoed node should not be updated, got %d", n.updated)
+	}
+	locked = false
+	w.Scan()
+	if n.updated != 1 {
+		t.Errorf("vetoed node should be updated by the next scan, got %d", n.updated)
+	}
+	want := []string{
+		"before scan", "after scan: 0 notified",
+		"before scan", "before update: [a.txt]", "after scan: 0 notified",
+		"before scan", "before update: [a.txt]", "after update: [a.txt], <nil>", "after scan: 1 notified",
+	}
+	if !slices.Equal(log, want) {
+		t.Errorf("hooks should be called as\n%q\ngot\n%q", want, log)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	clock := new(watchtest.Clock)
+	fsys := &watchtest.FS{Clock: clock}
+	fsys.WriteFile("app.log", []byte{})
+	fsys.WriteFile("main.go", []byte{})
+	w := &watch.Watcher{FS: fsys, Clock: clock}
+	n := &changedNode{testNode: testNode{path: "main.go", deps: []string{"app.log"}}, w: w}
+	w.RegisterWithOptions(n, watch.NodeOptions{RateLimit: time.Second})
+	w.Scan()
+
+	for i := 0; i < 10; i++ {
+		clock.Advance(100 * time.Millisecond)
+		fsys.WriteFile("app.log", []byte(fmt.Sprint(i)))
+		w.Scan()
+	}
+	if n.updated != 1 {
+		t.Errorf("node should be updated once within the rate limit, got %d", n.updated)
+	}
+	clock.Advance(100 * time.Millisecond)
+	fsys.WriteFile("main.go", []byte("package main"))
+	if updated, _ := w.Scan(); !updated {
+		t.Error("scan should update the node once the rate limit has passed")
+	}
+	if n.updated != 2 {
+		t.Errorf("excess changes should be coalesced into one update, got %d", n.updated)
+	}
+	if want := []string{"app.log", "main.go"}; !slices.Equal(n.changed, want) {
+		t.Errorf("coalesced changed paths should be %v, got %v", want, n.changed)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", []byte("a"))
+	var log bytes.Buffer
+	j := &watch.Journal{Limit: 2, Writer: &log}
+	w := &watch.Watcher{FS: fsys, Journal: j}
+	n := &eventsNode{testNode: testNode{path: "a.txt", deps: []string{"b.txt"}}, w: w}
+	w.Register(n)
+	w.Scan()
+
+	fsys.Rename("a.txt", "b.txt")
+	w.Scan()
+	rename := watch.Event{Op: watch.Rename, Path: "b.txt", OldPath: "a.txt", Seq: 1}
+	if !slices.Equal(n.events, []watch.Event{rename}) {
+		t.Errorf("node events should carry sequence numbers, got %v", n.events)
+	}
+	fsys.WriteFile("a.txt", []byte("a"))
+	w.Scan()
+	fsys.Remove("b.txt")
+	w.Scan()
+
+	if got := j.Seq(); got != 3 {
+		t.Errorf("journal should have 3 events, got %d", got)
+	}
+	want := []watch.Event{{Op: watch.Create, Path: "a.txt", Seq: 2}, {Op: watch.Remove, Path: "b.txt", Seq: 3}}
+	if got := j.Since(1); !slices.Equal(got, want) {
+		t.Errorf("events since 1 should be %v, got %v", want, got)
+	}
+	if !j.Covers(1) || j.Covers(0) {
+		t.Error("journal should cover sequence 1 but not 0, which was dropped")
+	}
+	if j.Covers(4) {
+		t.Error("journal should not cover sequence numbers it has not reached")
+	}
+
+	restored := new(watch.Journal)
+	if err := restored.Load(&log); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Since(0); len(got) != 3 || got[0] != rename {
+		t.Errorf("restored journal should have every event written, got %v", got)
+	}
+}
+
+func TestRegisterFS(t *testing.T) {
+	t0 := time.Now()
+	base := fstest.MapFS{"index.html": {ModTime: t0}}
+	overlay := fstest.MapFS{"index.html": {ModTime: t0}}
+	w := &watch.Watcher{FS: base}
+	a := &testNode{path: "index.html"}
+	b := &testNode{path: "index.html"}
+	c := &testNode{path: "index.html"}
+	w.Register(a)
+	w.RegisterFS(overlay, b)
+	w.RegisterFS(overlay, c)
+	w.Scan()
+	if got := w.Stats().Paths; got != 2 {
+		t.Errorf("the path should be tracked once per file system, got %d paths", got)
+	}
+
+	overlay["index.html"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+	if a.updated != 0 || b.updated != 1 || c.updated != 1 {
+		t.Errorf("only the overlay nodes should be updated, got %d, %d and %d", a.updated, b.updated, c.updated)
+	}
+	if got := w.NodesForPath("index.html"); !slices.Equal(got, []watch.Node{a, b, c}) {
+		t.Errorf("nodes for the path should include every file system, got %v", got)
+	}
+
+	base["index.html"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.ScanPaths("index.html")
+	if a.updated != 1 || b.updated != 1 {
+		t.Errorf("ScanPaths should check the path in every file system, got %d and %d", a.updated, b.updated)
+	}
+}
+
+func TestScanResult(t *testing.T) {
+	clock := new(watchtest.Clock)
+	fsys := &watchtest.FS{Clock: clock}
+	fsys.WriteFile("a.txt", []byte("a"))
+	fsys.WriteFile("b.txt", []byte("b"))
+	w := &watch.Watcher{FS: fsys, Clock: clock, Hooks: watch.Hooks{
+		BeforeScan: func() { clock.Advance(time.Millisecond) },
+	}}
+	a := &testNode{path: "a.txt"}
+	b := &testNode{path: "b.txt"}
+	w.Register(a)
+	w.Register(b)
+	w.Scan()
+
+	fsys.WriteFile("a.txt", []byte("aa"))
+	fsys.Remove("b.txt")
+	r := w.ScanResult(context.Background())
+	if !r.Updated || len(r.Errors) > 0 || r.StatErrors != 0 {
+		t.Errorf("scan should update without errors, got %v, %v", r.Updated, r.Errors)
+	}
+	if want := []string{"a.txt", "b.txt"}; !slices.Equal(r.Changed, want) {
+		t.Errorf("changed paths should be %v, got %v", want, r.Changed)
+	}
+	if want := []string{"b.txt"}; !slices.Equal(r.Deleted, want) {
+		t.Errorf("deleted paths should be %v, got %v", want, r.Deleted)
+	}
+	if want := []watch.Node{a, b}; !slices.Equal(r.Notified, want) {
+		t.Errorf("notified nodes should be %v, got %v", want, r.Notified)
+	}
+	if r.Duration != time.Millisecond {
+		t.Errorf("duration should be measured with the clock, got %v", r.Duration)
+	}
+}
+
+type ctxNode struct {
+	testNode
+	name     string
+	priority int
+	started  chan string
+	release  chan struct{}
+
+	mu        sync.Mutex
+	cancelled int
+	finished  int
+}
+
+func newCtxNode(name string, started chan string) *ctxNode {
+	return &ctxNode{testNode: testNode{path: name}, name: name, started: started, release: make(chan struct{})}
+}
+
+func (cn *ctxNode) Priority() int { return cn.priority }
+
+func (cn *ctxNode) UpdatedContext(ctx context.Context) error {
+	cn.started <- cn.name
+	select {
+	case <-ctx.Done():
+		cn.mu.Lock()
+		cn.cancelled++
+		cn.mu.Unlock()
+		return ctx.Err()
+	case <-cn.release:
+		cn.mu.Lock()
+		cn.finished++
+		cn.mu.Unlock()
+		return nil
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	t.Run("restarts stale updates", func(t *testing.T) {
+		started := make(chan string, 10)
+		n := newCtxNode("a.txt", started)
+		s := new(watch.Scheduler)
+		wrapped := s.Wrap(n)
+		wrapped.Updated()
+		<-started
+		wrapped.Updated()
+		<-started
+		close(n.release)
+		s.Wait()
+		if n.cancelled != 1 || n.finished != 1 {
+			t.Errorf("stale update should be cancelled and restarted, got %d cancelled and %d finished", n.cancelled, n.finished)
+		}
+	})
+
+	t.Run("starts queued updates by priority", func(t *testing.T) {
+		started := make(chan string, 10)
+		busy := newCtxNode("busy", started)
+		low := newCtxNode("low", started)
+		high := newCtxNode("high", started)
+		high.priority = 1
+		close(low.release)
+		close(high.release)
+		var errs []error
+		s := &watch.Scheduler{Workers: 1, OnError: func(err error) { errs = append(errs, err) }}
+		s.Wrap(busy).Updated()
+		<-started
+		s.Wrap(low).Updated()
+		s.Wrap(high).Updated()
+		close(busy.release)
+		s.Wait()
+		close(started)
+		var order []string
+		for name := range started {
+			order = append(order, name)
+		}
+		if want := []string{"high", "low"}; !slices.Equal(order, want) {
+			t.Errorf("queued updates should start in order %v, got %v", want, order)
+		}
+		if len(errs) > 0 {
+			t.Errorf("no errors should be reported, got %v", errs)
+		}
+	})
+
+	t.Run("Close cancels updates", func(t *testing.T) {
+		started := make(chan string, 10)
+		n := newCtxNode("a.txt", started)
+		s := new(watch.Scheduler)
+		s.Wrap(n).Updated()
+		<-started
+		s.Close()
+		s.Wrap(n).Updated()
+		if n.cancelled != 1 || len(started) != 0 {
+			t.Errorf("Close should cancel the running update and ignore later ones, got %d cancelled, %d started", n.cancelled, len(started))
+		}
+	})
+}
+
+func TestContextNode(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"a.txt": {ModTime: t0}}
+	w := &watch.Watcher{FS: fsys}
+	started := make(chan string, 1)
+	n := newCtxNode("a.txt", started)
+	close(n.release)
+	w.Register(n)
+	w.Scan()
+	fsys["a.txt"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+	if n.finished != 1 || n.updated != 0 {
+		t.Errorf("UpdatedContext should be called instead of Updated, got %d and %d", n.finished, n.updated)
+	}
+}
+
+func TestUpdateAllAbsorbs(t *testing.T) {
+	for _, absorbs := range []bool{false, true} {
+		fsys := new(watchtest.FS)
+		fsys.WriteFile("a.txt", nil)
+		fsys.WriteFile("b.txt", nil)
+		clock := new(watchtest.Clock)
+		w := &watch.Watcher{FS: fsys, Clock: clock, UpdateAllAbsorbs: absorbs}
+		a := watchtest.NewNode("a.txt")
+		b := watchtest.NewNode("b.txt")
+		w.Register(a)
+		w.RegisterWithOptions(b, watch.NodeOptions{Debounce: time.Second})
+		w.Scan()
+
+		// b's change is waiting for its debounce period when UpdateAll runs
+		fsys.Advance(time.Second)
+		fsys.Touch("b.txt")
+		w.Scan()
+		fsys.Touch("a.txt")
+		if errs := w.UpdateAll(); errs != nil {
+			t.Fatal(errs)
+		}
+		clock.Advance(time.Second)
+		w.Scan()
+		want := 1
+		if !absorbs {
+			want = 2
+		}
+		if a.Updates() != want || b.Updates() != want {
+			t.Errorf("UpdateAllAbsorbs %v: got %d and %d updates, want %d", absorbs, a.Updates(), b.Updates(), want)
+		}
+	}
+}
+
+// reasonNode records the reasons it is updated with.
+type reasonNode struct {
+	paths   []string
+	reasons []watch.Reason
+}
+
+func (n *reasonNode) Paths() []string { return n.paths }
+
+func (n *reasonNode) Updated() error { return errors.New("Updated should not be called") }
+
+func (n *reasonNode) UpdatedReason(ctx context.Context, reason watch.Reason) error {
+	n.reasons = append(n.reasons, reason)
+	return nil
+}
+
+func TestReasonNode(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	w := &watch.Watcher{FS: fsys}
+	a := &reasonNode{paths: []string{"a.txt"}}
+	b := &reasonNode{}
+	w.Register(a)
+	w.Register(b)
+	w.AddDependency(b, a)
+	w.Scan()
+
+	fsys.Advance(time.Second)
+	fsys.Touch("a.txt")
+	if _, errs := w.Scan(); errs != nil {
+		t.Fatal(errs)
+	}
+	if errs := w.UpdateAll(); errs != nil {
+		t.Fatal(errs)
+	}
+	if want := []watch.Reason{watch.ReasonChange, watch.ReasonForced}; !slices.Equal(a.reasons, want) {
+		t.Errorf("got reasons %v, want %v", a.reasons, want)
+	}
+	if want := []watch.Reason{watch.ReasonDependency, watch.ReasonForced}; !slices.Equal(b.reasons, want) {
+		t.Errorf("got reasons %v for the dependent, want %v", b.reasons, want)
+	}
+
+	// a Scheduler passes the reason on
+	var s watch.Scheduler
+	defer s.Close()
+	c := &reasonCtxNode{reasonNode: reasonNode{paths: []string{"c.txt"}}}
+	w.Register(s.Wrap(c))
+	w.UpdateAll()
+	s.Wait()
+	if want := []watch.Reason{watch.ReasonForced}; !slices.Equal(c.reasons, want) {
+		t.Errorf("got reasons %v through the Scheduler, want %v", c.reasons, want)
+	}
+}
+
+type reasonCtxNode struct {
+	reasonNode
+}
+
+func (n *reasonCtxNode) UpdatedContext(ctx context.Context) error {
+	return errors.New("UpdatedContext should not be called")
+}
+
+// tickClock is a Clock whose tickers only tick when the test sends on them.
+// Every ticker created is sent on tickers.
+type tickClock struct {
+	*watchtest.Clock
+	tickers chan *manualTicker
+}
+
+type manualTicker struct {
+	d time.Duration
+	c chan time.Time
+}
+
+func (c tickClock) NewTicker(d time.Duration) watch.Ticker {
+	t := &manualTicker{d: d, c: make(chan time.Time)}
+	c.tickers <- t
+	return t
+}
+
+func (t *manualTicker) C() <-chan time.Time { return t.c }
+
+func (t *manualTicker) Stop() {}
+
+func TestAdaptiveInterval(t *testing.T) {
+	clock := tickClock{Clock: new(watchtest.Clock), tickers: make(chan *manualTicker)}
+	fsys := &watchtest.FS{Clock: clock.Clock}
+	fsys.WriteFile("a.txt", nil)
+	w := &watch.Watcher{FS: fsys, Clock: clock, Adaptive: watch.AdaptiveInterval{Max: 4 * time.Second, Idle: 2 * time.Second}}
+	w.Register(&testNode{path: "a.txt"})
+	var seen []time.Duration
+	w.Hooks.BeforeScan = func() { seen = append(seen, w.Stats().Interval) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx, time.Second, nil) }()
+	cur := <-clock.tickers
+	created := []time.Duration{cur.d}
+	// tick advances the clock by d and ticks the current ticker, picking up
+	// the new ticker instead if Run replaced it after the previous scan.
+	tick := func(d time.Duration) {
+		clock.Advance(d)
+		select {
+		case cur.c <- clock.Now():
+			return
+		case cur = <-clock.tickers:
+			created = append(created, cur.d)
+		}
+		cur.c <- clock.Now()
+	}
+	tick(time.Second)     // t=1s, not idle yet
+	tick(time.Second)     // t=2s, idle: back off to 2s
+	tick(2 * time.Second) // t=4s, 4s
+	tick(4 * time.Second) // t=8s, capped at Max
+	fsys.WriteFile("a.txt", []byte("a"))
+	tick(4 * time.Second) // t=12s, change: back to the minimum
+	tick(time.Second)
+	cancel()
+	<-done
+
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, time.Second}; !slices.Equal(created, want) {
+		t.Errorf("tickers should have intervals %v, got %v", want, created)
+	}
+	want := []time.Duration{time.Second, time.Second, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second, time.Second}
+	if !slices.Equal(seen, want) {
+		t.Errorf("Stats.Interval before each scan should be %v, got %v", want, seen)
+	}
+	if got := w.Stats().Interval; got != 0 {
+		t.Errorf("Stats.Interval should be reset after Run, got %v", got)
+	}
+}
+
+type lifecycleNode struct {
+	testNode
+	w        *watch.Watcher
+	attached int
+	detached int
+}
+
+func (n *lifecycleNode) Attached(w *watch.Watcher) {
+	n.w = w
+	n.attached++
+}
+
+func (n *lifecycleNode) Detached() {
+	n.detached++
+}
+
+func TestLifecycle(t *testing.T) {
+	w := &watch.Watcher{FS: fstest.MapFS{}}
+	a := &lifecycleNode{testNode: testNode{path: "a.txt"}}
+	b := &lifecycleNode{testNode: testNode{path: "b.txt"}}
+	w.Register(a)
+	w.RegisterWithOptions(a, watch.NodeOptions{Debounce: time.Second})
+	if a.attached != 1 || a.w != w {
+		t.Errorf("Attached should be called once with the watcher, got %d calls", a.attached)
+	}
+	w.Unregister(a)
+	w.Unregister(a)
+	if a.detached != 1 {
+		t.Errorf("Detached should be called once, got %d calls", a.detached)
+	}
+
+	w.Register(b)
+	w.Close()
+	if b.attached != 1 || b.detached != 1 {
+		t.Errorf("Close should detach registered nodes, got %d attached and %d detached", b.attached, b.detached)
+	}
+	if a.detached != 1 {
+		t.Errorf("Close should not detach unregistered nodes, got %d", a.detached)
+	}
+}
+
+func TestStatCache(t *testing.T) {
+	clock := new(watchtest.Clock)
+	fsys := &watchtest.FS{Clock: clock}
+	fsys.WriteFile("shared.h", []byte("a"))
+	fsys.WriteFile("a.c", []byte("a"))
+	wa := &watch.Watcher{FS: fsys, Clock: clock}
+	wb := &watch.Watcher{FS: fsys, Clock: clock}
+	na := &testNode{path: "a.c", deps: []string{"shared.h"}}
+	nb := &testNode{path: "shared.h"}
+	wa.Register(na)
+	wb.Register(nb)
+
+	g := &watch.Group{StatCache: new(watch.StatCache)}
+	g.Add(wa, wb)
+	g.Scan()
+	if hits, misses := g.StatCache.Stats(); hits != 1 || misses != 2 {
+		t.Errorf("shared.h should be statted once per scan, got %d hits and %d misses", hits, misses)
+	}
+	clock.Advance(time.Second)
+	fsys.WriteFile("shared.h", []byte("b"))
+	g.Scan()
+	if na.updated != 1 || nb.updated != 1 {
+		t.Errorf("a reset cache should see the change in both watchers, got %d and %d", na.updated, nb.updated)
+	}
+
+	// a TTL shares results across scans until it expires
+	cache := &watch.StatCache{TTL: time.Second, Clock: clock}
+	wa.StatCache, wb.StatCache = cache, cache
+	wa.Scan()
+	clock.Advance(time.Millisecond)
+	fsys.WriteFile("shared.h", []byte("c"))
+	wb.Scan()
+	if nb.updated != 1 {
+		t.Errorf("a cached stat should hide the change until the TTL passes, got %d updates", nb.updated)
+	}
+	clock.Advance(time.Second)
+	wb.Scan()
+	if nb.updated != 2 {
+		t.Errorf("the change should be seen once the TTL passes, got %d updates", nb.updated)
+	}
+	clock.Advance(time.Millisecond)
+	fsys.WriteFile("shared.h", []byte("d"))
+	cache.Forget("a.c", "shared.h")
+	wb.Scan()
+	if nb.updated != 3 {
+		t.Errorf("a forgotten path should be statted again, got %d updates", nb.updated)
+	}
+
+	// without a TTL, the cache of a Watcher is valid for one scan
+	w := &watch.Watcher{FS: fsys, Clock: clock, StatCache: new(watch.StatCache)}
+	n := &testNode{path: "shared.h"}
+	w.Register(n)
+	w.Scan()
+	clock.Advance(time.Second)
+	fsys.WriteFile("shared.h", []byte("d"))
+	w.Scan()
+	if n.updated != 1 {
+		t.Errorf("the next scan should see the change, got %d updates", n.updated)
+	}
+}
+
+func TestStatMany(t *testing.T) {
+	fsys := new(watchtest.FS)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		fsys.WriteFile(name, []byte(name))
+	}
+	remote := &watchtest.RemoteFS{FS: fsys}
+	w := &watch.Watcher{FS: remote}
+	n := watchtest.NewNode("a.txt", "b.txt", "c.txt", "d.txt")
+	n.Watcher = w
+	w.Register(n)
+	if _, errs := w.Scan(); errs != nil {
+		t.Fatal(errs)
+	}
+	if got := remote.RoundTrips(); got != 1 {
+		t.Errorf("a scan should stat every path in one round trip, got %d", got)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", []byte("aa"))
+	fsys.Remove("b.txt")
+	fsys.WriteFile("d.txt", nil)
+	if _, errs := w.Scan(); errs != nil {
+		t.Fatal(errs)
+	}
+	// one more round trip reads the directory of b.txt to look for a rename
+	if got := remote.RoundTrips(); got != 3 {
+		t.Errorf("expected 3 round trips after the second scan, got %d", got)
+	}
+	watchtest.ExpectUpdated(t, n, 1)
+	want := []watch.Event{
+		{Op: watch.Write, Path: "a.txt"},
+		{Op: watch.Remove, Path: "b.txt"},
+		{Op: watch.Create, Path: "d.txt"},
+	}
+	if got := n.Events(); !slices.Equal(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+
+	// links are not followed by StatMany, so other modes stat one by one
+	w.Symlinks = watch.SymlinkLink
+	w.Scan()
+	if got := remote.RoundTrips() - 3; got != 4 {
+		t.Errorf("expected a round trip per path without SymlinkFollow, got %d", got)
+	}
+}
+
+type manifestNode struct {
+	*watchtest.Node
+	detached bool
+}
+
+func (n *manifestNode) Detached() { n.detached = true }
+
+func TestManifest(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.go", nil)
+	fsys.WriteFile("b.ts", nil)
+	fsys.WriteFile("watch.txt", []byte("# comment\n*.go @test -- go test\n\n*.ts -- npm run build\n"))
+	w := &watch.Watcher{FS: fsys}
+	nodes := map[string]*manifestNode{}
+	m := &watch.Manifest{Watcher: w, Path: "watch.txt", NewNode: func(e watch.ManifestEntry) (watch.Node, error) {
+		n := &manifestNode{Node: watchtest.NewNode()}
+		nodes[e.Patterns[0]] = n
+		return n, nil
+	}}
+	if err := m.Load(); err != nil {
+		t.Fatal(err)
+	}
+	w.Register(m)
+	w.Scan()
+	want := []watch.ManifestEntry{
+		{Patterns: []string{"*.go"}, Command: []string{"go", "test"}, Tags: []string{"test"}},
+		{Patterns: []string{"*.ts"}, Command: []string{"npm", "run", "build"}},
+	}
+	if got := m.Entries(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got entries %+v", got)
+	}
+	goNode, tsNode := nodes["*.go"], nodes["*.ts"]
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.go", nil)
+	w.Scan()
+	watchtest.ExpectUpdated(t, goNode.Node, 1)
+	watchtest.ExpectUpdated(t, tsNode.Node, 0)
+
+	// the unchanged entry keeps its node; the removed one is detached
+	fsys.Advance(time.Second)
+	fsys.WriteFile("watch.txt", []byte("*.go @test -- go test\n*.md -- make docs\n"))
+	fsys.WriteFile("c.md", nil)
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if nodes["*.go"] != goNode || !tsNode.detached || goNode.detached {
+		t.Errorf("only the removed entry should be replaced")
+	}
+	w.Scan() // the new entry's files are first seen
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.go", nil)
+	fsys.WriteFile("b.ts", nil)
+	fsys.WriteFile("c.md", nil)
+	w.Scan()
+	watchtest.ExpectUpdated(t, goNode.Node, 2)
+	watchtest.ExpectUpdated(t, tsNode.Node, 0)
+	watchtest.ExpectUpdated(t, nodes["*.md"].Node, 1)
+
+	// an invalid manifest keeps the previous configuration
+	fsys.Advance(time.Second)
+	fsys.WriteFile("watch.txt", []byte("@test -- go test\n"))
+	if _, errs := w.Scan(); len(errs) != 1 {
+		t.Errorf("expected the manifest's error, got %v", errs)
+	}
+	if len(m.Entries()) != 2 {
+		t.Errorf("expected the previous entries, got %+v", m.Entries())
+	}
+
+	// JSON manifests, with entries selected by tag
+	fsys.WriteFile("watch.json", []byte(`[{"patterns": ["*.go"], "command": ["go", "vet"], "tags": ["ci"]}, {"patterns": ["*.ts"], "command": ["tsc"]}]`))
+	w2 := &watch.Watcher{FS: fsys}
+	m2 := &watch.Manifest{Watcher: w2, Path: "watch.json", Tags: []string{"ci"}}
+	if err := m2.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := m2.Entries(); len(got) != 1 || !slices.Equal(got[0].Command, []string{"go", "vet"}) {
+		t.Errorf("got entries %+v", got)
+	}
+	fsys.WriteFile("watch.json", []byte(`[{"patterns": ["*.md"]}]`))
+	if err := (&watch.Manifest{Watcher: w2, Path: "watch.json"}).Load(); err == nil {
+		t.Error("an entry without a command should be rejected without NewNode")
+	}
+
+	// a registered Manifest is loaded by its first Scan
+	w3 := &watch.Watcher{FS: fsys}
+	m3 := &watch.Manifest{Watcher: w3, Path: "watch.json", NewNode: func(watch.ManifestEntry) (watch.Node, error) {
+		return watchtest.NewNode(), nil
+	}}
+	w3.Register(m3)
+	if _, errs := w3.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := m3.Entries(); len(got) != 1 || !slices.Equal(got[0].Patterns, []string{"*.md"}) {
+		t.Errorf("the first Scan should load the manifest, got entries %+v", got)
+	}
+}
+
+// sleepNode is a testNode whose updates take a while, so that they overlap
+// the updates of other nodes.
+type sleepNode struct{ testNode }
+
+func (n *sleepNode) Updated() error {
+	time.Sleep(5 * time.Millisecond)
+	return n.testNode.Updated()
+}
+
+func TestManifestConcurrent(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("watch.txt", []byte("*.go -- go test\n"))
+	w := &watch.Watcher{FS: fsys, Concurrency: 4}
+	m := &watch.Manifest{Watcher: w, Path: "watch.txt", NewNode: func(watch.ManifestEntry) (watch.Node, error) {
+		return watchtest.NewNode(), nil
+	}}
+	if err := m.Load(); err != nil {
+		t.Fatal(err)
+	}
+	w.Register(m)
+	var others []*sleepNode
+	for i := range 8 {
+		n := &sleepNode{testNode{path: fmt.Sprintf("f%d.txt", i)}}
+		fsys.WriteFile(n.path, nil)
+		others = append(others, n)
+		w.Register(n)
+	}
+	w.Scan()
+
+	// reloads made while other nodes are updated are applied after the scan
+	for i := range 5 {
+		fsys.Advance(time.Second)
+		fsys.WriteFile("watch.txt", []byte(fmt.Sprintf("*.go -- go test\n*.%d -- make\n", i)))
+		for _, n := range others {
+			fsys.WriteFile(n.path, nil)
+		}
+		if _, errs := w.Scan(); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if got := len(w.Nodes()); got != 1+len(others)+2 {
+			t.Fatalf("expected 2 entries to be registered, got %d nodes", got)
+		}
+	}
+	for _, n := range others {
+		if n.updated != 5 {
+			t.Errorf("%s: got %d updates, want 5", n.path, n.updated)
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("src/a.txt", []byte("abc"))
+	w := &watch.Watcher{FS: fsys}
+	w.Register(watch.Files([]string{"src/a.txt", "src/b.txt"}, func() error { return nil }))
+	if _, ok := w.Info("src/a.txt"); ok {
+		t.Error("paths should have no info before the first scan")
+	}
+	w.Scan()
+	info, ok := w.Info("src/a.txt")
+	if !ok || info.Name() != "a.txt" || info.Size() != 3 || info.IsDir() {
+		t.Fatalf("got %v, %v", info, ok)
+	}
+	modTime := info.ModTime()
+
+	// the info is that of the last scan, not the current file
+	fsys.Advance(time.Second)
+	fsys.WriteFile("src/a.txt", []byte("abcdef"))
+	if info, _ := w.Info("src/a.txt"); info.Size() != 3 || !info.ModTime().Equal(modTime) {
+		t.Errorf("expected the recorded info, got size %d", info.Size())
+	}
+	w.Scan()
+	if info, _ := w.Info("src/a.txt"); info.Size() != 6 || !info.ModTime().After(modTime) {
+		t.Errorf("expected the new info, got size %d and mod time %v", info.Size(), info.ModTime())
+	}
+	if _, ok := w.Info("src/b.txt"); ok {
+		t.Error("missing paths should have no info")
+	}
+	if _, ok := w.Info("other.txt"); ok {
+		t.Error("unwatched paths should have no info")
+	}
+}
+
+func TestRemoveGrace(t *testing.T) {
+	clock := new(watchtest.Clock)
+	fsys := &watchtest.FS{Clock: clock}
+	fsys.WriteFile("a.txt", []byte("a"))
+	w := &watch.Watcher{FS: fsys, Clock: clock, RemoveGrace: time.Second}
+	n := &watchtest.Node{Files: []string{"a.txt"}, Watcher: w}
+	w.Register(n)
+	w.Scan()
+
+	// a file recreated within the grace period is reported once, as a Write
+	fsys.Remove("a.txt")
+	w.Scan()
+	watchtest.ExpectUpdated(t, n, 0)
+	if _, ok := w.Info("a.txt"); !ok {
+		t.Error("a path missing within the grace period should keep its info")
+	}
+	clock.Advance(500 * time.Millisecond)
+	fsys.WriteFile("a.txt", []byte("ab"))
+	w.Scan()
+	watchtest.ExpectUpdated(t, n, 1)
+	if got := n.Events(); len(got) != 1 || got[0].Op != watch.Write {
+		t.Errorf("expected a single Write, got %v", got)
+	}
+
+	// one still missing after the grace period is reported as removed
+	fsys.Remove("a.txt")
+	w.Scan()
+	clock.Advance(500 * time.Millisecond)
+	w.Scan()
+	watchtest.ExpectUpdated(t, n, 1)
+	clock.Advance(500 * time.Millisecond)
+	w.Scan()
+	watchtest.ExpectUpdated(t, n, 2)
+	if got := n.Events(); len(got) != 1 || got[0].Op != watch.Remove {
+		t.Errorf("expected a Remove, got %v", got)
+	}
+	if _, ok := w.Info("a.txt"); ok {
+		t.Error("a removed path should have no info")
+	}
+	fsys.WriteFile("a.txt", nil)
+	w.Scan()
+	if got := n.Events(); len(got) != 1 || got[0].Op != watch.Create {
+		t.Errorf("expected a Create, got %v", got)
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	errDenied, errBuild := errors.New("denied"), errors.New("build failed")
+	fsys := failFS{
+		MapFS: fstest.MapFS{"a.txt": {}},
+		fail:  map[string]error{},
+	}
+	w := &watch.Watcher{FS: fsys}
+	n := &errNode{testNode{path: "a.txt", deps: []string{"b.txt"}}, errBuild}
+	w.Register(n)
+	if _, errs := w.Scan(); errs.Err() != nil {
+		t.Fatalf("expected a nil error, got %v", errs)
+	}
+	if got := fmt.Sprintf("%v%v", watch.ScanErrors(nil), watch.ScanErrors{}); got != "" {
+		t.Errorf("empty ScanErrors should format as empty, got %q", got)
+	}
+
+	fsys.fail["b.txt"] = errDenied
+	fsys.MapFS["a.txt"] = &fstest.MapFile{ModTime: time.Unix(1, 0)}
+	_, errs := w.Scan()
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	var err error = errs
+	if !errors.Is(err, errDenied) || !errors.Is(err, errBuild) {
+		t.Errorf("errors.Is should see every error, got %v", err)
+	}
+	var nerr *watch.NodeError
+	if !errors.As(err, &nerr) || nerr.Node != n || nerr.Path != "a.txt" {
+		t.Errorf("errors.As should find the *NodeError, got %v", err)
+	}
+	if stat := errs.StatErrors(); len(stat) != 1 || stat[0].Path != "b.txt" {
+		t.Errorf("got stat errors %v", stat)
+	}
+	if nodes := errs.NodeErrors(); len(nodes) != 1 || nodes[0].Node != n {
+		t.Errorf("got node errors %v", nodes)
+	}
+	if want := "watch: b.txt: denied\nwatch: *watch_test.errNode: a.txt: build failed"; err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+
+	g := new(watch.Group)
+	g.Add(w)
+	if _, errs := g.Scan(); len(errs.StatErrors()) != 1 {
+		t.Errorf("the group should return the watcher's errors, got %v", errs)
+	}
+}
+
+// slowFS advances its clock on every stat of the paths in slow.
+type slowFS struct {
+	*watchtest.FS
+	clock *watchtest.Clock
+	slow  map[string]time.Duration
+}
+
+func (f slowFS) Stat(name string) (fs.FileInfo, error) {
+	f.clock.Advance(f.slow[name])
+	return f.FS.Stat(name)
+}
+
+func TestProfile(t *testing.T) {
+	clock := new(watchtest.Clock)
+	fsys := slowFS{&watchtest.FS{Clock: clock}, clock, map[string]time.Duration{"mnt/slow.txt": 300 * time.Millisecond}}
+	fsys.WriteFile("a.txt", nil)
+	fsys.WriteFile("mnt/slow.txt", nil)
+	w := &watch.Watcher{FS: fsys, Clock: clock}
+	fast := watchtest.NewNode("a.txt")
+	slow := watchtest.NewNode("a.txt", "mnt/slow.txt")
+	build := watch.File("a.txt", func() error {
+		clock.Advance(time.Second)
+		return nil
+	})
+	w.Register(fast)
+	w.Register(slow)
+	w.Register(build)
+	w.Scan()
+	if w.ProfileReport() != nil {
+		t.Error("expected no report before profiling")
+	}
+
+	w.Profile(true)
+	w.Scan()
+	fsys.WriteFile("a.txt", nil)
+	w.Scan()
+	w.Profile(false)
+	w.Scan()
+
+	r := w.ProfileReport()
+	if r.Scans != 2 || r.Duration != 1600*time.Millisecond {
+		t.Errorf("got %d scans in %v", r.Scans, r.Duration)
+	}
+	if len(r.Paths) != 2 || r.Paths[0].Path != "mnt/slow.txt" || r.Paths[0].Stats != 2 || r.Paths[0].Time != 600*time.Millisecond || r.Paths[0].Max != 300*time.Millisecond {
+		t.Errorf("expected mnt/slow.txt to be the slowest path, got %+v", r.Paths)
+	}
+	if len(r.Nodes) != 3 || r.Nodes[0].Node != build || r.Nodes[0].Update != time.Second || r.Nodes[0].Updates != 1 {
+		t.Fatalf("expected the build node to be the slowest, got %+v", r.Nodes)
+	}
+	if n := r.Nodes[1]; n.Node != slow || n.Stat != 600*time.Millisecond || n.Paths != 2 {
+		t.Errorf("expected the node watching mnt/slow.txt next, got %+v", n)
+	}
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "mnt/slow.txt") || strings.Contains(out, " a.txt") || strings.Count(out, "\n") != 7 {
+		t.Errorf("expected the slowest path and node only, got\n%s", out)
+	}
+}
+
+// countFS counts the stats of each path.
+type countFS struct {
+	*watchtest.FS
+	mu    sync.Mutex
+	stats map[string]int
+}
+
+func (f *countFS) Stat(name string) (fs.FileInfo, error) {
+	f.mu.Lock()
+	f.stats[name]++
+	f.mu.Unlock()
+	return f.FS.Stat(name)
+}
+
+func (f *countFS) count(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stats[name]
+}
+
+func TestScope(t *testing.T) {
+	clock := new(watchtest.Clock)
+	fsys := &countFS{FS: &watchtest.FS{Clock: clock}, stats: map[string]int{}}
+	for _, p := range []string{"shared.h", "a/main.c", "a/x.c", "b/main.c"} {
+		fsys.WriteFile(p, nil)
+	}
+	// without a StatCache, w and its scopes share one for each scan
+	w := &watch.Watcher{FS: fsys, Clock: clock}
+	w.Ignore("*.o")
+	root := &testNode{path: "a/main.c"}
+	w.Register(root)
+	a := w.Scope("a")
+	b := w.Scope("./b/")
+	// paths outside a scope's prefix are not watched by the scope
+	na := &lifecycleNode{testNode: testNode{path: "a/main.c", deps: []string{"shared.h", "b/main.c", "a/main.o"}}}
+	nb := &testNode{path: "b/main.c"}
+	ga := watchtest.NewNode()
+	a.Register(na)
+	a.Register(&watch.GlobNode{Watcher: a, Patterns: []string{"**/*.c"}, Node: ga})
+	b.Register(nb)
+	if got := a.Nodes(); len(got) != 2 || len(w.Nodes()) != 1 {
+		t.Errorf("a scope should have its own nodes, got %d and %d", len(got), len(w.Nodes()))
+	}
+
+	w.Scan()
+	if n := fsys.count("a/main.c"); n != 1 {
+		t.Errorf("a/main.c should be statted once for w and its scope, got %d stats", n)
+	}
+	if got := a.Paths(); !slices.Equal(got, []string{"a", "a/main.c", "a/x.c"}) {
+		t.Errorf("got scope paths %v", got)
+	}
+
+	clock.Advance(time.Second)
+	fsys.WriteFile("a/main.c", nil)
+	fsys.WriteFile("b/main.c", nil)
+	fsys.WriteFile("shared.h", nil)
+	r := w.ScanResult(context.Background())
+	if !r.Updated || !slices.Equal(r.Changed, []string{"a/main.c", "b/main.c"}) || len(r.Notified) != 4 {
+		t.Errorf("expected the scopes to be scanned with w, got %+v", r)
+	}
+	if root.updated != 1 || na.updated != 1 || nb.updated != 1 {
+		t.Errorf("got %d, %d and %d updates", root.updated, na.updated, nb.updated)
+	}
+	if n := fsys.count("a/main.c"); n != 2 {
+		t.Errorf("each scan should stat a/main.c again, got %d stats", n)
+	}
+	watchtest.ExpectUpdated(t, ga, 1)
+
+	// a closed scope detaches its nodes and is no longer scanned
+	a.Close()
+	if na.detached != 1 || !slices.Equal(w.Scopes(), []*watch.Watcher{b}) {
+		t.Errorf("closing a scope should detach its nodes and remove it, got %d and %v", na.detached, w.Scopes())
+	}
+	clock.Advance(time.Second)
+	fsys.WriteFile("a/main.c", nil)
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if root.updated != 2 || na.updated != 1 {
+		t.Errorf("got %d and %d updates", root.updated, na.updated)
+	}
+
+	w.Close()
+	if len(w.Scopes()) != 0 || b.Register(nb) != watch.ErrClosed || w.Scope("c").Register(nb) != watch.ErrClosed {
+		t.Error("closing w should close its scopes")
+	}
+}
+
+func TestUpdateNode(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	w := &watch.Watcher{FS: fsys}
+	n := &blockingNode{testNode{path: "a.txt"}, make(chan struct{}), make(chan struct{})}
+	b := &testNode{path: "b.txt"}
+	failing := &errNode{testNode{path: "c.txt"}, errors.New("build failed")}
+	w.Register(n)
+	w.Register(b)
+	w.Register(failing)
+	w.Scan()
+
+	// UpdateNode waits for the updates of an in-progress Scan
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", []byte("a"))
+	go w.Scan()
+	<-n.started
+	done := make(chan watch.ScanErrors)
+	go func() { done <- w.UpdateNode(b) }()
+	select {
+	case <-done:
+		t.Fatal("UpdateNode should wait for the scan to finish")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(n.release)
+	if errs := <-done; len(errs) > 0 || b.updated != 1 {
+		t.Errorf("got %v and %d updates", errs, b.updated)
+	}
+
+	if errs := w.UpdateNode(failing); len(errs.NodeErrors()) != 1 {
+		t.Errorf("expected the node's error, got %v", errs)
+	}
+	if errs := w.UpdateNode(&testNode{path: "d.txt"}); errs != nil {
+		t.Errorf("an unregistered node should not be updated, got %v", errs)
+	}
 }
@@ -0,0 +1,180 @@
+// Package watcharchive provides an fs.FS over the members of a zip or tar
+// archive that follows the archive as it is replaced on disk, so that the
+// members of an asset bundle can be watched like ordinary files.
+package watcharchive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+// FS is an fs.StatFS serving the members of the archive at Path. Every
+// operation first stats the archive and, if its modification time or size
+// changed since it was last read, reads it again, so a watch.Watcher that
+// watches members through FS, by registering their nodes with RegisterFS,
+// sees the modification times and sizes of the members of the current
+// archive. Only the nodes watching members that changed are then notified,
+// and removing the archive reports all its members as removed.
+//
+// A zip archive is recognised by the extension .zip, a tar archive by .tar,
+// and a gzip compressed tar archive by .tar.gz or .tgz. The archive is read
+// into memory, and an archive that fails to read, for example because it is
+// still being written, is reported from every operation until it changes
+// again. An FS must not be copied after first use.
+//
+//	bundle := &watcharchive.FS{Path: "assets.zip"}
+//	w.RegisterFS(bundle, watch.File("textures/stone.png", reloadStone))
+type FS struct {
+	// Path is the path of the archive.
+	Path string
+
+	// FS is the file system containing the archive. If nil, the operating
+	// system's file system is used.
+	FS fs.FS
+
+	mu      sync.Mutex
+	loaded  bool
+	modTime time.Time
+	size    int64
+	members fs.FS
+	err     error
+}
+
+// ErrFormat is returned for an archive whose extension is not recognised.
+var ErrFormat = errors.New("watcharchive: unknown archive format")
+
+// Open implements fs.FS.
+func (f *FS) Open(name string) (fs.File, error) {
+	members, err := f.current()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return members.Open(name)
+}
+
+// Stat implements fs.StatFS.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	members, err := f.current()
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return fs.Stat(members, name)
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	members, err := f.current()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return fs.ReadDir(members, name)
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	members, err := f.current()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return fs.ReadFile(members, name)
+}
+
+// current returns the members of the archive, reading it again if it
+// changed since it was last read.
+func (f *FS) current() (fs.FS, error) {
+	info, err := f.stat()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		// read the archive again once it reappears
+		f.loaded, f.members = false, nil
+		return nil, err
+	}
+	if f.loaded && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.members, f.err
+	}
+	f.loaded, f.modTime, f.size = true, info.ModTime(), info.Size()
+	f.members, f.err = f.read()
+	return f.members, f.err
+}
+
+// read reads the archive.
+func (f *FS) read() (fs.FS, error) {
+	var data []byte
+	var err error
+	if f.FS == nil {
+		data, err = os.ReadFile(f.Path)
+	} else {
+		data, err = fs.ReadFile(f.FS, f.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(path.Base(strings.ReplaceAll(f.Path, `\`, "/")))
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("watcharchive: %s: %w", f.Path, err)
+		}
+		return r, nil
+	case strings.HasSuffix(name, ".tar"):
+		return f.readTar(bytes.NewReader(data))
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("watcharchive: %s: %w", f.Path, err)
+		}
+		return f.readTar(zr)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrFormat, f.Path)
+}
+
+// readTar reads the regular files and directories of a tar archive.
+func (f *FS) readTar(r io.Reader) (fs.FS, error) {
+	members := fstest.MapFS{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("watcharchive: %s: %w", f.Path, err)
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+		file := &fstest.MapFile{Mode: hdr.FileInfo().Mode(), ModTime: hdr.ModTime}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			if file.Data, err = io.ReadAll(tr); err != nil {
+				return nil, fmt.Errorf("watcharchive: %s: %w", f.Path, err)
+			}
+		case tar.TypeDir:
+		default:
+			continue
+		}
+		members[name] = file
+	}
+}
+
+func (f *FS) stat() (fs.FileInfo, error) {
+	if f.FS == nil {
+		return os.Stat(f.Path)
+	}
+	return fs.Stat(f.FS, f.Path)
+}
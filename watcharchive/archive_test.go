@@ -0,0 +1,119 @@
+package watcharchive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watcharchive"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+type member struct {
+	name, data string
+	modTime    time.Time
+}
+
+func zipArchive(t *testing.T, members ...member) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, m := range members {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: m.name, Modified: m.modTime, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(m.data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZip(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("assets.zip", zipArchive(t, member{"a.txt", "a", t0}, member{"dir/b.txt", "b", t0}))
+	bundle := &watcharchive.FS{Path: "assets.zip", FS: fsys}
+
+	var updated []string
+	w := &watch.Watcher{FS: fsys}
+	w.RegisterFS(bundle, watch.File("a.txt", func() error {
+		updated = append(updated, "a")
+		return nil
+	}))
+	w.RegisterFS(bundle, watch.File("dir/b.txt", func() error {
+		updated = append(updated, "b")
+		return nil
+	}))
+	if _, errs := w.Scan(); errs != nil {
+		t.Fatal(errs)
+	}
+	if data, err := fs.ReadFile(bundle, "dir/b.txt"); err != nil || string(data) != "b" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+
+	// only the node of the member that changed is notified
+	fsys.Advance(time.Second)
+	fsys.WriteFile("assets.zip", zipArchive(t, member{"a.txt", "aa", t0.Add(time.Minute)}, member{"dir/b.txt", "b", t0}))
+	if _, errs := w.Scan(); errs != nil {
+		t.Fatal(errs)
+	}
+	if len(updated) != 1 || updated[0] != "a" {
+		t.Errorf("changing a.txt should only notify its node, got %v", updated)
+	}
+
+	updated = nil
+	fsys.Remove("assets.zip")
+	w.Scan()
+	if len(updated) != 2 {
+		t.Errorf("removing the archive should notify both nodes, got %v", updated)
+	}
+	if _, err := bundle.Stat("a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("members of a removed archive should not exist, got %v", err)
+	}
+
+	fsys.WriteFile("assets.zip", []byte("truncated"))
+	if _, err := bundle.Stat("a.txt"); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("a corrupt archive should be reported, got %v", err)
+	}
+}
+
+func TestTarGz(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Name: "./shaders/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: t0})
+	tw.WriteHeader(&tar.Header{Name: "./shaders/lit.frag", Typeflag: tar.TypeReg, Mode: 0o644, Size: 4, ModTime: t0})
+	tw.Write([]byte("main"))
+	tw.Close()
+	zw.Close()
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("bundle.tgz", buf.Bytes())
+
+	bundle := &watcharchive.FS{Path: "bundle.tgz", FS: fsys}
+	info, err := bundle.Stat("shaders/lit.frag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 4 || !info.ModTime().Equal(t0) {
+		t.Errorf("got size %d and mod time %v", info.Size(), info.ModTime())
+	}
+	if entries, err := bundle.ReadDir("shaders"); err != nil || len(entries) != 1 {
+		t.Errorf("got entries %v, %v", entries, err)
+	}
+
+	other := &watcharchive.FS{Path: "bundle.rar", FS: fsys}
+	fsys.WriteFile("bundle.rar", nil)
+	if _, err := other.Stat("a"); !errors.Is(err, watcharchive.ErrFormat) {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+}
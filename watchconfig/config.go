@@ -0,0 +1,109 @@
+// Package watchconfig provides a watch.Node that hot-reloads a configuration
+// file into a typed value.
+package watchconfig
+
+import (
+	"io/fs"
+	"sync/atomic"
+
+	"github.com/chriscraws/watch"
+)
+
+// Config is a watch.Node that watches a configuration file and, whenever it
+// changes, decodes it into a new T and swaps it in atomically. If the file
+// cannot be read, decoded or validated, the previous value is kept and the
+// error is returned from Updated. The file is first loaded by the first Scan
+// after the Config is registered. A Config must not be copied after first
+// use.
+//
+//	cfg := &watchconfig.Config[Settings]{Path: "settings.json", Unmarshal: json.Unmarshal}
+//	w.Register(cfg)
+//	if _, errs := w.Scan(); errs != nil {
+//		log.Fatal(errs)
+//	}
+//	settings := cfg.Load()
+type Config[T any] struct {
+	// Path is the path of the configuration file.
+	Path string
+
+	// FS is the file system Path is read from, as by watch.ReadFile.
+	FS fs.FS
+
+	// Unmarshal decodes the file contents into v, which is a *T. Functions
+	// with the signature of json.Unmarshal, such as the Unmarshal functions
+	// of most YAML and TOML packages, can be used directly.
+	Unmarshal func(data []byte, v any) error
+
+	// Validate, if not nil, is called with each decoded value before it is
+	// swapped in. Returning an error rejects the value.
+	Validate func(*T) error
+
+	// OnChange, if not nil, is called with the previous and new values after
+	// a successful reload. The previous value is nil on the first reload.
+	OnChange func(old, new *T)
+
+	value atomic.Pointer[T]
+}
+
+// Load returns the most recently loaded value, or the zero value of T if the
+// file has not been loaded successfully yet. Load is safe to call
+// concurrently with reloads.
+func (c *Config[T]) Load() T {
+	if v := c.value.Load(); v != nil {
+		return *v
+	}
+	var zero T
+	return zero
+}
+
+// Reload reads, decodes and validates the file and swaps in the new value.
+func (c *Config[T]) Reload() error {
+	data, err := watch.ReadFile(c.FS, c.Path)
+	if err != nil {
+		return err
+	}
+	v := new(T)
+	if err := c.Unmarshal(data, v); err != nil {
+		return &Error{Path: c.Path, Err: err}
+	}
+	if c.Validate != nil {
+		if err := c.Validate(v); err != nil {
+			return &Error{Path: c.Path, Err: err}
+		}
+	}
+	old := c.value.Swap(v)
+	if c.OnChange != nil {
+		c.OnChange(old, v)
+	}
+	return nil
+}
+
+// Paths implements watch.Node.
+func (c *Config[T]) Paths() []string {
+	return []string{c.Path}
+}
+
+// NotifyExisting implements watch.ExistingNotifier by returning true.
+func (c *Config[T]) NotifyExisting() bool {
+	return true
+}
+
+// Updated implements watch.Node by calling Reload.
+func (c *Config[T]) Updated() error {
+	return c.Reload()
+}
+
+// Error is returned when a configuration file cannot be decoded or fails
+// validation.
+type Error struct {
+	Path string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return "watchconfig: " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
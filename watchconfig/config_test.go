@@ -0,0 +1,65 @@
+package watchconfig_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchconfig"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+type settings struct {
+	Port int `json:"port"`
+}
+
+func TestConfig(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("settings.json", []byte(`{"port": 80}`))
+	var changes int
+	cfg := &watchconfig.Config[settings]{
+		Path:      "settings.json",
+		FS:        fsys,
+		Unmarshal: json.Unmarshal,
+		Validate: func(s *settings) error {
+			if s.Port <= 0 {
+				return errors.New("port must be positive")
+			}
+			return nil
+		},
+		OnChange: func(old, new *settings) { changes++ },
+	}
+	w := &watch.Watcher{FS: fsys}
+	w.Register(cfg)
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := cfg.Load().Port; got != 80 {
+		t.Errorf("port should be 80, got %d", got)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("settings.json", []byte(`{"port": 8080}`))
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := cfg.Load().Port; got != 8080 {
+		t.Errorf("port should be 8080, got %d", got)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("settings.json", []byte(`{"port": -1}`))
+	_, errs := w.Scan()
+	var cerr *watchconfig.Error
+	if len(errs) != 1 || !errors.As(errs[0], &cerr) {
+		t.Errorf("expected a *watchconfig.Error, got %v", errs)
+	}
+	if got := cfg.Load().Port; got != 8080 {
+		t.Errorf("invalid config should keep port 8080, got %d", got)
+	}
+	if changes != 2 {
+		t.Errorf("OnChange should be called twice, got %d", changes)
+	}
+}
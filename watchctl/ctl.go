@@ -0,0 +1,320 @@
+// Package watchctl controls a running watch.Watcher over a connection, such
+// as a Unix domain socket, so that editors and scripts can inspect it, add
+// and remove watched patterns, pause it and trigger updates without
+// restarting the process.
+//
+// The protocol is line-delimited JSON. The client writes one Request per
+// line and the server answers each with one Response line, in order:
+//
+//	{"op":"status"}
+//	{"status":{"nodes":0,"paths":0,"scans":3}}
+//	{"op":"add","patterns":["**/*.go"],"command":["go","test","./..."]}
+//	{"id":1}
+//	{"op":"remove","id":7}
+//	{"error":"watchctl: no entry 7"}
+//
+// Requests can be sent by hand with tools such as socat:
+//
+//	echo '{"op":"trigger"}' | socat - UNIX-CONNECT:/tmp/watch.sock
+package watchctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/chriscraws/watch"
+)
+
+// The operations of a Request.
+const (
+	OpStatus  = "status"  // report the Status of the Watcher
+	OpAdd     = "add"     // watch Patterns, running Command when they change
+	OpRemove  = "remove"  // stop watching the entry ID
+	OpPause   = "pause"   // suspend notifications, see watch.Watcher.Pause
+	OpResume  = "resume"  // resume notifications
+	OpTrigger = "trigger" // update the entry ID, or every node if ID is 0
+)
+
+// Request is a command sent to a Server.
+type Request struct {
+	Op       string   `json:"op"`
+	ID       int      `json:"id,omitempty"`
+	Patterns []string `json:"patterns,omitempty"`
+	Command  []string `json:"command,omitempty"`
+}
+
+// Response is the answer of a Server to a Request.
+type Response struct {
+	// Error is the reason the request failed, or empty if it succeeded.
+	Error string `json:"error,omitempty"`
+
+	// ID is the ID of the entry created by an add.
+	ID int `json:"id,omitempty"`
+
+	// Status answers a status request.
+	Status *Status `json:"status,omitempty"`
+
+	// Errors lists the errors returned by the nodes updated by a trigger.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Status describes a Watcher and the entries added through its Server.
+type Status struct {
+	Paused  bool    `json:"paused,omitempty"`
+	Nodes   int     `json:"nodes"`
+	Paths   int     `json:"paths"`
+	Scans   uint64  `json:"scans"`
+	Errors  uint64  `json:"errors,omitempty"`
+	Entries []Entry `json:"entries,omitempty"`
+}
+
+// Entry is a set of patterns added with an add request.
+type Entry struct {
+	ID       int      `json:"id"`
+	Patterns []string `json:"patterns"`
+	Command  []string `json:"command,omitempty"`
+}
+
+// Server answers the requests of control clients for Watcher. Entries are
+// registered with Watcher as watch.GlobNodes with Watcher.Batch, so a Server
+// may be used while another goroutine runs the Watcher, but not from the
+// Updated method of one of its nodes. A Server must not be copied after
+// first use.
+type Server struct {
+	// Watcher is the watcher controlled.
+	Watcher *watch.Watcher
+
+	// NewNode returns the node notified when the files of an entry added
+	// with command change. If nil, a watch.CommandNode running command is
+	// used, and entries without a command are rejected.
+	NewNode func(command []string) (watch.Node, error)
+
+	mu      sync.Mutex
+	last    int
+	entries []*entry
+}
+
+type entry struct {
+	Entry
+	glob *watch.GlobNode
+}
+
+// Serve accepts connections on l and serves each with ServeConn until
+// Accept fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn answers the requests read from conn until the client closes its
+// end or writing fails. A line that is not a valid Request is answered with
+// an error. It does not close conn.
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req Request
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("watchctl: %v", err)
+		} else {
+			resp = s.Do(req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Do answers req.
+func (s *Server) Do(req Request) Response {
+	resp, err := s.do(req)
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return resp
+}
+
+func (s *Server) do(req Request) (Response, error) {
+	w := s.Watcher
+	switch req.Op {
+	case OpStatus:
+		return Response{Status: s.status()}, nil
+	case OpAdd:
+		id, err := s.add(req.Patterns, req.Command)
+		return Response{ID: id}, err
+	case OpRemove:
+		return Response{}, s.remove(req.ID)
+	case OpPause:
+		w.Pause()
+		return Response{}, nil
+	case OpResume:
+		w.Resume()
+		return Response{}, nil
+	case OpTrigger:
+		errs, err := s.trigger(req.ID)
+		var resp Response
+		for _, err := range errs {
+			resp.Errors = append(resp.Errors, err.Error())
+		}
+		return resp, err
+	}
+	return Response{}, fmt.Errorf("watchctl: unknown op %q", req.Op)
+}
+
+func (s *Server) status() *Status {
+	stats := s.Watcher.Stats()
+	st := &Status{
+		Paused: s.Watcher.Paused(),
+		Nodes:  stats.Nodes,
+		Paths:  stats.Paths,
+		Scans:  stats.Scans,
+		Errors: stats.Errors,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		st.Entries = append(st.Entries, e.Entry)
+	}
+	return st
+}
+
+// add registers an entry watching patterns.
+func (s *Server) add(patterns, command []string) (int, error) {
+	if len(patterns) == 0 {
+		return 0, errors.New("watchctl: no patterns")
+	}
+	node, err := s.node(command)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last++
+	e := &entry{
+		Entry: Entry{ID: s.last, Patterns: slices.Clone(patterns), Command: slices.Clone(command)},
+		glob:  &watch.GlobNode{Watcher: s.Watcher, Patterns: slices.Clone(patterns), Node: node},
+	}
+	if err := s.Watcher.Batch(func(tx *watch.Tx) { tx.Register(e.glob) }); err != nil {
+		return 0, err
+	}
+	s.entries = append(s.entries, e)
+	return e.ID, nil
+}
+
+func (s *Server) node(command []string) (watch.Node, error) {
+	if s.NewNode != nil {
+		return s.NewNode(command)
+	}
+	if len(command) == 0 {
+		return nil, errors.New("watchctl: no command")
+	}
+	return &watch.CommandNode{Command: command}, nil
+}
+
+// remove unregisters the entry id, detaching its node.
+func (s *Server) remove(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.entries, func(e *entry) bool { return e.ID == id })
+	if i < 0 {
+		return fmt.Errorf("watchctl: no entry %d", id)
+	}
+	e := s.entries[i]
+	if err := s.Watcher.Batch(func(tx *watch.Tx) { tx.Unregister(e.glob) }); err != nil {
+		return err
+	}
+	s.entries = slices.Delete(s.entries, i, i+1)
+	// GlobNode does not forward Detached to the node it wraps
+	if d, ok := e.glob.Node.(watch.Detacher); ok {
+		d.Detached()
+	}
+	return nil
+}
+
+// trigger updates the node of the entry id, or every node if id is 0, and
+// returns the errors of the update.
+func (s *Server) trigger(id int) ([]error, error) {
+	if id == 0 {
+		return s.Watcher.UpdateAll(), nil
+	}
+	s.mu.Lock()
+	i := slices.IndexFunc(s.entries, func(e *entry) bool { return e.ID == id })
+	var node watch.Node
+	if i >= 0 {
+		node = s.entries[i].glob
+	}
+	s.mu.Unlock()
+	if node == nil {
+		return nil, fmt.Errorf("watchctl: no entry %d", id)
+	}
+	// UpdateNode holds the Watcher's lock, so the update cannot overlap a Scan's
+	return s.Watcher.UpdateNode(node), nil
+}
+
+// Client sends requests to a Server. A Client is safe for concurrent use;
+// requests are sent one at a time.
+type Client struct {
+	mu      sync.Mutex
+	conn    io.ReadWriteCloser
+	scanner *bufio.Scanner
+}
+
+// Dial connects to the Server listening at address on the named network,
+// such as "unix".
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a Client sending requests over conn.
+func NewClient(conn io.ReadWriteCloser) *Client {
+	return &Client{conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+// Do sends req and returns the response. A request the server could not
+// carry out is reported as an error, along with the response.
+func (c *Client) Do(req Request) (Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := json.NewEncoder(c.conn).Encode(req); err != nil {
+		return Response{}, err
+	}
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return Response{}, err
+		}
+		return Response{}, io.ErrUnexpectedEOF
+	}
+	var resp Response
+	if err := json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
+		return Response{}, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
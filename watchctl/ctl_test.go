@@ -0,0 +1,117 @@
+package watchctl_test
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchctl"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+type detachNode struct {
+	*watchtest.Node
+	detached bool
+}
+
+func (n *detachNode) Detached() { n.detached = true }
+
+func TestServer(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("src/a.go", nil)
+	w := &watch.Watcher{FS: fsys}
+	var nodes []*detachNode
+	s := &watchctl.Server{Watcher: w, NewNode: func(command []string) (watch.Node, error) {
+		n := &detachNode{Node: watchtest.NewNode()}
+		nodes = append(nodes, n)
+		return n, nil
+	}}
+	server, conn := net.Pipe()
+	go func() {
+		defer server.Close()
+		s.ServeConn(server)
+	}()
+	c := watchctl.NewClient(conn)
+	defer c.Close()
+
+	resp, err := c.Do(watchctl.Request{Op: watchctl.OpAdd, Patterns: []string{"src/*.go"}, Command: []string{"go", "test"}})
+	if err != nil || resp.ID != 1 {
+		t.Fatalf("got %+v, %v", resp, err)
+	}
+	w.Scan()
+	fsys.Advance(time.Second)
+	fsys.WriteFile("src/a.go", nil)
+	w.Scan()
+	watchtest.ExpectUpdated(t, nodes[0].Node, 1)
+
+	resp, err = c.Do(watchctl.Request{Op: watchctl.OpStatus})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := resp.Status
+	if st == nil || st.Nodes != 1 || st.Scans != 2 || len(st.Entries) != 1 || !slices.Equal(st.Entries[0].Command, []string{"go", "test"}) {
+		t.Errorf("got status %+v", st)
+	}
+
+	// pausing holds notifications until resumed
+	if _, err := c.Do(watchctl.Request{Op: watchctl.OpPause}); err != nil {
+		t.Fatal(err)
+	}
+	fsys.Advance(time.Second)
+	fsys.WriteFile("src/a.go", nil)
+	w.Scan()
+	watchtest.ExpectUpdated(t, nodes[0].Node, 1)
+	if resp, _ := c.Do(watchctl.Request{Op: watchctl.OpStatus}); !resp.Status.Paused {
+		t.Error("expected the status to report the pause")
+	}
+	c.Do(watchctl.Request{Op: watchctl.OpResume})
+	w.Scan()
+	watchtest.ExpectUpdated(t, nodes[0].Node, 2)
+
+	if _, err := c.Do(watchctl.Request{Op: watchctl.OpTrigger, ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	watchtest.ExpectUpdated(t, nodes[0].Node, 3)
+	if _, err := c.Do(watchctl.Request{Op: watchctl.OpTrigger}); err != nil {
+		t.Fatal(err)
+	}
+	watchtest.ExpectUpdated(t, nodes[0].Node, 4)
+
+	if _, err := c.Do(watchctl.Request{Op: watchctl.OpRemove, ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !nodes[0].detached || len(w.Nodes()) != 0 {
+		t.Error("removing an entry should unregister and detach its node")
+	}
+	if _, err := c.Do(watchctl.Request{Op: watchctl.OpRemove, ID: 1}); err == nil {
+		t.Error("removing an unknown entry should fail")
+	}
+	if _, err := c.Do(watchctl.Request{Op: "reload"}); err == nil {
+		t.Error("unknown ops should fail")
+	}
+	if _, err := c.Do(watchctl.Request{Op: watchctl.OpAdd}); err == nil {
+		t.Error("adding no patterns should fail")
+	}
+}
+
+func TestInvalidRequest(t *testing.T) {
+	s := &watchctl.Server{Watcher: new(watch.Watcher)}
+	server, conn := net.Pipe()
+	go func() {
+		defer server.Close()
+		s.ServeConn(server)
+	}()
+	defer conn.Close()
+	conn.Write([]byte("status\n"))
+	buf := make([]byte, 256)
+	n, _ := conn.Read(buf)
+	c := watchctl.NewClient(conn)
+	if _, err := c.Do(watchctl.Request{Op: watchctl.OpStatus}); err != nil {
+		t.Errorf("the connection should survive an invalid request, got %v", err)
+	}
+	if got := string(buf[:n]); got == "" || got[0] != '{' {
+		t.Errorf("expected an error response, got %q", got)
+	}
+}
@@ -0,0 +1,276 @@
+// Package watchgen re-runs the //go:generate directives of Go files when
+// their inputs change, turning a watch.Watcher into an incremental code
+// generation driver.
+package watchgen
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/chriscraws/watch"
+)
+
+// Directive is a //go:generate directive.
+type Directive struct {
+	// File is the path of the Go file containing the directive.
+	File string
+
+	// Line is the line number of the directive, starting at 1.
+	Line int
+
+	// Text is the full source text of the directive, as matched by the -run
+	// flag of go generate.
+	Text string
+
+	// Args is the command and its arguments, split as go generate does.
+	Args []string
+}
+
+// Inputs returns the paths named by the directive's arguments that exist,
+// relative to the directory of its file. Arguments of the form -flag=value
+// are checked by value. Arguments containing $ are skipped, since go
+// generate expands them.
+func (d Directive) Inputs() []string {
+	dir := filepath.Dir(d.File)
+	var inputs []string
+	for _, arg := range d.Args[1:] {
+		if _, v, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(arg, "-") {
+			arg = v
+		}
+		if arg == "" || strings.HasPrefix(arg, "-") || strings.Contains(arg, "$") {
+			continue
+		}
+		p := arg
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		if info, err := os.Stat(p); err == nil && !info.IsDir() && !slices.Contains(inputs, p) {
+			inputs = append(inputs, p)
+		}
+	}
+	return inputs
+}
+
+// Parse returns the //go:generate directives of the Go file read from r.
+// name is recorded as the File of each directive.
+func Parse(name string, r io.Reader) ([]Directive, error) {
+	var directives []Directive
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		rest, ok := strings.CutPrefix(text, "//go:generate")
+		if !ok || rest == "" || (rest[0] != ' ' && rest[0] != '\t') {
+			continue
+		}
+		args, err := split(rest)
+		if err != nil {
+			return directives, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		directives = append(directives, Directive{File: name, Line: line, Text: text, Args: args})
+	}
+	return directives, scanner.Err()
+}
+
+// split splits a directive into words separated by spaces, where a word may
+// be a double-quoted Go string.
+func split(s string) ([]string, error) {
+	var words []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return words, nil
+		}
+		if s[0] != '"' {
+			i := strings.IndexAny(s, " \t")
+			if i < 0 {
+				i = len(s)
+			}
+			words = append(words, s[:i])
+			s = s[i:]
+			continue
+		}
+		end := 1
+		for ; end < len(s) && s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(s) {
+			return nil, errors.New("unterminated quoted string")
+		}
+		word, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, word)
+		s = s[end+1:]
+	}
+}
+
+// Generator is a watch.ReasonNode that watches the Go files matching a set of
+// glob patterns together with the inputs of their //go:generate directives.
+// When a Go file changes all of its directives are run again; when an input
+// changes only the directives naming it are. New Go files are picked up as
+// they appear, and a changed file's directives are parsed again before they
+// are run. Directives still to run are skipped once the context of the scan
+// is done.
+//
+//	g := &watchgen.Generator{Watcher: w, Patterns: []string{"**/*.go"}}
+//	w.Register(g)
+//
+// Files are read from the operating system's file system, where go generate
+// runs, so the Watcher's FS should be left nil.
+type Generator struct {
+	// Watcher expands the patterns, using its ignore rules.
+	Watcher *watch.Watcher
+
+	// Patterns lists the glob patterns of the Go files to watch. If empty,
+	// "**/*.go" is used.
+	Patterns []string
+
+	// Run runs a directive. If nil, go generate is run for the directive's
+	// file with -run selecting just that directive.
+	Run func(ctx context.Context, d Directive) error
+
+	// Stdout and Stderr receive the output of the default Run. If nil, the
+	// output is discarded.
+	Stdout, Stderr io.Writer
+
+	glob   watch.GlobNode
+	parsed map[string][]Directive
+	errs   map[string]error
+}
+
+// Directives returns the directives of the Go files watched so far, ordered
+// by file and line.
+func (g *Generator) Directives() []Directive {
+	var directives []Directive
+	for _, file := range slices.Sorted(maps.Keys(g.parsed)) {
+		directives = append(directives, g.parsed[file]...)
+	}
+	return directives
+}
+
+// Paths returns the Go files matching Patterns, the directories searched to
+// find them and the inputs of their directives.
+func (g *Generator) Paths() []string {
+	g.glob.Watcher = g.Watcher
+	g.glob.Patterns = g.Patterns
+	if len(g.glob.Patterns) == 0 {
+		g.glob.Patterns = []string{"**/*.go"}
+	}
+	paths := g.glob.Paths()
+	for _, p := range slices.Clone(paths) {
+		if !strings.HasSuffix(p, ".go") {
+			continue
+		}
+		if _, ok := g.parsed[p]; !ok {
+			g.parse(p)
+		}
+		for _, d := range g.parsed[p] {
+			paths = append(paths, d.Inputs()...)
+		}
+	}
+	return paths
+}
+
+// Updated runs every directive.
+func (g *Generator) Updated() error {
+	return g.UpdatedReason(context.Background(), watch.ReasonForced)
+}
+
+// UpdatedReason implements watch.ReasonNode. It parses the Go files reported
+// by the Watcher's ChangedPaths again and runs the directives of the changed
+// files and those naming a changed input, stopping early if ctx is
+// cancelled. On a forced refresh, or if no paths changed, as when notified
+// because of a dependency, every directive is run. Errors encountered while
+// expanding the patterns or parsing the Go files are returned along with
+// those of the directives.
+func (g *Generator) UpdatedReason(ctx context.Context, reason watch.Reason) error {
+	var paths []string
+	if reason != watch.ReasonForced {
+		paths = g.Watcher.ChangedPaths(g)
+	}
+	errs := []error{g.glob.Updated()}
+	if len(paths) == 0 {
+		for _, file := range slices.Sorted(maps.Keys(g.errs)) {
+			errs = append(errs, g.errs[file])
+		}
+		return g.run(ctx, g.Directives(), errs)
+	}
+	var run []Directive
+	for _, p := range paths {
+		if _, ok := g.parsed[p]; ok {
+			g.parse(p)
+			errs = append(errs, g.errs[p])
+		}
+	}
+	for _, d := range g.Directives() {
+		if slices.Contains(paths, d.File) || slices.ContainsFunc(d.Inputs(), func(in string) bool {
+			return slices.Contains(paths, in)
+		}) {
+			run = append(run, d)
+		}
+	}
+	return g.run(ctx, run, errs)
+}
+
+// run runs directives in order and joins their errors with errs.
+func (g *Generator) run(ctx context.Context, directives []Directive, errs []error) error {
+	for _, d := range directives {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		run := g.Run
+		if run == nil {
+			run = g.generate
+		}
+		if err := run(ctx, d); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", d.File, d.Line, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// generate runs go generate for the file of d, selecting only d.
+func (g *Generator) generate(ctx context.Context, d Directive) error {
+	cmd := exec.CommandContext(ctx, "go", "generate", "-run", "^"+regexp.QuoteMeta(d.Text)+"$", d.File)
+	cmd.Stdout, cmd.Stderr = g.Stdout, g.Stderr
+	return cmd.Run()
+}
+
+// parse reads the directives of the Go file at p. Files that no longer exist
+// are forgotten.
+func (g *Generator) parse(p string) {
+	if g.parsed == nil {
+		g.parsed = make(map[string][]Directive)
+		g.errs = make(map[string]error)
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		delete(g.parsed, p)
+		delete(g.errs, p)
+		return
+	}
+	var directives []Directive
+	if err == nil {
+		directives, err = Parse(p, f)
+		f.Close()
+	}
+	g.parsed[p], g.errs[p] = directives, err
+}
@@ -0,0 +1,112 @@
+package watchgen_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchgen"
+)
+
+func TestParse(t *testing.T) {
+	src := `package p
+
+//go:generate stringer -type=Color
+//go:generate sh -c "echo \"hi\" > out.txt"
+//go:generated not a directive
+// go:generate not a directive either
+//go:generate
+`
+	directives, err := watchgen.Parse("p.go", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(directives) != 2 {
+		t.Fatalf("got %d directives, want 2: %v", len(directives), directives)
+	}
+	d := directives[1]
+	if d.File != "p.go" || d.Line != 4 || d.Text != `//go:generate sh -c "echo \"hi\" > out.txt"` {
+		t.Errorf("got %+v", d)
+	}
+	if want := []string{"sh", "-c", `echo "hi" > out.txt`}; !slices.Equal(d.Args, want) {
+		t.Errorf("got args %q, want %q", d.Args, want)
+	}
+	if _, err := watchgen.Parse("p.go", strings.NewReader(`//go:generate echo "oops`)); err == nil {
+		t.Error("expected an error for an unterminated string")
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	t.Chdir(t.TempDir())
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("gen.go", "package p\n\n//go:generate embed -in=data.txt\n//go:generate version\n")
+	write("data.txt", "a")
+
+	var ran []string
+	w := new(watch.Watcher)
+	g := &watchgen.Generator{Watcher: w, Run: func(_ context.Context, d watchgen.Directive) error {
+		ran = append(ran, d.Args[0])
+		return nil
+	}}
+	w.Register(g)
+	w.Scan()
+	if ran != nil {
+		t.Fatalf("ran %v on the first scan", ran)
+	}
+	if !slices.Contains(g.Paths(), "data.txt") {
+		t.Fatalf("inputs not watched: %v", g.Paths())
+	}
+
+	write("data.txt", "ab")
+	if _, errs := w.Scan(); errs != nil {
+		t.Fatal(errs)
+	}
+	if !slices.Equal(ran, []string{"embed"}) {
+		t.Errorf("after an input change ran %v, want [embed]", ran)
+	}
+
+	ran = nil
+	write("gen.go", "package p\n\n//go:generate embed -in=data.txt\n//go:generate version\n//go:generate lint\n")
+	if _, errs := w.Scan(); errs != nil {
+		t.Fatal(errs)
+	}
+	if !slices.Equal(ran, []string{"embed", "version", "lint"}) {
+		t.Errorf("after a Go file change ran %v, want [embed version lint]", ran)
+	}
+
+	ran = nil
+	write("other.go", "package p\n\n//go:generate other\n")
+	if _, errs := w.Scan(); errs != nil {
+		t.Fatal(errs)
+	}
+	if ran != nil {
+		t.Errorf("a new Go file ran %v", ran)
+	}
+	if n := len(g.Directives()); n != 4 {
+		t.Errorf("got %d directives, want 4", n)
+	}
+
+	// cancelling the scan stops the directives still to run
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g.Run = func(_ context.Context, d watchgen.Directive) error {
+		ran = append(ran, d.Args[0])
+		cancel()
+		return nil
+	}
+	ran = nil
+	write("gen.go", "package p\n\n//go:generate embed -in=data.txt\n//go:generate version\n//go:generate lint -v\n")
+	_, _, errs := w.ScanContext(ctx)
+	if !slices.Equal(ran, []string{"embed"}) || !errors.Is(errs.Err(), context.Canceled) {
+		t.Errorf("a cancelled scan ran %v with errors %v, want [embed] and context.Canceled", ran, errs)
+	}
+}
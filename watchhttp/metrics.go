@@ -0,0 +1,79 @@
+package watchhttp
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/chriscraws/watch"
+)
+
+// Metrics is an http.Handler that exposes the Stats of a Watcher in the
+// Prometheus text exposition format, for scraping by Prometheus or any
+// compatible agent. Counters are reported as totals, so rates, such as
+// changes per second, are computed by the monitoring system. Errors are
+// also reported per node, labelled with watch.NodeName, including the nodes
+// that have since been unregistered.
+//
+//	http.Handle("/metrics", &watchhttp.Metrics{Watcher: w})
+type Metrics struct {
+	// Watcher is the Watcher whose statistics are exposed.
+	Watcher *watch.Watcher
+
+	// Namespace prefixes the metric names. If empty, "watch" is used.
+	Namespace string
+}
+
+// ServeHTTP writes the current statistics.
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	ns := m.Namespace
+	if ns == "" {
+		ns = "watch"
+	}
+	stats := m.Watcher.Stats()
+	var b strings.Builder
+	metric := func(name, kind, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s_%s %s\n# TYPE %s_%s %s\n%s_%s %s\n", ns, name, help, ns, name, kind, ns, name, formatValue(value))
+	}
+	metric("scans_total", "counter", "Scans run.", float64(stats.Scans))
+	metric("stats_total", "counter", "Paths statted.", float64(stats.Stats))
+	metric("changes_total", "counter", "Paths found changed.", float64(stats.Changes))
+	metric("notified_total", "counter", "Nodes notified.", float64(stats.Notified))
+	metric("errors_total", "counter", "Errors returned by scans.", float64(stats.Errors))
+	metric("scan_duration_seconds_total", "counter", "Time spent scanning.", stats.ScanDuration.Seconds())
+	metric("last_scan_duration_seconds", "gauge", "Duration of the last scan.", stats.LastScan.Duration.Seconds())
+	metric("last_scan_changes", "gauge", "Paths found changed by the last scan.", float64(stats.LastScan.Changes))
+	metric("nodes", "gauge", "Registered nodes.", float64(stats.Nodes))
+	metric("paths", "gauge", "Tracked paths.", float64(stats.Paths))
+	metric("interval_seconds", "gauge", "Polling interval used by Run.", stats.Interval.Seconds())
+
+	// nodes with the same name are reported together
+	errs := map[string]uint64{}
+	for node, n := range stats.NodeErrors {
+		errs[watch.NodeName(node)] += n
+	}
+	fmt.Fprintf(&b, "# HELP %s_node_errors_total Errors returned by node updates.\n# TYPE %s_node_errors_total counter\n", ns, ns)
+	names := make([]string, 0, len(errs))
+	for name := range errs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s_node_errors_total{node=\"%s\"} %d\n", ns, escapeLabel(name), errs[name])
+	}
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	rw.Write([]byte(b.String()))
+}
+
+// escapeLabel escapes a label value for the text exposition format.
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// formatValue formats a sample value.
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
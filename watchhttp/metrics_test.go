@@ -0,0 +1,52 @@
+package watchhttp_test
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchhttp"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+type namedNode struct {
+	*watchtest.Node
+	name string
+}
+
+func (n namedNode) String() string { return n.name }
+
+func TestMetrics(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	w := &watch.Watcher{FS: fsys}
+	n := watchtest.NewNode("a.txt")
+	n.Err = errors.New("failed")
+	w.Register(namedNode{n, `templates "html"`})
+	w.Scan()
+	fsys.Advance(time.Second)
+	fsys.Touch("a.txt")
+	w.Scan()
+
+	rec := httptest.NewRecorder()
+	(&watchhttp.Metrics{Watcher: w}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		"# TYPE watch_scans_total counter\nwatch_scans_total 2\n",
+		"watch_changes_total 1\n",
+		"watch_paths 1\n",
+		"# TYPE watch_last_scan_duration_seconds gauge\n",
+		`watch_node_errors_total{node="templates \"html\""} 1` + "\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics should contain %q, got\n%s", want, body)
+		}
+	}
+}
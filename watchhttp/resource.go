@@ -0,0 +1,181 @@
+package watchhttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chriscraws/watch"
+)
+
+// Resource is a watch.Node that watches a remote resource, such as a config
+// or schema file, by polling its URL with conditional requests. It
+// implements watch.Poller, so it is polled by Watcher.Scan alongside local
+// files and notified in the same cycle. Like files, the resource is not
+// reported as changed by the first successful poll. Backoff delays are
+// measured with the Clock of the Watcher the Resource is registered with.
+type Resource struct {
+	// URL is the address of the resource.
+	URL string
+
+	// Client is used to make requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Timeout bounds each request. If zero, 10 seconds is used.
+	Timeout time.Duration
+
+	// Backoff is the delay before polling again after a failed request. It
+	// doubles with each consecutive failure, up to MaxBackoff. If zero, one
+	// second is used.
+	Backoff time.Duration
+
+	// MaxBackoff caps the delay between polls after failures. If zero, one
+	// minute is used.
+	MaxBackoff time.Duration
+
+	// OnChange, if not nil, is called by Updated with the latest body of
+	// the resource.
+	OnChange func(body []byte) error
+
+	mu           sync.Mutex
+	fetched      bool
+	etag         string
+	lastModified string
+	body         []byte
+	failures     int
+	retryAt      time.Time
+	clock        watch.Clock // of the Watcher, if not nil
+}
+
+// Attached implements watch.Attacher by using the Clock of w.
+func (r *Resource) Attached(w *watch.Watcher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clock = w.Clock
+}
+
+// Paths returns nil, as the resource is not a local file.
+func (r *Resource) Paths() []string {
+	return nil
+}
+
+// Updated calls OnChange with the latest body.
+func (r *Resource) Updated() error {
+	if r.OnChange == nil {
+		return nil
+	}
+	return r.OnChange(r.Body())
+}
+
+// Body returns the body of the resource from the latest successful poll.
+func (r *Resource) Body() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.body
+}
+
+// String returns the URL, identifying the resource in errors reported by
+// Scan.
+func (r *Resource) String() string {
+	return r.URL
+}
+
+// Poll is PollContext with context.Background.
+func (r *Resource) Poll() (bool, error) {
+	return r.PollContext(context.Background())
+}
+
+// PollContext fetches the resource if it was modified, as reported by the
+// server through its ETag or Last-Modified headers, and reports whether the
+// body changed. The request is cancelled when ctx is done. While backing off
+// after a failure, PollContext returns immediately.
+func (r *Resource) PollContext(ctx context.Context) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.now().Before(r.retryAt) {
+		return false, nil
+	}
+	changed, err := r.fetch(ctx)
+	if err != nil {
+		r.failures++
+		r.retryAt = r.now().Add(r.backoff())
+		return false, err
+	}
+	r.failures = 0
+	r.retryAt = time.Time{}
+	return changed, nil
+}
+
+func (r *Resource) now() time.Time {
+	if r.clock == nil {
+		return time.Now()
+	}
+	return r.clock.Now()
+}
+
+func (r *Resource) fetch(ctx context.Context) (bool, error) {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+	if r.lastModified != "" {
+		req.Header.Set("If-Modified-Since", r.lastModified)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return false, nil
+	case resp.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("watchhttp: GET %s: %s", r.URL, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	changed := r.fetched && string(body) != string(r.body)
+	r.fetched = true
+	r.body = body
+	r.etag = resp.Header.Get("ETag")
+	r.lastModified = resp.Header.Get("Last-Modified")
+	return changed, nil
+}
+
+func (r *Resource) backoff() time.Duration {
+	d := r.Backoff
+	if d == 0 {
+		d = time.Second
+	}
+	limit := r.MaxBackoff
+	if limit == 0 {
+		limit = time.Minute
+	}
+	for i := 1; i < r.failures && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+var (
+	_ watch.ContextPoller = (*Resource)(nil)
+	_ watch.Attacher      = (*Resource)(nil)
+)
@@ -0,0 +1,86 @@
+package watchhttp_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchhttp"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+func TestResource(t *testing.T) {
+	var (
+		version  atomic.Int32
+		failing  atomic.Bool
+		requests atomic.Int32
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		etag := `"v` + string('0'+rune(version.Load())) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		rw.Header().Set("ETag", etag)
+		rw.Write([]byte(etag))
+	}))
+	defer ts.Close()
+
+	var got string
+	r := &watchhttp.Resource{
+		URL:      ts.URL,
+		Backoff:  time.Hour,
+		OnChange: func(body []byte) error { got = string(body); return nil },
+	}
+	clock := new(watchtest.Clock)
+	w := &watch.Watcher{Clock: clock}
+	w.Register(r)
+	w.Scan()
+	w.Scan()
+	if got != "" {
+		t.Errorf("unchanged resource should not be reported")
+	}
+
+	version.Store(1)
+	w.Scan()
+	if got != `"v1"` {
+		t.Errorf("body should be reported after change, got %q", got)
+	}
+
+	failing.Store(true)
+	_, errs := w.Scan()
+	var statErr *watch.StatError
+	if len(errs) != 1 || !errors.As(errs[0], &statErr) || statErr.Path != ts.URL {
+		t.Errorf("expected a StatError for the URL, got %v", errs)
+	}
+	n := requests.Load()
+	w.Scan()
+	if requests.Load() != n {
+		t.Errorf("resource should back off after a failure")
+	}
+
+	failing.Store(false)
+	version.Store(2)
+	clock.Advance(time.Hour)
+	w.Scan()
+	if requests.Load() == n || got != `"v2"` {
+		t.Errorf("back-off should end with the Watcher's Clock, got %q", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n = requests.Load()
+	if _, err := r.PollContext(ctx); !errors.Is(err, context.Canceled) || requests.Load() != n {
+		t.Errorf("PollContext should not fetch once ctx is done, got %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+// Package watchhttp serves changes detected by a watch.Watcher over HTTP, so
+// that browsers can reload when templates or assets change during
+// development.
+package watchhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chriscraws/watch"
+)
+
+// Event is the payload of a "reload" Server-Sent Event.
+type Event struct {
+	// Paths lists the changed paths that triggered the event. It may be
+	// empty if the paths are not known.
+	Paths []string `json:"paths"`
+
+	id uint64
+}
+
+// Server is an http.Handler that streams reload events to browsers using
+// Server-Sent Events. Events are sent with Broadcast, or by the nodes
+// returned by Node and Wrap when they are updated. The zero value is ready
+// to use.
+type Server struct {
+	// KeepAlive is the interval between comments sent to keep idle
+	// connections open. If zero, 30 seconds is used.
+	KeepAlive time.Duration
+
+	// Journal, if not nil, should be the Journal of the Watcher whose
+	// changes are broadcast. Events are then sent with the journal's
+	// sequence number as their ID, and a client reconnecting with a
+	// Last-Event-ID header is sent the paths it missed in a single
+	// event, or an event without paths if they are no longer in the
+	// journal.
+	Journal *watch.Journal
+
+	mu      sync.Mutex
+	clients map[chan Event]struct{}
+}
+
+// Broadcast sends a reload event for paths to every connected client.
+// Clients that are not keeping up miss the event.
+func (s *Server) Broadcast(paths []string) {
+	e := Event{Paths: paths}
+	if s.Journal != nil {
+		e.id = s.Journal.Seq()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// missed returns the event catching up a client that last saw the event
+// with the given Last-Event-ID, if it missed any.
+func (s *Server) missed(lastID string) (Event, bool) {
+	if s.Journal == nil || lastID == "" {
+		return Event{}, false
+	}
+	seq, err := strconv.ParseUint(lastID, 10, 64)
+	if err != nil || !s.Journal.Covers(seq) {
+		return Event{id: s.Journal.Seq()}, true
+	}
+	events := s.Journal.Since(seq)
+	if len(events) == 0 {
+		return Event{}, false
+	}
+	var paths []string
+	for _, e := range events {
+		paths = append(paths, e.Path)
+		if e.OldPath != "" {
+			paths = append(paths, e.OldPath)
+		}
+	}
+	slices.Sort(paths)
+	return Event{Paths: slices.Compact(paths), id: events[len(events)-1].Seq}, true
+}
+
+// ServeHTTP streams events to the client until the request is cancelled.
+func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	c := make(chan Event, 16)
+	s.mu.Lock()
+	if s.clients == nil {
+		s.clients = make(map[chan Event]struct{})
+	}
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+	}()
+
+	if e, ok := s.missed(r.Header.Get("Last-Event-ID")); ok {
+		c <- e
+	}
+
+	rc := http.NewResponseController(rw)
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := s.KeepAlive
+	if keepAlive == 0 {
+		keepAlive = 30 * time.Second
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(rw, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case e := <-c:
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			if e.id > 0 {
+				if _, err := fmt.Fprintf(rw, "id: %d\n", e.id); err != nil {
+					return
+				}
+			}
+			if _, err := fmt.Fprintf(rw, "event: reload\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Node returns a node that watches paths and broadcasts a reload event for
+// the changed paths whenever it is updated by w. The node must be registered
+// with w by the caller.
+func (s *Server) Node(w *watch.Watcher, paths ...string) watch.Node {
+	return &reloadNode{s: s, w: w, paths: paths}
+}
+
+// Wrap returns a node that forwards to node and broadcasts a reload event
+// after node is updated successfully, so that browsers reload once a rebuild
+// has finished. The returned node must be registered with w instead of node.
+func (s *Server) Wrap(w *watch.Watcher, node watch.Node) watch.Node {
+	return &reloadNode{s: s, w: w, node: node}
+}
+
+type reloadNode struct {
+	s     *Server
+	w     *watch.Watcher
+	paths []string
+	node  watch.Node
+}
+
+func (n *reloadNode) Paths() []string {
+	if n.node != nil {
+		return n.node.Paths()
+	}
+	return n.paths
+}
+
+func (n *reloadNode) Updated() error {
+	if n.node != nil {
+		if err := n.node.Updated(); err != nil {
+			return err
+		}
+	}
+	n.s.Broadcast(n.w.ChangedPaths(n))
+	return nil
+}
+
+var snippet = template.Must(template.New("snippet").Parse(`<script>
+(function () {
+	var source = new EventSource({{.}});
+	source.addEventListener("reload", function (e) {
+		var paths = JSON.parse(e.data).paths || [];
+		var css = paths.length > 0 && paths.every(function (p) { return /\.css$/.test(p); });
+		if (!css) {
+			location.reload();
+			return;
+		}
+		document.querySelectorAll('link[rel="stylesheet"]').forEach(function (link) {
+			var url = new URL(link.href);
+			url.searchParams.set("watchhttp", Date.now());
+			link.href = url.toString();
+		});
+	});
+})();
+</script>`))
+
+// Snippet returns a script element that connects to a Server mounted at
+// endpoint and reloads the page on every event. If only stylesheets changed,
+// they are reloaded in place instead of the whole page.
+func Snippet(endpoint string) template.HTML {
+	var b strings.Builder
+	snippet.Execute(&b, endpoint)
+	return template.HTML(b.String())
+}
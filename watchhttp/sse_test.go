@@ -0,0 +1,99 @@
+package watchhttp_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchhttp"
+)
+
+func TestServer(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"index.html": {ModTime: t0}}
+	w := &watch.Watcher{FS: fsys}
+	s := new(watchhttp.Server)
+	w.Register(s.Node(w, "index.html"))
+	w.Scan()
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("content type should be text/event-stream, got %q", ct)
+	}
+
+	fsys["index.html"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+
+	lines := bufio.NewScanner(resp.Body)
+	var got []string
+	for len(got) < 2 && lines.Scan() {
+		if line := lines.Text(); line != "" {
+			got = append(got, line)
+		}
+	}
+	want := []string{"event: reload", `data: {"paths":["index.html"]}`}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("event should be %q, got %q", want, got)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	s := string(watchhttp.Snippet("/_watch"))
+	if !strings.Contains(s, `new EventSource("/_watch")`) {
+		t.Errorf("snippet should connect to the endpoint, got %s", s)
+	}
+}
+
+func TestServerJournal(t *testing.T) {
+	t0 := time.Now()
+	fsys := fstest.MapFS{"index.html": {ModTime: t0}, "site.css": {ModTime: t0}}
+	j := new(watch.Journal)
+	w := &watch.Watcher{FS: fsys, Journal: j}
+	s := &watchhttp.Server{Journal: j}
+	w.Register(s.Node(w, "index.html", "site.css"))
+	w.Scan()
+	fsys["index.html"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+	fsys["site.css"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	w.Scan()
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	for _, tt := range []struct {
+		lastID string
+		want   []string
+	}{
+		{"1", []string{"id: 2", "event: reload", `data: {"paths":["site.css"]}`}},
+		{"0", []string{"id: 2", "event: reload", `data: {"paths":["index.html","site.css"]}`}},
+		{"7", []string{"id: 2", "event: reload", `data: {"paths":null}`}},
+	} {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		req.Header.Set("Last-Event-ID", tt.lastID)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := bufio.NewScanner(resp.Body)
+		var got []string
+		for len(got) < 3 && lines.Scan() {
+			if line := lines.Text(); line != "" {
+				got = append(got, line)
+			}
+		}
+		resp.Body.Close()
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("client reconnecting after %s should get %q, got %q", tt.lastID, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Package watchlsp feeds the file change notifications of an editor into a
+// watch.Watcher, so that language servers and editor plugins built on the
+// watch package see the changes an editor reports, such as saves, without
+// waiting for the next poll, and still poll for changes made by other
+// programs.
+//
+// The notifications have the shape of the Language Server Protocol's
+// workspace/didChangeWatchedFiles: a list of FileEvents, each naming a file
+// URI and whether the file was created, changed or deleted. A language
+// server decodes the params of the notification into
+// DidChangeWatchedFilesParams and passes them to Bridge.DidChangeWatchedFiles:
+//
+//	b := &watchlsp.Bridge{Watcher: w, Root: workspaceDir}
+//	var params watchlsp.DidChangeWatchedFilesParams
+//	if err := json.Unmarshal(raw, &params); err != nil {
+//		return err
+//	}
+//	b.DidChangeWatchedFiles(params)
+package watchlsp
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/chriscraws/watch"
+)
+
+// FileChangeType is the kind of a FileEvent, with the values of the Language
+// Server Protocol.
+type FileChangeType int
+
+const (
+	Created FileChangeType = 1
+	Changed FileChangeType = 2
+	Deleted FileChangeType = 3
+)
+
+// FileEvent is a change of a file reported by an editor.
+type FileEvent struct {
+	URI  string         `json:"uri"`
+	Type FileChangeType `json:"type"`
+}
+
+// DidChangeWatchedFilesParams are the params of a
+// workspace/didChangeWatchedFiles notification.
+type DidChangeWatchedFilesParams struct {
+	Changes []FileEvent `json:"changes"`
+}
+
+// Bridge applies editor-reported changes to Watcher. The files named by the
+// events are checked with ScanPaths, as if Scan had found them changed: the
+// recorded state of the files is updated and the nodes watching them are
+// notified, so a later Scan does not report the same changes again. For
+// created and deleted files the directories containing them are checked too,
+// so that nodes watching the directories, such as watch.GlobNodes, see them
+// appear or disappear. Events for files the Watcher does not watch are
+// otherwise ignored; a created file that matches a glob is watched from the
+// next Scan.
+type Bridge struct {
+	// Watcher is the watcher the changes are applied to.
+	Watcher *watch.Watcher
+
+	// Root, if not empty, is the directory the watched paths are relative
+	// to, such as the root of the workspace: a file URI under Root is mapped
+	// to its slash-separated path relative to Root, and URIs outside it are
+	// dropped. If empty, file URIs are mapped to the absolute paths of the
+	// operating system.
+	Root string
+
+	// MapPath, if not nil, translates the path of each URI, after Root is
+	// applied, into the path watched. Paths mapped to "" are dropped.
+	MapPath func(path string) string
+}
+
+// DidChangeWatchedFiles applies the changes of params, as Notify does.
+func (b *Bridge) DidChangeWatchedFiles(params DidChangeWatchedFilesParams) (bool, watch.ScanErrors) {
+	return b.Notify(params.Changes...)
+}
+
+// Notify applies events to the Watcher with a single ScanPaths, returning
+// its results.
+func (b *Bridge) Notify(events ...FileEvent) (bool, watch.ScanErrors) {
+	var paths []string
+	for _, e := range events {
+		p := b.Path(e.URI)
+		if p == "" {
+			continue
+		}
+		paths = append(paths, p)
+		if e.Type == Created || e.Type == Deleted {
+			paths = append(paths, parent(p))
+		}
+	}
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+	if len(paths) == 0 {
+		return false, nil
+	}
+	return b.Watcher.ScanPaths(paths...)
+}
+
+// Path returns the watched path of the file named by uri, or "" if uri is
+// not a file URI or is dropped by Root or MapPath.
+func (b *Bridge) Path(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" || u.Path == "" {
+		return ""
+	}
+	p := u.Path
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		// a Windows drive, as in file:///C:/src/a.go
+		p = p[1:]
+	}
+	p = filepath.FromSlash(p)
+	if b.Root != "" {
+		rel, err := filepath.Rel(b.Root, p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return ""
+		}
+		p = filepath.ToSlash(rel)
+	}
+	if b.MapPath != nil {
+		p = b.MapPath(p)
+	}
+	return p
+}
+
+// parent returns the directory containing p, in the syntax of p.
+func parent(p string) string {
+	if strings.Contains(p, "/") || !strings.Contains(p, string(filepath.Separator)) {
+		return path.Dir(p)
+	}
+	return filepath.Dir(p)
+}
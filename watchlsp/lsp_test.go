@@ -0,0 +1,86 @@
+package watchlsp_test
+
+import (
+	"encoding/json"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchlsp"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+// fileURI returns the file URI of the absolute path p.
+func fileURI(p string) string {
+	p = filepath.ToSlash(p)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return "file://" + p
+}
+
+func TestBridge(t *testing.T) {
+	root := t.TempDir()
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"src":      {Mode: fs.ModeDir | 0o755, ModTime: t0},
+		"src/a.go": {ModTime: t0},
+	}
+	// ScanPaths bypasses the results the StatCache holds from the last Scan
+	w := &watch.Watcher{FS: fsys, StatCache: new(watch.StatCache)}
+	a := watchtest.NewNode("src/a.go")
+	glob := watchtest.NewNode()
+	w.Register(a)
+	w.Register(&watch.GlobNode{Watcher: w, Patterns: []string{"src/*.go"}, Node: glob})
+	w.Scan()
+	b := &watchlsp.Bridge{Watcher: w, Root: root}
+
+	fsys["src/a.go"] = &fstest.MapFile{ModTime: t0.Add(time.Second)}
+	var params watchlsp.DidChangeWatchedFilesParams
+	msg := `{"changes": [{"uri": "` + fileURI(filepath.Join(root, "src", "a.go")) + `", "type": 2}, {"uri": "file:///elsewhere/b.go", "type": 2}]}`
+	if err := json.Unmarshal([]byte(msg), &params); err != nil {
+		t.Fatal(err)
+	}
+	if updated, errs := b.DidChangeWatchedFiles(params); !updated || len(errs) > 0 {
+		t.Fatalf("got %v, %v", updated, errs)
+	}
+	watchtest.ExpectUpdated(t, a, 1)
+	watchtest.ExpectUpdated(t, glob, 1)
+
+	// a created file is seen through its directory
+	fsys["src/b.go"] = &fstest.MapFile{ModTime: t0}
+	fsys["src"] = &fstest.MapFile{Mode: fs.ModeDir | 0o755, ModTime: t0.Add(time.Second)}
+	b.Notify(watchlsp.FileEvent{URI: fileURI(filepath.Join(root, "src", "b.go")), Type: watchlsp.Created})
+	watchtest.ExpectUpdated(t, a, 1)
+	watchtest.ExpectUpdated(t, glob, 2)
+
+	// the changes applied are not reported again
+	w.Scan()
+	watchtest.ExpectUpdated(t, a, 1)
+	watchtest.ExpectUpdated(t, glob, 2)
+}
+
+func TestPath(t *testing.T) {
+	root := t.TempDir()
+	b := &watchlsp.Bridge{Root: root}
+	for uri, want := range map[string]string{
+		fileURI(filepath.Join(root, "src", "a b.go")):  "src/a b.go",
+		fileURI(root) + "/src/c%20d.go":                "src/c d.go",
+		fileURI(filepath.Join(root, "..", "other.go")): "",
+		"untitled:Untitled-1":                          "",
+		"https://example.com/a.go":                     "",
+	} {
+		if got := b.Path(uri); got != want {
+			t.Errorf("Path(%q) = %q, want %q", uri, got, want)
+		}
+	}
+
+	b.MapPath = func(p string) string { return strings.TrimPrefix(p, "src/") }
+	if got := b.Path(fileURI(filepath.Join(root, "src", "a.go"))); got != "a.go" {
+		t.Errorf("MapPath should apply after Root, got %q", got)
+	}
+}
@@ -0,0 +1,300 @@
+// Package watchrpc streams the events of a watch.Watcher over a network
+// connection as line-delimited JSON, and mirrors them into a Watcher on the
+// other end. A watcher running inside a container or VM can then drive
+// rebuilds on the host, or the other way around, without the receiving side
+// polling the whole tree: it only checks the paths it is told about.
+//
+// The protocol is deliberately simple. The client sends a single line,
+//
+//	{"since":42}
+//
+// naming the last sequence number it saw, or 0, and the server then writes
+// one Message per line until either side closes the connection.
+package watchrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chriscraws/watch"
+)
+
+// Message is a batch of events sent from a Server to its clients.
+type Message struct {
+	// Events lists the events of one notification.
+	Events []watch.Event `json:"events,omitempty"`
+
+	// Seq is the sequence number of the server's Journal after the events,
+	// or 0 if the server has no Journal.
+	Seq uint64 `json:"seq,omitempty"`
+
+	// Resync is set if events were lost, such as while the client was
+	// disconnected or not keeping up, or are not known, as when nodes are
+	// updated by UpdateAll. The client should then scan everything.
+	Resync bool `json:"resync,omitempty"`
+}
+
+type hello struct {
+	Since uint64 `json:"since"`
+}
+
+// Server streams events to connected clients. Events are sent with
+// Broadcast, or by the nodes returned by Node and Wrap when they are updated.
+// The zero value is ready to use.
+type Server struct {
+	// Journal, if not nil, should be the Journal of the Watcher whose events
+	// are broadcast. Messages then carry the journal's sequence number, and
+	// a client reconnecting with the last number it saw is first sent the
+	// events it missed, or a Resync if they are no longer in the journal.
+	Journal *watch.Journal
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+}
+
+type client struct {
+	c    chan Message
+	lost atomic.Bool
+}
+
+// Broadcast sends events to every connected client. A client that is not
+// keeping up is sent a Resync once it catches up instead.
+func (s *Server) Broadcast(events []watch.Event) {
+	s.send(Message{Events: events, Resync: len(events) == 0})
+}
+
+func (s *Server) send(m Message) {
+	if s.Journal != nil {
+		m.Seq = s.Journal.Seq()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		select {
+		case c.c <- m:
+		default:
+			c.lost.Store(true)
+		}
+	}
+}
+
+// Serve accepts connections on l and serves each with ServeConn until
+// Accept fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn reads the client's greeting from conn and then streams messages
+// to it until writing fails or the client closes its end. It does not close
+// conn.
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	var h hello
+	if err := json.Unmarshal(line, &h); err != nil {
+		return err
+	}
+
+	c := &client{c: make(chan Message, 64)}
+	s.mu.Lock()
+	if s.clients == nil {
+		s.clients = make(map[*client]struct{})
+	}
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+	}()
+
+	if m, ok := s.missed(h.Since); ok {
+		c.c <- m
+	}
+	// the client sends nothing after its greeting, so a read returning means
+	// it has gone away
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, r)
+		close(gone)
+	}()
+
+	enc := json.NewEncoder(conn)
+	for {
+		select {
+		case <-gone:
+			return nil
+		case m := <-c.c:
+			if c.lost.Swap(false) {
+				m = Message{Seq: m.Seq, Resync: true}
+			}
+			if err := enc.Encode(m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// missed returns the message catching up a client that last saw since.
+func (s *Server) missed(since uint64) (Message, bool) {
+	if s.Journal == nil || since == 0 {
+		return Message{}, false
+	}
+	if !s.Journal.Covers(since) {
+		return Message{Seq: s.Journal.Seq(), Resync: true}, true
+	}
+	events := s.Journal.Since(since)
+	if len(events) == 0 {
+		return Message{}, false
+	}
+	return Message{Events: events, Seq: events[len(events)-1].Seq}, true
+}
+
+// Node returns a node that watches paths and broadcasts the events that
+// caused it to be updated by w. The node must be registered with w by the
+// caller.
+func (s *Server) Node(w *watch.Watcher, paths ...string) watch.Node {
+	return &streamNode{s: s, w: w, paths: paths}
+}
+
+// Wrap returns a node that forwards to node and broadcasts its events after
+// node is updated successfully. The returned node must be registered with w
+// instead of node.
+func (s *Server) Wrap(w *watch.Watcher, node watch.Node) watch.Node {
+	return &streamNode{s: s, w: w, node: node}
+}
+
+type streamNode struct {
+	s     *Server
+	w     *watch.Watcher
+	paths []string
+	node  watch.Node
+}
+
+func (n *streamNode) Paths() []string {
+	if n.node != nil {
+		return n.node.Paths()
+	}
+	return n.paths
+}
+
+func (n *streamNode) Updated() error {
+	if n.node != nil {
+		if err := n.node.Updated(); err != nil {
+			return err
+		}
+	}
+	n.s.Broadcast(n.w.Events(n))
+	return nil
+}
+
+// Client mirrors the events streamed by a Server into a local Watcher. The
+// local Watcher must watch the same files, such as through a shared volume;
+// for each message it checks the named paths with ScanPaths, notifying the
+// local nodes that watch them, and it runs a full Scan on a Resync. The
+// errors of these scans, including those returned by the local nodes, are
+// passed to OnError.
+type Client struct {
+	// Watcher is the local watcher the events are mirrored into.
+	Watcher *watch.Watcher
+
+	// MapPath, if not nil, translates a path of the remote watcher into a
+	// local one, such as to strip a container mount point. Paths mapped to
+	// "" are dropped.
+	MapPath func(path string) string
+
+	// OnMessage, if not nil, is called with each message after it has been
+	// mirrored, with its paths translated by MapPath.
+	OnMessage func(m Message)
+
+	// OnError, if not nil, is called with the errors of the scan mirroring
+	// a message, if there are any, before OnMessage. Stat errors are also
+	// passed to the local Watcher's ErrorHandler.
+	OnError func(errs watch.ScanErrors)
+
+	seq atomic.Uint64
+}
+
+// Run greets the server on conn and mirrors the messages it sends until the
+// connection fails or ctx is done. The last sequence number seen is kept, so
+// calling Run again with a new connection after a disconnect catches up on
+// the events missed in between, if the server has a Journal.
+func (c *Client) Run(ctx context.Context, conn io.ReadWriter) error {
+	if closer, ok := conn.(io.Closer); ok {
+		stop := context.AfterFunc(ctx, func() { closer.Close() })
+		defer stop()
+	}
+	if err := json.NewEncoder(conn).Encode(hello{Since: c.seq.Load()}); err != nil {
+		return errors.Join(ctx.Err(), err)
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var m Message
+		if err := dec.Decode(&m); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		c.mirror(m)
+	}
+}
+
+// mirror applies a message to the local Watcher.
+func (c *Client) mirror(m Message) {
+	events := m.Events[:0:0]
+	var paths []string
+	for _, e := range m.Events {
+		if e.Path = c.mapPath(e.Path); e.Path == "" {
+			continue
+		}
+		paths = append(paths, e.Path)
+		if e.OldPath != "" {
+			if e.OldPath = c.mapPath(e.OldPath); e.OldPath != "" {
+				paths = append(paths, e.OldPath)
+			}
+		}
+		events = append(events, e)
+	}
+	m.Events = events
+	var errs watch.ScanErrors
+	if m.Resync {
+		_, errs = c.Watcher.Scan()
+	} else if len(paths) > 0 {
+		_, errs = c.Watcher.ScanPaths(paths...)
+	}
+	if len(errs) > 0 && c.OnError != nil {
+		c.OnError(errs)
+	}
+	if m.Seq > 0 {
+		c.seq.Store(m.Seq)
+	}
+	if c.OnMessage != nil {
+		c.OnMessage(m)
+	}
+}
+
+func (c *Client) mapPath(p string) string {
+	if c.MapPath == nil {
+		return p
+	}
+	return c.MapPath(p)
+}
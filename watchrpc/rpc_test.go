@@ -0,0 +1,160 @@
+package watchrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchrpc"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+func TestStream(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("src/a.txt", []byte("a"))
+	fsys.WriteFile("src/b.txt", []byte("b"))
+
+	// the remote watcher sees the files under src, the local one under local
+	journal := new(watch.Journal)
+	remote := &watch.Watcher{FS: fsys, Journal: journal}
+	s := &watchrpc.Server{Journal: journal}
+	remote.Register(s.Node(remote, "src/a.txt", "src/b.txt"))
+	remote.Scan()
+
+	local := &watch.Watcher{FS: fsys}
+	var updated []string
+	local.Register(watch.File("local/a.txt", func() error {
+		updated = append(updated, "a")
+		return nil
+	}))
+	local.Register(watch.File("local/b.txt", func() error {
+		updated = append(updated, "b")
+		return nil
+	}))
+	local.Scan()
+
+	messages := make(chan watchrpc.Message)
+	c := &watchrpc.Client{
+		Watcher: local,
+		MapPath: func(p string) string {
+			if rest, ok := strings.CutPrefix(p, "src/"); ok {
+				return "local/" + rest
+			}
+			return ""
+		},
+		OnMessage: func(m watchrpc.Message) { messages <- m },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	connect := func() chan error {
+		server, client := net.Pipe()
+		go func() {
+			defer server.Close()
+			s.ServeConn(server)
+		}()
+		done := make(chan error, 1)
+		go func() { done <- c.Run(ctx, client) }()
+		return done
+	}
+	// receive skips the probes sent while waiting for the client to connect,
+	// whose paths MapPath drops
+	receive := func() watchrpc.Message {
+		t.Helper()
+		for {
+			select {
+			case m := <-messages:
+				if len(m.Events) > 0 {
+					return m
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for a message")
+			}
+		}
+	}
+	waitConnected := func() {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ; {
+			s.Broadcast([]watch.Event{{Op: watch.Write, Path: "probe"}})
+			select {
+			case <-messages:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("client never connected")
+			}
+		}
+	}
+	done := connect()
+	waitConnected()
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("local/a.txt", []byte("aa"))
+	fsys.WriteFile("src/a.txt", []byte("aa"))
+	remote.Scan()
+	m := receive()
+	if len(m.Events) != 1 || m.Events[0].Path != "local/a.txt" || m.Seq == 0 {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if len(updated) != 1 || updated[0] != "a" {
+		t.Errorf("mirroring should update the local node for a, got %v", updated)
+	}
+
+	// events missed while disconnected are caught up on reconnect
+	cancel()
+	<-done
+	fsys.Advance(time.Second)
+	fsys.WriteFile("local/b.txt", []byte("bb"))
+	fsys.WriteFile("src/b.txt", []byte("bb"))
+	remote.Scan()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	connect()
+	m = receive()
+	if len(m.Events) != 1 || m.Events[0].Path != "local/b.txt" {
+		t.Errorf("expected the missed event, got %+v", m)
+	}
+	if len(updated) != 2 || updated[1] != "b" {
+		t.Errorf("catching up should update the local node for b, got %v", updated)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", nil)
+	local := &watch.Watcher{FS: fsys}
+	errFail := errors.New("fail")
+	local.Register(watch.File("a.txt", func() error { return errFail }))
+	local.Scan()
+
+	reported := make(chan watch.ScanErrors, 1)
+	c := &watchrpc.Client{Watcher: local, OnError: func(errs watch.ScanErrors) { reported <- errs }}
+	server, client := net.Pipe()
+	defer server.Close()
+	go c.Run(context.Background(), client)
+
+	// a server greeted by the client, announcing a change to a.txt
+	var greeting json.RawMessage
+	if err := json.NewDecoder(server).Decode(&greeting); err != nil {
+		t.Fatal(err)
+	}
+	fsys.Advance(time.Second)
+	fsys.WriteFile("a.txt", []byte("a"))
+	m := watchrpc.Message{Seq: 1, Events: []watch.Event{{Op: watch.Write, Path: "a.txt"}}}
+	if err := json.NewEncoder(server).Encode(m); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case errs := <-reported:
+		if len(errs) != 1 || !errors.Is(errs[0], errFail) {
+			t.Errorf("expected the node's error, got %v", errs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the node's error was not reported")
+	}
+}
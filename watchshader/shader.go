@@ -0,0 +1,194 @@
+// Package watchshader provides a watch.Node that reassembles a shader from
+// its #include closure whenever any of the files change, ready to be
+// recompiled on the GPU.
+package watchshader
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/chriscraws/watch"
+)
+
+// Shader is a watch.Node that watches a shader source file and every file it
+// includes, as discovered by watch.IncludeResolver, and reassembles them into
+// a single source whenever any of them changes. Each #include line is
+// replaced by the contents of the included file. Quoted includes are looked
+// up relative to the including file and then in Dirs; angle-bracket includes
+// are only looked up in Dirs. A file containing "#pragma once" is only
+// inlined the first time it is included.
+//
+// If the source cannot be assembled, or Compile rejects it, the error is
+// returned from Updated and the previous source keeps being served. The
+// source is first assembled by the first Scan after the Shader is
+// registered. A Shader must not be copied after first use.
+//
+//	s := &watchshader.Shader{
+//		Path:    "shaders/lit.frag",
+//		Dirs:    []string{"shaders/lib"},
+//		Compile: compileFragment,
+//	}
+//	w.Register(s)
+//	if _, errs := w.Scan(); errs != nil {
+//		log.Fatal(errs)
+//	}
+type Shader struct {
+	// Path is the path of the root shader file.
+	Path string
+
+	// Dirs lists the include search directories.
+	Dirs []string
+
+	// FS is the file system the shader files are read from, as by
+	// watch.ReadFile.
+	FS fs.FS
+
+	// Compile, if not nil, is called with each newly assembled source, such
+	// as to compile and link it. If it returns an error, the source is not
+	// swapped in.
+	Compile func(source *Source) error
+
+	node   watch.ResolvedNode
+	source atomic.Pointer[Source]
+}
+
+// Source is an assembled shader source.
+type Source struct {
+	// Text is the source with every include inlined.
+	Text string
+
+	// Files lists the files that were inlined, root first, in the order they
+	// were first included.
+	Files []string
+
+	lines []origin
+}
+
+// origin records the file and line that a line of Text came from.
+type origin struct {
+	file string
+	line int
+}
+
+// Origin returns the file and line number that line n of Text, starting at 1,
+// was copied from, for mapping compiler diagnostics back to the original
+// files. ok is false if n is out of range.
+func (s *Source) Origin(n int) (file string, line int, ok bool) {
+	if n < 1 || n > len(s.lines) {
+		return "", 0, false
+	}
+	o := s.lines[n-1]
+	return o.file, o.line, true
+}
+
+// Source returns the most recently assembled source, or nil if the shader has
+// not been assembled successfully yet. It is safe to call concurrently with
+// reloads.
+func (s *Shader) Source() *Source {
+	return s.source.Load()
+}
+
+// Reload re-resolves the includes of Path, reassembles the source and passes
+// it to Compile.
+func (s *Shader) Reload() error {
+	if err := s.resolved().Updated(); err != nil {
+		return err
+	}
+	a := assembler{
+		fsys:     s.FS,
+		includes: &watch.IncludeResolver{Dirs: s.Dirs, FS: s.FS},
+		source:   new(Source),
+		once:     make(map[string]bool),
+	}
+	if err := a.include(s.Path, nil); err != nil {
+		return err
+	}
+	a.source.Text = a.text.String()
+	if s.Compile != nil {
+		if err := s.Compile(a.source); err != nil {
+			return err
+		}
+	}
+	s.source.Store(a.source)
+	return nil
+}
+
+// Paths implements watch.Node.
+func (s *Shader) Paths() []string {
+	return s.resolved().Paths()
+}
+
+// NotifyExisting implements watch.ExistingNotifier by returning true.
+func (s *Shader) NotifyExisting() bool {
+	return true
+}
+
+// Updated implements watch.Node by calling Reload.
+func (s *Shader) Updated() error {
+	return s.Reload()
+}
+
+// resolved configures the node to resolve the files included by Path.
+func (s *Shader) resolved() *watch.ResolvedNode {
+	s.node.Root = s.Path
+	s.node.FS = s.FS
+	s.node.Resolver = &watch.IncludeResolver{Dirs: s.Dirs, FS: s.FS}
+	return &s.node
+}
+
+var pragmaOnce = regexp.MustCompile(`^\s*#\s*pragma\s+once\b`)
+
+// assembler inlines the includes of a shader.
+type assembler struct {
+	fsys     fs.FS
+	includes *watch.IncludeResolver
+	source   *Source
+	text     strings.Builder
+	once     map[string]bool
+}
+
+// include appends the file at p, with its includes inlined. stack lists the
+// files currently being included, to detect cycles.
+func (a *assembler) include(p string, stack []string) error {
+	if slices.Contains(stack, p) {
+		return fmt.Errorf("watchshader: include cycle: %s -> %s", strings.Join(stack, " -> "), p)
+	}
+	if a.once[p] {
+		return nil
+	}
+	data, err := watch.ReadFile(a.fsys, p)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(a.source.Files, p) {
+		a.source.Files = append(a.source.Files, p)
+	}
+	stack = append(stack, p)
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if pragmaOnce.MatchString(line) {
+			a.once[p] = true
+			continue
+		}
+		name, dep, ok := a.includes.Include(p, line)
+		if !ok {
+			a.text.WriteString(line)
+			a.text.WriteByte('\n')
+			a.source.lines = append(a.source.lines, origin{p, n})
+			continue
+		}
+		if dep == "" {
+			return fmt.Errorf("%s:%d: watchshader: include %q not found: %w", p, n, name, fs.ErrNotExist)
+		}
+		if err := a.include(dep, stack); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
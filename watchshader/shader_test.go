@@ -0,0 +1,66 @@
+package watchshader_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchshader"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+func TestShader(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("shaders/lit.frag", []byte("#version 330\n#include \"common.glsl\"\n#include <light.glsl>\nvoid main() {}\n"))
+	fsys.WriteFile("shaders/common.glsl", []byte("#pragma once\nuniform float time;\n"))
+	fsys.WriteFile("lib/light.glsl", []byte("#include \"../shaders/common.glsl\"\nvec3 light() { return vec3(time); }\n"))
+	var compiled int
+	s := &watchshader.Shader{Path: "shaders/lit.frag", Dirs: []string{"lib"}, FS: fsys, Compile: func(src *watchshader.Source) error {
+		compiled++
+		if src.Text == "broken\n" {
+			return errors.New("syntax error")
+		}
+		return nil
+	}}
+	w := &watch.Watcher{FS: fsys}
+	w.Register(s)
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	want := "#version 330\nuniform float time;\nvec3 light() { return vec3(time); }\nvoid main() {}\n"
+	if got := s.Source().Text; got != want {
+		t.Errorf("got source %q, want %q", got, want)
+	}
+	if file, line, ok := s.Source().Origin(3); !ok || file != "lib/light.glsl" || line != 2 {
+		t.Errorf("line 3 came from %s:%d, want lib/light.glsl:2", file, line)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("shaders/common.glsl", []byte("#pragma once\nuniform float t;\n"))
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if compiled != 2 {
+		t.Errorf("compiled %d times, want 2", compiled)
+	}
+	want = "#version 330\nuniform float t;\nvec3 light() { return vec3(time); }\nvoid main() {}\n"
+	if got := s.Source().Text; got != want {
+		t.Errorf("include change should reassemble, got %q", got)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("shaders/lit.frag", []byte("broken\n"))
+	if _, errs := w.Scan(); len(errs) != 1 {
+		t.Errorf("expected a compile error, got %v", errs)
+	}
+	if got := s.Source().Text; got != want {
+		t.Errorf("compile error should keep the previous source, got %q", got)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("shaders/lit.frag", []byte("#include \"lit.frag\"\n"))
+	if _, errs := w.Scan(); len(errs) != 1 {
+		t.Errorf("expected an include cycle error, got %v", errs)
+	}
+}
@@ -0,0 +1,176 @@
+// Package watchtemplate provides watch.Node implementations that reparse
+// html/template and text/template files whenever they, or the templates
+// they include, change.
+package watchtemplate
+
+import (
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	texttemplate "text/template"
+
+	"github.com/chriscraws/watch"
+)
+
+// HTML is a watch.Node that watches an html/template root file and every
+// template it includes, as discovered by watch.TemplateResolver, and
+// reparses them whenever any of them changes. As with
+// template.ParseFiles, each file defines a template named after its base
+// name, and the root file's template is the one run by Execute. If the
+// templates fail to parse, the error is returned from Updated and the
+// previously parsed set keeps being served. The templates are first parsed
+// by the first Scan after the node is registered. An HTML must not be copied
+// after first use.
+type HTML struct {
+	// Root is the path of the root template.
+	Root string
+
+	// Dirs lists additional directories to look up included templates in.
+	Dirs []string
+
+	// FS is the file system templates are read from, as by watch.ReadFile.
+	FS fs.FS
+
+	// Funcs is added to the template's function map before parsing.
+	Funcs htmltemplate.FuncMap
+
+	node watch.ResolvedNode
+	tmpl atomic.Pointer[htmltemplate.Template]
+}
+
+// Template returns the most recently parsed template set, or nil if it has
+// not been parsed successfully yet. It is safe to call concurrently with
+// reloads.
+func (h *HTML) Template() *htmltemplate.Template {
+	return h.tmpl.Load()
+}
+
+// Reload resolves and parses the templates.
+func (h *HTML) Reload() error {
+	files, err := load(&h.node, h.Root, h.Dirs, h.FS)
+	if err != nil {
+		return err
+	}
+	tmpl := htmltemplate.New(files[0].name).Funcs(h.Funcs)
+	for i, f := range files {
+		part := tmpl
+		if i > 0 {
+			part = tmpl.New(f.name)
+		}
+		if _, err := part.Parse(f.text); err != nil {
+			return err
+		}
+	}
+	h.tmpl.Store(tmpl)
+	return nil
+}
+
+// Paths implements watch.Node.
+func (h *HTML) Paths() []string {
+	return resolved(&h.node, h.Root, h.Dirs, h.FS).Paths()
+}
+
+// NotifyExisting implements watch.ExistingNotifier by returning true.
+func (h *HTML) NotifyExisting() bool {
+	return true
+}
+
+// Updated implements watch.Node by calling Reload.
+func (h *HTML) Updated() error {
+	return h.Reload()
+}
+
+// Text is like HTML, for text/template.
+type Text struct {
+	// Root is the path of the root template.
+	Root string
+
+	// Dirs lists additional directories to look up included templates in.
+	Dirs []string
+
+	// FS is the file system templates are read from, as by watch.ReadFile.
+	FS fs.FS
+
+	// Funcs is added to the template's function map before parsing.
+	Funcs texttemplate.FuncMap
+
+	node watch.ResolvedNode
+	tmpl atomic.Pointer[texttemplate.Template]
+}
+
+// Template returns the most recently parsed template set, or nil if it has
+// not been parsed successfully yet.
+func (t *Text) Template() *texttemplate.Template {
+	return t.tmpl.Load()
+}
+
+// Reload resolves and parses the templates.
+func (t *Text) Reload() error {
+	files, err := load(&t.node, t.Root, t.Dirs, t.FS)
+	if err != nil {
+		return err
+	}
+	tmpl := texttemplate.New(files[0].name).Funcs(t.Funcs)
+	for i, f := range files {
+		part := tmpl
+		if i > 0 {
+			part = tmpl.New(f.name)
+		}
+		if _, err := part.Parse(f.text); err != nil {
+			return err
+		}
+	}
+	t.tmpl.Store(tmpl)
+	return nil
+}
+
+// Paths implements watch.Node.
+func (t *Text) Paths() []string {
+	return resolved(&t.node, t.Root, t.Dirs, t.FS).Paths()
+}
+
+// NotifyExisting implements watch.ExistingNotifier by returning true.
+func (t *Text) NotifyExisting() bool {
+	return true
+}
+
+// Updated implements watch.Node by calling Reload.
+func (t *Text) Updated() error {
+	return t.Reload()
+}
+
+type file struct {
+	name, text string
+}
+
+// resolved configures node to resolve the templates included by root.
+func resolved(node *watch.ResolvedNode, root string, dirs []string, fsys fs.FS) *watch.ResolvedNode {
+	node.Root = root
+	node.FS = fsys
+	node.Resolver = &watch.TemplateResolver{Dirs: dirs, FS: fsys}
+	return node
+}
+
+// load re-resolves the templates included by root and reads them, root
+// first.
+func load(node *watch.ResolvedNode, root string, dirs []string, fsys fs.FS) ([]file, error) {
+	node = resolved(node, root, dirs, fsys)
+	if err := node.Updated(); err != nil {
+		return nil, err
+	}
+	var files []file
+	for _, p := range node.Paths() {
+		data, err := watch.ReadFile(fsys, p)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(p)
+		if fsys == nil {
+			name = filepath.Base(p)
+		}
+		files = append(files, file{name: name, text: string(data)})
+	}
+	return files, nil
+}
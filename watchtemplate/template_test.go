@@ -0,0 +1,66 @@
+package watchtemplate_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchtemplate"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+func execute(t *testing.T, h *watchtemplate.HTML) string {
+	t.Helper()
+	var b strings.Builder
+	if err := h.Template().Execute(&b, "world"); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestHTML(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("index.html", []byte(`{{template "greeting.html" .}}!`))
+	fsys.WriteFile("partials/greeting.html", []byte(`hello, {{.}}`))
+	h := &watchtemplate.HTML{Root: "index.html", Dirs: []string{"partials"}, FS: fsys}
+	w := &watch.Watcher{FS: fsys}
+	w.Register(h)
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := execute(t, h); got != "hello, world!" {
+		t.Errorf("unexpected output %q", got)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("partials/greeting.html", []byte(`goodbye, {{.}}`))
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := execute(t, h); got != "goodbye, world!" {
+		t.Errorf("include change should reparse, got %q", got)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("partials/greeting.html", []byte(`{{.`))
+	if _, errs := w.Scan(); len(errs) != 1 {
+		t.Errorf("expected a parse error, got %v", errs)
+	}
+	if got := execute(t, h); got != "goodbye, world!" {
+		t.Errorf("parse error should keep previous templates, got %q", got)
+	}
+}
+
+func TestText(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", []byte(`{{upper .}}`))
+	tt := &watchtemplate.Text{Root: "a.txt", FS: fsys, Funcs: map[string]any{"upper": strings.ToUpper}}
+	if err := tt.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := tt.Template().Execute(&b, "x"); err != nil || b.String() != "X" {
+		t.Errorf("unexpected output %q, %v", b.String(), err)
+	}
+}
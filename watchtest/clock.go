@@ -0,0 +1,81 @@
+package watchtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/chriscraws/watch"
+)
+
+// Clock is a watch.Clock whose time only moves when Advance is called. The
+// zero value starts at the Unix epoch. Clock is safe for concurrent use.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*ticker
+}
+
+// Now implements watch.Clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.time()
+}
+
+func (c *Clock) time() time.Time {
+	if c.now.IsZero() {
+		c.now = time.Unix(0, 0).UTC()
+	}
+	return c.now
+}
+
+// Advance moves the clock forward by d, firing every ticker whose next tick
+// falls within the period. Like time.Ticker, a ticker whose channel is full
+// drops ticks.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.time().Add(d)
+	for _, t := range c.tickers {
+		for !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.d)
+		}
+	}
+}
+
+// NewTicker implements watch.Clock.
+func (c *Clock) NewTicker(d time.Duration) watch.Ticker {
+	if d <= 0 {
+		panic("watchtest: non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &ticker{clock: c, c: make(chan time.Time, 1), d: d, next: c.time().Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+type ticker struct {
+	clock *Clock
+	c     chan time.Time
+	d     time.Duration
+	next  time.Time
+}
+
+func (t *ticker) C() <-chan time.Time { return t.c }
+
+func (t *ticker) Stop() {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.tickers {
+		if other == t {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package watchtest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch/watchtest"
+)
+
+func TestClock(t *testing.T) {
+	clock := new(watchtest.Clock)
+	t0 := clock.Now()
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	clock.Advance(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker should not fire before its interval")
+	default:
+	}
+
+	clock.Advance(3 * time.Second)
+	if tick := <-ticker.C(); !tick.Equal(t0.Add(time.Second)) {
+		t.Errorf("first tick should be at 1s, got %v", tick.Sub(t0))
+	}
+	select {
+	case <-ticker.C():
+		t.Error("extra ticks should be dropped")
+	default:
+	}
+	if got := clock.Now().Sub(t0); got != 3500*time.Millisecond {
+		t.Errorf("clock should have advanced 3.5s, got %v", got)
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Second)
+	select {
+	case <-ticker.C():
+		t.Error("stopped ticker should not fire")
+	default:
+	}
+}
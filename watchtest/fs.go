@@ -0,0 +1,187 @@
+// Package watchtest provides helpers for testing code built on package
+// watch without touching the real file system.
+package watchtest
+
+import (
+	"io/fs"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+// FS is an in-memory fs.StatFS whose files and modification times can be
+// changed programmatically between calls to watch.Watcher.Scan. Writes stamp
+// files with the FS's own clock, which only moves when Advance is called, so
+// tests control exactly which scans see a change. Every file created has its
+// own identity, like an inode number, which is kept by WriteFile, Touch,
+// Chtimes, Chmod and Rename, so that watch.Watcher can detect renames. The
+// zero value is an empty file system whose clock starts at the Unix epoch.
+// FS is safe for concurrent use.
+type FS struct {
+	// Clock, if not nil, is the clock used to stamp files. Sharing it with
+	// watch.Watcher.Clock lets a test drive modification times, debounce
+	// periods and Run with a single call to Advance.
+	Clock *Clock
+
+	mu    sync.Mutex
+	files map[string]*fstest.MapFile
+	own   Clock
+	inode inode
+}
+
+// inode is the identity of a file, returned by the Sys method of the
+// fs.FileInfo of files in an FS.
+type inode uint64
+
+func (i inode) Inode() uint64 { return uint64(i) }
+
+func (f *FS) clock() *Clock {
+	if f.Clock != nil {
+		return f.Clock
+	}
+	return &f.own
+}
+
+// Now returns the current time of the FS clock.
+func (f *FS) Now() time.Time {
+	return f.clock().Now()
+}
+
+// Advance moves the FS clock forward by d.
+func (f *FS) Advance(d time.Duration) {
+	f.clock().Advance(d)
+}
+
+// WriteFile creates or replaces the file name with data, stamped with the
+// current FS time. Replacing a file keeps its identity.
+func (f *FS) WriteFile(name string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.files == nil {
+		f.files = make(map[string]*fstest.MapFile)
+	}
+	var sys any
+	mode := fs.FileMode(0o644)
+	if file, ok := f.files[name]; ok {
+		sys, mode = file.Sys, file.Mode
+	} else {
+		f.inode++
+		sys = f.inode
+	}
+	f.files[name] = &fstest.MapFile{Data: append([]byte(nil), data...), Mode: mode, ModTime: f.clock().Now(), Sys: sys}
+}
+
+// Rename moves the file oldname to newname, replacing any file there, and
+// keeps its contents, modification time and identity. It does nothing if
+// oldname does not exist.
+func (f *FS) Rename(oldname, newname string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if file, ok := f.files[oldname]; ok && oldname != newname {
+		f.files[newname] = file
+		delete(f.files, oldname)
+	}
+}
+
+// Remove removes the file name. It does nothing if the file does not exist.
+func (f *FS) Remove(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.files, name)
+}
+
+// Touch sets the modification time of the file name to the current FS time,
+// like touch(1), creating it empty if it does not exist.
+func (f *FS) Touch(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if file, ok := f.files[name]; ok {
+		copy := *file
+		copy.ModTime = f.clock().Now()
+		f.files[name] = &copy
+		return
+	}
+	if f.files == nil {
+		f.files = make(map[string]*fstest.MapFile)
+	}
+	f.inode++
+	f.files[name] = &fstest.MapFile{Mode: 0o644, ModTime: f.clock().Now(), Sys: f.inode}
+}
+
+// Chtimes sets the modification time of the file name without changing its
+// contents. It does nothing if the file does not exist.
+func (f *FS) Chtimes(name string, mtime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if file, ok := f.files[name]; ok {
+		copy := *file
+		copy.ModTime = mtime
+		f.files[name] = &copy
+	}
+}
+
+// Chmod sets the mode of the file name without changing its contents or
+// modification time. It does nothing if the file does not exist.
+func (f *FS) Chmod(name string, mode fs.FileMode) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if file, ok := f.files[name]; ok {
+		copy := *file
+		copy.Mode = mode
+		f.files[name] = &copy
+	}
+}
+
+// snapshot returns an immutable copy of the files, suitable for serving a
+// single operation.
+func (f *FS) snapshot() fstest.MapFS {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := make(fstest.MapFS, len(f.files))
+	for name, file := range f.files {
+		copy := *file
+		m[name] = &copy
+	}
+	return m
+}
+
+// Open implements fs.FS.
+func (f *FS) Open(name string) (fs.File, error) {
+	return f.snapshot().Open(name)
+}
+
+// Stat implements fs.StatFS.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	f.mu.Lock()
+	file, ok := f.files[name]
+	if ok {
+		copy := *file
+		f.mu.Unlock()
+		return fstest.MapFS{name: &copy}.Stat(name)
+	}
+	f.mu.Unlock()
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." && !f.hasPrefix(name+"/") {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.snapshot().Stat(name)
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return f.snapshot().ReadDir(name)
+}
+
+func (f *FS) hasPrefix(prefix string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name := range f.files {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
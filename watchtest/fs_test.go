@@ -0,0 +1,95 @@
+package watchtest_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch/watchtest"
+)
+
+func TestFS(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("dir/a.txt", []byte("a"))
+	t0 := fsys.Now()
+	fsys.Advance(time.Minute)
+	fsys.WriteFile("dir/b.txt", []byte("b"))
+
+	info, err := fsys.Stat("dir/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(t0.Add(time.Minute)) {
+		t.Errorf("mod time should follow the FS clock, got %v", info.ModTime())
+	}
+	if info, err := fsys.Stat("dir"); err != nil || !info.IsDir() {
+		t.Errorf("dir should be a directory, got %v, %v", info, err)
+	}
+	if _, err := fsys.Stat("missing"); !errorIsNotExist(err) {
+		t.Errorf("missing file should not exist, got %v", err)
+	}
+	entries, err := fs.ReadDir(fsys, "dir")
+	if err != nil || len(entries) != 2 {
+		t.Errorf("dir should have 2 entries, got %v, %v", entries, err)
+	}
+
+	fsys.Chtimes("dir/a.txt", t0.Add(time.Hour))
+	if info, _ := fsys.Stat("dir/a.txt"); !info.ModTime().Equal(t0.Add(time.Hour)) {
+		t.Errorf("Chtimes should set the mod time, got %v", info.ModTime())
+	}
+
+	fsys.Chmod("dir/a.txt", 0o755)
+	fsys.WriteFile("dir/a.txt", []byte("aa"))
+	if info, _ := fsys.Stat("dir/a.txt"); info.Mode() != 0o755 {
+		t.Errorf("Chmod should set the mode and WriteFile keep it, got %v", info.Mode())
+	}
+	fsys.Touch("dir/c.txt")
+	fsys.Advance(time.Second)
+	fsys.Touch("dir/a.txt")
+	if info, _ := fsys.Stat("dir/c.txt"); info.Size() != 0 {
+		t.Errorf("Touch should create an empty file, got size %d", info.Size())
+	}
+	if data, _ := fs.ReadFile(fsys, "dir/a.txt"); string(data) != "aa" {
+		t.Errorf("Touch should keep the contents, got %q", data)
+	}
+	if info, _ := fsys.Stat("dir/a.txt"); !info.ModTime().Equal(fsys.Now()) {
+		t.Errorf("Touch should set the mod time to now, got %v", info.ModTime())
+	}
+}
+
+func errorIsNotExist(err error) bool {
+	return err != nil && errors.Is(err, fs.ErrNotExist)
+}
+
+func TestFSRename(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", []byte("a"))
+	before, _ := fsys.Stat("a.txt")
+
+	fsys.Rename("a.txt", "b.txt")
+	if _, err := fsys.Stat("a.txt"); !errorIsNotExist(err) {
+		t.Errorf("old name should not exist after Rename, got %v", err)
+	}
+	after, err := fsys.Stat("b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Sys() != before.Sys() {
+		t.Errorf("Rename should keep the file identity, got %v and %v", before.Sys(), after.Sys())
+	}
+
+	fsys.WriteFile("b.txt", []byte("b"))
+	if info, _ := fsys.Stat("b.txt"); info.Sys() != before.Sys() {
+		t.Errorf("WriteFile should keep the identity of an existing file")
+	}
+	fsys.WriteFile("c.txt", []byte("c"))
+	if info, _ := fsys.Stat("c.txt"); info.Sys() == before.Sys() {
+		t.Errorf("new files should have their own identity")
+	}
+
+	fsys.Remove("b.txt")
+	if _, err := fsys.Stat("b.txt"); !errorIsNotExist(err) {
+		t.Errorf("removed file should not exist, got %v", err)
+	}
+}
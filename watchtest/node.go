@@ -0,0 +1,75 @@
+package watchtest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/chriscraws/watch"
+)
+
+// Node is a watch.Node that counts how often it is updated and records the
+// events of each update, for asserting on with ExpectUpdated. The zero value
+// watches no paths. Node is safe for concurrent use.
+type Node struct {
+	// Files lists the paths returned by Paths.
+	Files []string
+
+	// Watcher, if not nil, is the Watcher the node is registered with, used
+	// to record the events of each update.
+	Watcher *watch.Watcher
+
+	// Err, if not nil, is returned by Updated.
+	Err error
+
+	mu      sync.Mutex
+	updates int
+	events  []watch.Event
+}
+
+var _ watch.Node = (*Node)(nil)
+
+// NewNode returns a Node watching files.
+func NewNode(files ...string) *Node {
+	return &Node{Files: files}
+}
+
+// Paths implements watch.Node.
+func (n *Node) Paths() []string {
+	return n.Files
+}
+
+// Updated implements watch.Node.
+func (n *Node) Updated() error {
+	var events []watch.Event
+	if n.Watcher != nil {
+		events = n.Watcher.Events(n)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.updates++
+	n.events = events
+	return n.Err
+}
+
+// Updates returns the number of times Updated was called.
+func (n *Node) Updates() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.updates
+}
+
+// Events returns the events of the last update, if Watcher is set.
+func (n *Node) Events() []watch.Event {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.events
+}
+
+// ExpectUpdated reports an error on t unless node has been updated exactly
+// want times.
+func ExpectUpdated(t testing.TB, node *Node, want int) {
+	t.Helper()
+	if got := node.Updates(); got != want {
+		t.Errorf("node watching %v was updated %d times, want %d", node.Files, got, want)
+	}
+}
@@ -0,0 +1,48 @@
+package watchtest_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchtest"
+)
+
+func TestNode(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.Touch("a.txt")
+	w := &watch.Watcher{FS: fsys}
+	n := watchtest.NewNode("a.txt")
+	n.Watcher = w
+	w.Register(n)
+	w.Scan()
+	watchtest.ExpectUpdated(t, n, 0)
+
+	fsys.Advance(time.Second)
+	fsys.Touch("a.txt")
+	n.Err = errors.New("failed")
+	if _, errs := w.Scan(); len(errs) != 1 {
+		t.Errorf("Err should be returned from Updated, got %v", errs)
+	}
+	watchtest.ExpectUpdated(t, n, 1)
+	if want := []watch.Event{{Op: watch.Write, Path: "a.txt"}}; !slices.Equal(n.Events(), want) {
+		t.Errorf("events should be %v, got %v", want, n.Events())
+	}
+
+	tb := new(recordTB)
+	watchtest.ExpectUpdated(tb, n, 2)
+	if !tb.failed {
+		t.Error("ExpectUpdated should fail for a wrong count")
+	}
+}
+
+// recordTB records whether a test helper reported an error.
+type recordTB struct {
+	testing.TB
+	failed bool
+}
+
+func (tb *recordTB) Helper()               {}
+func (tb *recordTB) Errorf(string, ...any) { tb.failed = true }
@@ -0,0 +1,82 @@
+package watchtest
+
+import (
+	"io/fs"
+	"sync/atomic"
+	"time"
+
+	"github.com/chriscraws/watch"
+)
+
+// RemoteFS wraps a file system as if it were served by a remote backend,
+// such as an SFTP server, where every operation costs a round trip. It
+// implements watch.StatManyFS, answering a whole batch of stats in a single
+// round trip, and counts round trips so that tests can assert on them. It
+// also serves as the pattern for adapting a real remote client: Stat and
+// StatMany each make one request, and StatMany reports missing files with
+// errors matching fs.ErrNotExist.
+//
+//	remote := &watchtest.RemoteFS{FS: fsys}
+//	w := &watch.Watcher{FS: remote}
+//	w.Scan()
+//	// remote.RoundTrips() is 1, however many files w watches
+type RemoteFS struct {
+	// FS is the file system served. If nil, an empty FS is served.
+	FS fs.StatFS
+
+	// Latency, if not zero, is slept for on every round trip.
+	Latency time.Duration
+
+	trips atomic.Int64
+}
+
+var _ watch.StatManyFS = (*RemoteFS)(nil)
+
+// RoundTrips returns the number of round trips made so far.
+func (r *RemoteFS) RoundTrips() int {
+	return int(r.trips.Load())
+}
+
+func (r *RemoteFS) roundTrip() fs.StatFS {
+	r.trips.Add(1)
+	if r.Latency > 0 {
+		time.Sleep(r.Latency)
+	}
+	if r.FS == nil {
+		return new(FS)
+	}
+	return r.FS
+}
+
+// Open implements fs.FS.
+func (r *RemoteFS) Open(name string) (fs.File, error) {
+	return r.roundTrip().Open(name)
+}
+
+// Stat implements fs.StatFS.
+func (r *RemoteFS) Stat(name string) (fs.FileInfo, error) {
+	return r.roundTrip().Stat(name)
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (r *RemoteFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(r.roundTrip(), name)
+}
+
+// StatMany implements watch.StatManyFS with a single round trip.
+func (r *RemoteFS) StatMany(names []string) ([]fs.FileInfo, []error) {
+	fsys := r.roundTrip()
+	infos := make([]fs.FileInfo, len(names))
+	var errs []error
+	for i, name := range names {
+		info, err := fsys.Stat(name)
+		if err != nil {
+			if errs == nil {
+				errs = make([]error, len(names))
+			}
+			errs[i] = err
+		}
+		infos[i] = info
+	}
+	return infos, errs
+}
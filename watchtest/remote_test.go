@@ -0,0 +1,31 @@
+package watchtest_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/chriscraws/watch/watchtest"
+)
+
+func TestRemoteFS(t *testing.T) {
+	fsys := new(watchtest.FS)
+	fsys.WriteFile("a.txt", []byte("a"))
+	remote := &watchtest.RemoteFS{FS: fsys}
+	infos, errs := remote.StatMany([]string{"a.txt", "b.txt"})
+	if len(infos) != 2 || infos[0] == nil || infos[0].Size() != 1 {
+		t.Errorf("unexpected infos %v", infos)
+	}
+	if len(errs) != 2 || errs[0] != nil || !errors.Is(errs[1], fs.ErrNotExist) {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if _, errs := remote.StatMany([]string{"a.txt"}); errs != nil {
+		t.Errorf("errs should be nil when every stat succeeds, got %v", errs)
+	}
+	if _, err := remote.Stat("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := remote.RoundTrips(); got != 3 {
+		t.Errorf("expected 3 round trips, got %d", got)
+	}
+}
@@ -0,0 +1,84 @@
+// Package watchtls provides a watch.Node that reloads a TLS certificate and
+// key pair when they change on disk.
+package watchtls
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync/atomic"
+
+	"github.com/chriscraws/watch"
+)
+
+// Certificate is a watch.Node that watches a PEM encoded certificate and key
+// pair. When either file changes the pair is parsed again and, if it is
+// valid, served by GetCertificate. A pair that fails to load, for example
+// because only one of the files has been replaced so far, is reported from
+// Updated and the previous pair keeps being served. The pair is first loaded
+// by the first Scan after the Certificate is registered. A Certificate must
+// not be copied after first use.
+//
+//	cert := &watchtls.Certificate{CertFile: "cert.pem", KeyFile: "key.pem"}
+//	w.Register(cert)
+//	if _, errs := w.Scan(); errs != nil {
+//		log.Fatal(errs)
+//	}
+//	srv := &http.Server{TLSConfig: &tls.Config{GetCertificate: cert.GetCertificate}}
+type Certificate struct {
+	// CertFile and KeyFile are the paths of the certificate and private key.
+	CertFile string
+	KeyFile  string
+
+	// FS is the file system the files are read from, as by watch.ReadFile.
+	FS fs.FS
+
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// ErrNoCertificate is returned by GetCertificate if no pair has been loaded.
+var ErrNoCertificate = errors.New("watchtls: no certificate loaded")
+
+// Reload reads and parses the pair, and serves it if it is valid.
+func (c *Certificate) Reload() error {
+	certPEM, err := watch.ReadFile(c.FS, c.CertFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := watch.ReadFile(c.FS, c.KeyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("watchtls: %s: %w", c.CertFile, err)
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+// GetCertificate returns the most recently loaded pair. It has the signature
+// of tls.Config.GetCertificate and is safe to call concurrently with
+// reloads.
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := c.cert.Load(); cert != nil {
+		return cert, nil
+	}
+	return nil, ErrNoCertificate
+}
+
+// Paths implements watch.Node.
+func (c *Certificate) Paths() []string {
+	return []string{c.CertFile, c.KeyFile}
+}
+
+// NotifyExisting implements watch.ExistingNotifier by returning true.
+func (c *Certificate) NotifyExisting() bool {
+	return true
+}
+
+// Updated implements watch.Node by calling Reload.
+func (c *Certificate) Updated() error {
+	return c.Reload()
+}
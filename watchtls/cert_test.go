@@ -0,0 +1,100 @@
+package watchtls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/chriscraws/watch"
+	"github.com/chriscraws/watch/watchtest"
+	"github.com/chriscraws/watch/watchtls"
+)
+
+// writePair writes a self-signed certificate for name and its key to fsys.
+func writePair(t *testing.T, fsys *watchtest.FS, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys.WriteFile("cert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	fsys.WriteFile("key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func commonName(t *testing.T, c *watchtls.Certificate) string {
+	t.Helper()
+	cert, err := c.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertificate(t *testing.T) {
+	fsys := new(watchtest.FS)
+	c := &watchtls.Certificate{CertFile: "cert.pem", KeyFile: "key.pem", FS: fsys}
+	if _, err := c.GetCertificate(nil); !errors.Is(err, watchtls.ErrNoCertificate) {
+		t.Errorf("expected ErrNoCertificate, got %v", err)
+	}
+
+	writePair(t, fsys, "one")
+	w := &watch.Watcher{FS: fsys}
+	w.Register(c)
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := commonName(t, c); got != "one" {
+		t.Errorf("expected certificate one, got %s", got)
+	}
+
+	fsys.Advance(time.Second)
+	writePair(t, fsys, "two")
+	if _, errs := w.Scan(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if got := commonName(t, c); got != "two" {
+		t.Errorf("expected certificate two, got %s", got)
+	}
+
+	fsys.Advance(time.Second)
+	fsys.WriteFile("key.pem", []byte("garbage"))
+	if _, errs := w.Scan(); len(errs) != 1 {
+		t.Errorf("expected an error for an invalid key, got %v", errs)
+	}
+	if got := commonName(t, c); got != "two" {
+		t.Errorf("invalid pair should keep certificate two, got %s", got)
+	}
+	if err := c.Reload(); err == nil || errors.Unwrap(err) == nil {
+		t.Errorf("the parse error should be wrapped, got %v", err)
+	}
+	missing := &watchtls.Certificate{CertFile: "missing.pem", KeyFile: "key.pem", FS: fsys}
+	if err := missing.Reload(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist for a missing file, got %v", err)
+	}
+}